@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/enzosv/binalysis/model"
+	"github.com/pkg/errors"
+)
+
+func alertsPath(store, key string) string {
+	return fmt.Sprintf("%s/%s.alerts.json", store, key)
+}
+
+func loadAlerts(path string) model.Alerts {
+	var alerts model.Alerts
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return alerts
+	}
+	json.Unmarshal(content, &alerts)
+	return alerts
+}
+
+func persistAlerts(path string, alerts model.Alerts) error {
+	content, err := json.Marshal(alerts)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, content, 0644)
+}
+
+// publicIP is whether an address is on the internet rather than this machine or its network
+func publicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast())
+}
+
+// checkWebhook only allows http(s) urls of public hosts so alerts cannot reach the server's network
+func checkWebhook(webhook string) error {
+	u, err := url.Parse(webhook)
+	if err != nil {
+		return errors.Wrap(err, "webhook")
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("webhook must be an http or https url")
+	}
+	if u.Hostname() == "" {
+		return fmt.Errorf("webhook has no host")
+	}
+	ips, err := net.LookupIP(u.Hostname())
+	if err != nil {
+		return errors.Wrap(err, "webhook")
+	}
+	for _, ip := range ips {
+		if !publicIP(ip) {
+			return fmt.Errorf("webhook must be a public address")
+		}
+	}
+	return nil
+}
+
+// webhookClient refuses to connect to non public addresses, including hosts
+// that resolved to public ones when the webhook was set and redirects
+func webhookClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+				return fmt.Errorf("webhook %s is not a public address", host)
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: 30 * time.Second,
+		// no proxy so the dialer checks the webhook's own address
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, addr)
+			},
+		},
+	}
+}
+
+func alertID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return "alert:" + hex.EncodeToString(b)
+}
+
+// alertRequest is a rule to add or replace, or the settings of every rule
+type alertRequest struct {
+	model.AlertRule
+	Currency *string `json:"currency"`
+	Webhook  *string `json:"webhook"`
+}
+
+// AlertsHandler lists a user's alert rules, their state and recent events.
+// POST adds a rule or replaces the one with the same id, and sets currency and webhook when given.
+// DELETE ?id= removes a rule
+func AlertsHandler(store string, verbose bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Content-Type", "application/json")
+		key := r.Header.Get("X-API-Key")
+		if _, err := os.Stat(fmt.Sprintf("%s/%s.json", store, key)); key == "" || err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": "no report for this key. Update first"})
+			return
+		}
+		path := alertsPath(store, key)
+		defer lockFile(path)()
+		alerts := loadAlerts(path)
+		switch r.Method {
+		case http.MethodPost:
+			var req alertRequest
+			err := json.NewDecoder(r.Body).Decode(&req)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+				return
+			}
+			if req.Currency != nil {
+				currency := strings.ToLower(*req.Currency)
+				if currency != "" && !model.IsFiat(currency) {
+					w.WriteHeader(http.StatusBadRequest)
+					json.NewEncoder(w).Encode(map[string]string{"error": "unsupported currency " + currency})
+					return
+				}
+				if currency != alerts.Currency {
+					// prices are no longer comparable
+					alerts.States = nil
+				}
+				alerts.Currency = currency
+			}
+			if req.Webhook != nil {
+				webhook := strings.TrimSpace(*req.Webhook)
+				if webhook != "" {
+					err = checkWebhook(webhook)
+					if err != nil {
+						w.WriteHeader(http.StatusBadRequest)
+						json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+						return
+					}
+				}
+				if webhook != alerts.Webhook {
+					// events for the old webhook are not sent to the new one
+					alerts.Pending = nil
+				}
+				alerts.Webhook = webhook
+			}
+			if req.Kind != "" || req.Symbol != "" {
+				rule := req.AlertRule
+				rule.Symbol = strings.ToUpper(strings.TrimSpace(rule.Symbol))
+				err = rule.Validate()
+				if err != nil {
+					w.WriteHeader(http.StatusBadRequest)
+					json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+					return
+				}
+				if rule.ID == "" {
+					rule.ID = alertID()
+				}
+				var kept []model.AlertRule
+				for _, existing := range alerts.Rules {
+					if existing.ID != rule.ID {
+						kept = append(kept, existing)
+					}
+				}
+				alerts.Rules = append(kept, rule)
+				// a changed rule starts over
+				delete(alerts.States, rule.ID)
+			}
+		case http.MethodDelete:
+			id := r.URL.Query().Get("id")
+			var kept []model.AlertRule
+			for _, existing := range alerts.Rules {
+				if existing.ID != id {
+					kept = append(kept, existing)
+				}
+			}
+			if len(kept) == len(alerts.Rules) {
+				w.WriteHeader(http.StatusNotFound)
+				json.NewEncoder(w).Encode(map[string]string{"error": "no alert with id " + id})
+				return
+			}
+			alerts.Rules = kept
+			delete(alerts.States, id)
+		}
+		if r.Method != http.MethodGet {
+			err := persistAlerts(path, alerts)
+			if err != nil {
+				fmt.Println(err)
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+				return
+			}
+			if verbose {
+				fmt.Printf("%d alert rules\n", len(alerts.Rules))
+			}
+		}
+		json.NewEncoder(w).Encode(alerts)
+	}
+}
+
+// AlertMonitor checks every user's alert rules against current prices on an interval
+type AlertMonitor struct {
+	client   *http.Client
+	webhooks *http.Client
+	store    string
+	mappings []CoinMapping
+	// the server's own /latest. Its price cache is asked first
+	self     string
+	interval time.Duration
+	verbose  bool
+	// average buy prices of each user's payload. Only the prices are fetched on each check
+	bases map[string]model.AlertBasis
+}
+
+func NewAlertMonitor(store string, mappings []CoinMapping, self string, interval time.Duration, verbose bool) *AlertMonitor {
+	return &AlertMonitor{
+		client:   &http.Client{Timeout: 30 * time.Second},
+		webhooks: webhookClient(),
+		store:    store,
+		mappings: mappings,
+		self:     self,
+		interval: interval,
+		verbose:  verbose,
+		bases:    map[string]model.AlertBasis{},
+	}
+}
+
+// Run checks every interval until the process exits
+func (m *AlertMonitor) Run() {
+	for {
+		time.Sleep(m.interval)
+		paths, err := filepath.Glob(filepath.Join(m.store, "*.alerts.json"))
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		for _, path := range paths {
+			err = m.check(path)
+			if err != nil {
+				fmt.Println(errors.Wrap(err, filepath.Base(path)))
+			}
+		}
+	}
+}
+
+// check compares one user's rules with current prices, persists their state
+// and sends what changed. Events stay pending until the webhook accepts them
+func (m *AlertMonitor) check(path string) error {
+	unlock := lockFile(path)
+	alerts := loadAlerts(path)
+	unlock()
+	if len(alerts.Rules) < 1 && len(alerts.Pending) < 1 {
+		return nil
+	}
+	if len(alerts.Rules) > 0 {
+		payload := loadExisting(strings.TrimSuffix(path, ".alerts.json") + ".json")
+		mappings := mergeMappings(knownMappings, m.mappings, payload.Mappings)
+		basis, ok := m.bases[path]
+		if !ok || basis.Stale(payload, alerts.Currency) {
+			var err error
+			basis, err = model.EvaluateAlertBasis(m.client, payload, mappings, m.self, alerts.Currency)
+			if err != nil {
+				return err
+			}
+			m.bases[path] = basis
+		}
+		var symbols []string
+		for _, rule := range alerts.Rules {
+			symbols = append(symbols, rule.Symbol)
+		}
+		rows := basis.Rows(m.client, payload, mappings, m.self, symbols)
+
+		// rules may have changed while prices were fetched
+		unlock = lockFile(path)
+		if _, err := os.Stat(path); err != nil {
+			// deleted with the report
+			unlock()
+			return nil
+		}
+		alerts = loadAlerts(path)
+		if basis.Stale(payload, alerts.Currency) {
+			// the currency changed. Checked again next time
+			unlock()
+			return nil
+		}
+		events := alerts.Check(rows, time.Now())
+		err := persistAlerts(path, alerts)
+		unlock()
+		if err != nil {
+			return err
+		}
+		if m.verbose {
+			for _, e := range events {
+				fmt.Println(e.Message)
+			}
+		}
+	}
+	if alerts.Webhook == "" || len(alerts.Pending) < 1 {
+		return nil
+	}
+	err := m.notify(alerts.Webhook, alerts.Pending)
+	if err != nil {
+		// still pending
+		return err
+	}
+	unlock = lockFile(path)
+	defer unlock()
+	if _, err := os.Stat(path); err != nil {
+		return nil
+	}
+	sent := alerts.Pending
+	alerts = loadAlerts(path)
+	alerts.Sent(sent)
+	return persistAlerts(path, alerts)
+}
+
+// notify posts events to a webhook as {"events": [...]}
+func (m *AlertMonitor) notify(webhook string, events []model.AlertEvent) error {
+	body, err := json.Marshal(map[string]interface{}{"events": events})
+	if err != nil {
+		return err
+	}
+	res, err := m.webhooks.Post(webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "webhook")
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("webhook: %s", res.Status)
+	}
+	return nil
+}
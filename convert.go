@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	binance2 "github.com/adshao/go-binance/v2"
+	"github.com/pkg/errors"
+)
+
+// convert history can only be queried 30 days at a time
+const convertWindow = 30 * 24 * time.Hour
+
+// binance convert launch. No conversions before this
+var convertEpoch = time.Date(2020, 9, 1, 0, 0, 0, 0, time.UTC)
+
+// usd pegged assets are treated as the quote of a conversion.
+// Conversions between two other assets are both a disposal and an acquisition
+var stableQuotes = map[string]bool{
+	"USDT":  true,
+	"BUSD":  true,
+	"USDC":  true,
+	"TUSD":  true,
+	"USDP":  true,
+	"FDUSD": true,
+	"DAI":   true,
+}
+
+// exchange turns swapping fromAmount of from for toAmount of to into ledger transactions
+// priced at the implied rate
+func exchange(id string, t time.Time, from string, fromAmount float64, to string, toAmount float64, fee float64, feeAsset string) []Transaction {
+	sell := Transaction{
+		ID:       id + ":sell",
+		Source:   "binance",
+		Base:     from,
+		Quote:    to,
+		Time:     t,
+		Price:    toAmount / fromAmount,
+		Qty:      fromAmount,
+		Fee:      fee,
+		FeeAsset: feeAsset,
+	}
+	buy := Transaction{
+		ID:      id + ":buy",
+		Source:  "binance",
+		Base:    to,
+		Quote:   from,
+		Time:    t,
+		IsBuyer: true,
+		Price:   fromAmount / toAmount,
+		Qty:     toAmount,
+	}
+	if stableQuotes[to] {
+		return []Transaction{sell}
+	}
+	if stableQuotes[from] {
+		buy.Fee = fee
+		buy.FeeAsset = feeAsset
+		return []Transaction{buy}
+	}
+	return []Transaction{sell, buy}
+}
+
+// convert history is read this many trades at a time
+const convertPage = 1000
+
+// fetchConverts reads Binance Convert trades since the given time.
+// A window with more trades than a page is read again up to its oldest trade or from its newest,
+// whichever way the page is sorted
+func fetchConverts(ctx context.Context, client *binance2.Client, since time.Time, verbose bool) ([]Transaction, error) {
+	var txs []Transaction
+	seen := map[int64]bool{}
+	for start := since; start.Before(time.Now()); start = start.Add(convertWindow) {
+		from, to := start.UnixMilli(), start.Add(convertWindow).UnixMilli()
+		for {
+			history, err := client.NewConvertTradeHistoryService().
+				StartTime(from).
+				EndTime(to).
+				Limit(convertPage).
+				Do(ctx)
+			if err != nil {
+				return txs, errors.Wrap(err, "fetching convert history")
+			}
+			fresh := 0
+			for _, c := range history.List {
+				if seen[c.OrderId] {
+					continue
+				}
+				seen[c.OrderId] = true
+				fresh++
+				if c.OrderStatus != "SUCCESS" {
+					continue
+				}
+				amounts, err := parseAmounts(c.FromAmount, c.ToAmount)
+				if err != nil {
+					return txs, err
+				}
+				if amounts[0] <= 0 || amounts[1] <= 0 {
+					continue
+				}
+				id := fmt.Sprintf("binance:convert:%d", c.OrderId)
+				txs = append(txs, exchange(id, time.UnixMilli(c.CreateTime), c.FromAsset, amounts[0], c.ToAsset, amounts[1], 0, "")...)
+			}
+			// nothing new when every trade left shares a millisecond
+			if fresh == 0 || (!history.MoreData && len(history.List) < convertPage) {
+				break
+			}
+			first, last := history.List[0].CreateTime, history.List[len(history.List)-1].CreateTime
+			if first > last {
+				to = last
+			} else {
+				from = last
+			}
+		}
+	}
+	if verbose {
+		fmt.Printf("fetched %d convert transactions since %s\n", len(txs), since.Format("2006-01-02"))
+	}
+	return txs, nil
+}
+
+// fetchDust reads small balances converted to BNB.
+// Only the latest 100 conversions are available
+func fetchDust(ctx context.Context, client *binance2.Client, verbose bool) ([]Transaction, error) {
+	dust, err := client.NewListDustLogService().Do(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "fetching dust log")
+	}
+	var txs []Transaction
+	for _, d := range dust.UserAssetDribblets {
+		for _, detail := range d.UserAssetDribbletDetails {
+			amounts, err := parseAmounts(detail.Amount, detail.TransferedAmount, detail.ServiceChargeAmount)
+			if err != nil {
+				return txs, err
+			}
+			if amounts[0] <= 0 || amounts[1] <= 0 {
+				continue
+			}
+			id := fmt.Sprintf("binance:dust:%d:%s", d.TransID, detail.FromAsset)
+			// transfered amount is after the service charge. Trades are gross with the fee on top
+			txs = append(txs, exchange(id, time.UnixMilli(detail.OperateTime), detail.FromAsset, amounts[0], "BNB", amounts[1]+amounts[2], amounts[2], "BNB")...)
+		}
+	}
+	if verbose {
+		fmt.Printf("fetched %d dust transactions\n", len(txs))
+	}
+	return txs, nil
+}
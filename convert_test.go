@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	binance2 "github.com/adshao/go-binance/v2"
+)
+
+func TestFetchConvertsPages(t *testing.T) {
+	since := time.Now().Add(-24 * time.Hour)
+	type trade struct {
+		OrderID    int64  `json:"orderId"`
+		Status     string `json:"orderStatus"`
+		FromAsset  string `json:"fromAsset"`
+		FromAmount string `json:"fromAmount"`
+		ToAsset    string `json:"toAsset"`
+		ToAmount   string `json:"toAmount"`
+		CreateTime int64  `json:"createTime"`
+	}
+	var trades []trade
+	// more than two pages, a few to each millisecond
+	for i := int64(0); i < 2500; i++ {
+		trades = append(trades, trade{i, "SUCCESS", "USDT", "10", "BTC", "0.001", since.UnixMilli() + 1000 + i/4})
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/convert/tradeFlow") {
+			t.Errorf("unexpected call to %s", r.URL.Path)
+			return
+		}
+		q := r.URL.Query()
+		from, _ := strconv.ParseInt(q.Get("startTime"), 10, 64)
+		to, _ := strconv.ParseInt(q.Get("endTime"), 10, 64)
+		limit, _ := strconv.Atoi(q.Get("limit"))
+		var matched []trade
+		for _, c := range trades {
+			if c.CreateTime >= from && c.CreateTime <= to {
+				matched = append(matched, c)
+			}
+		}
+		// newest first
+		sort.Slice(matched, func(i, j int) bool {
+			return matched[i].CreateTime > matched[j].CreateTime
+		})
+		more := len(matched) > limit
+		if more {
+			matched = matched[:limit]
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"list": matched, "moreData": more})
+	}))
+	defer server.Close()
+	client := binance2.NewClient("key", "secret")
+	client.BaseURL = server.URL
+
+	txs, err := fetchConverts(context.Background(), client, since, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counted := map[string]int{}
+	for _, tx := range txs {
+		counted[tx.ID]++
+	}
+	if len(counted) != len(trades) {
+		t.Errorf("got %d conversions, want %d", len(counted), len(trades))
+	}
+	for id, n := range counted {
+		if n != 1 {
+			t.Errorf("%s read %d times", id, n)
+		}
+	}
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// CSVMapping maps csv column headers to trade fields
+type CSVMapping struct {
+	Time     string `json:"time"`
+	Pair     string `json:"pair"`
+	Side     string `json:"side"`
+	Price    string `json:"price"`
+	Qty      string `json:"qty"`
+	Fee      string `json:"fee"`
+	FeeAsset string `json:"fee_asset"`
+	// go time layout of the time column. Parsed as UTC
+	TimeLayout string `json:"time_layout"`
+	// separator between base and quote. Empty to match known quote assets
+	PairSeparator string `json:"pair_separator"`
+	// ledger source to merge into
+	Source string `json:"source"`
+}
+
+var csvPresets = map[string]CSVMapping{
+	// Binance "Export trade history"
+	// Date(UTC),Pair,Side,Price,Executed,Amount,Fee
+	// Executed and Fee are suffixed with their asset. e.g. 0.01BTC
+	"binance": {
+		Time:       "Date(UTC)",
+		Pair:       "Pair",
+		Side:       "Side",
+		Price:      "Price",
+		Qty:        "Executed",
+		Fee:        "Fee",
+		TimeLayout: "2006-01-02 15:04:05",
+		Source:     "binance",
+	},
+	// KuCoin trade history export
+	// tradeCreatedAt,orderId,symbol,side,price,size,funds,fee,liquidity,feeCurrency,orderType
+	"kucoin": {
+		Time:          "tradeCreatedAt",
+		Pair:          "symbol",
+		Side:          "side",
+		Price:         "price",
+		Qty:           "size",
+		Fee:           "fee",
+		FeeAsset:      "feeCurrency",
+		TimeLayout:    "2006-01-02 15:04:05",
+		PairSeparator: "-",
+		Source:        "kucoin",
+	},
+}
+
+// quote assets to try when a pair has no separator. Longest first
+var knownQuotes = []string{
+	"FDUSD", "USDT", "BUSD", "USDC", "TUSD", "USDP", "BIDR", "IDRT",
+	"DAI", "PAX", "UST", "VAI", "BTC", "ETH", "BNB", "EUR", "GBP",
+	"AUD", "BRL", "RUB", "TRY", "UAH", "NGN", "TRX", "XRP", "DOT",
+}
+
+// mappingFromQuery starts from a preset and overrides columns given in the query
+func mappingFromQuery(q map[string][]string) (CSVMapping, error) {
+	get := func(k string) string {
+		if v, ok := q[k]; ok && len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+	mapping := CSVMapping{TimeLayout: time.RFC3339}
+	if preset := get("preset"); preset != "" {
+		p, ok := csvPresets[strings.ToLower(preset)]
+		if !ok {
+			return mapping, fmt.Errorf("unknown preset %s", preset)
+		}
+		mapping = p
+	}
+	fields := map[string]*string{
+		"time":           &mapping.Time,
+		"pair":           &mapping.Pair,
+		"side":           &mapping.Side,
+		"price":          &mapping.Price,
+		"qty":            &mapping.Qty,
+		"fee":            &mapping.Fee,
+		"fee_asset":      &mapping.FeeAsset,
+		"time_layout":    &mapping.TimeLayout,
+		"pair_separator": &mapping.PairSeparator,
+		"source":         &mapping.Source,
+	}
+	for k, field := range fields {
+		if v := get(k); v != "" {
+			*field = v
+		}
+	}
+	if mapping.Time == "" || mapping.Pair == "" || mapping.Side == "" || mapping.Price == "" || mapping.Qty == "" {
+		return mapping, fmt.Errorf("time, pair, side, price and qty columns are required")
+	}
+	if mapping.Source == "" {
+		return mapping, fmt.Errorf("source is required")
+	}
+	return mapping, nil
+}
+
+// splitAmount separates a number from a trailing asset. e.g. 0.01BTC
+func splitAmount(value string) (float64, string, error) {
+	value = strings.ReplaceAll(strings.TrimSpace(value), ",", "")
+	i := 0
+	for i < len(value) {
+		c := value[i]
+		if strings.IndexByte("0123456789.-+", c) >= 0 {
+			i++
+			continue
+		}
+		// exponent only when followed by a digit so 1ETH is not 1E
+		if (c == 'e' || c == 'E') && i+1 < len(value) && strings.IndexByte("0123456789-+", value[i+1]) >= 0 {
+			i++
+			continue
+		}
+		break
+	}
+	if i == 0 {
+		return 0, "", fmt.Errorf("invalid amount %q", value)
+	}
+	amount, err := strconv.ParseFloat(value[:i], 64)
+	if err != nil {
+		return 0, "", err
+	}
+	return amount, strings.ToUpper(value[i:]), nil
+}
+
+func splitPair(pair, separator string) (string, string, error) {
+	pair = strings.ToUpper(strings.TrimSpace(pair))
+	if separator != "" {
+		parts := strings.Split(pair, strings.ToUpper(separator))
+		if len(parts) != 2 {
+			return "", "", fmt.Errorf("invalid pair %s", pair)
+		}
+		return parts[0], parts[1], nil
+	}
+	for _, quote := range knownQuotes {
+		if strings.HasSuffix(pair, quote) && len(pair) > len(quote) {
+			return strings.TrimSuffix(pair, quote), quote, nil
+		}
+	}
+	return "", "", fmt.Errorf("unknown quote asset in %s", pair)
+}
+
+// parseCSV reads trades using the mapping's columns
+func parseCSV(r io.Reader, mapping CSVMapping) ([]Transaction, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, errors.Wrap(err, "reading header")
+	}
+	columns := map[string]int{}
+	for i, h := range header {
+		// excel exports may start with a byte order mark
+		columns[strings.TrimPrefix(strings.TrimSpace(h), "\ufeff")] = i
+	}
+	for _, c := range []string{mapping.Time, mapping.Pair, mapping.Side, mapping.Price, mapping.Qty, mapping.Fee, mapping.FeeAsset} {
+		if _, ok := columns[c]; c != "" && !ok {
+			return nil, fmt.Errorf("missing column %s", c)
+		}
+	}
+	var txs []Transaction
+	occurrences := map[string]int{}
+	line := 1
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line++
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("line %d", line))
+		}
+		t, err := parseRow(row, columns, mapping)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("line %d", line))
+		}
+		// identical rows are separate fills. Their ids only need to be the same on every import
+		k := t.Key()
+		sum := sha1.Sum([]byte(k))
+		t.ID = importPrefix + hex.EncodeToString(sum[:8])
+		if n := occurrences[k]; n > 0 {
+			t.ID += fmt.Sprintf("-%d", n)
+		}
+		occurrences[k]++
+		txs = append(txs, t)
+	}
+	return txs, nil
+}
+
+func parseRow(row []string, columns map[string]int, mapping CSVMapping) (Transaction, error) {
+	get := func(c string) string {
+		if c == "" {
+			return ""
+		}
+		return strings.TrimSpace(row[columns[c]])
+	}
+	t := Transaction{Source: strings.ToLower(mapping.Source)}
+	var err error
+	t.Time, err = time.Parse(mapping.TimeLayout, get(mapping.Time))
+	if err != nil {
+		return t, err
+	}
+	t.Base, t.Quote, err = splitPair(get(mapping.Pair), mapping.PairSeparator)
+	if err != nil {
+		return t, err
+	}
+	switch strings.ToLower(get(mapping.Side)) {
+	case "buy":
+		t.IsBuyer = true
+	case "sell":
+		t.IsBuyer = false
+	default:
+		return t, fmt.Errorf("invalid side %s", get(mapping.Side))
+	}
+	t.Price, _, err = splitAmount(get(mapping.Price))
+	if err != nil {
+		return t, err
+	}
+	t.Qty, _, err = splitAmount(get(mapping.Qty))
+	if err != nil {
+		return t, err
+	}
+	if mapping.Fee != "" {
+		t.Fee, t.FeeAsset, err = splitAmount(get(mapping.Fee))
+		if err != nil {
+			return t, err
+		}
+	}
+	if mapping.FeeAsset != "" {
+		t.FeeAsset = strings.ToUpper(get(mapping.FeeAsset))
+	}
+	return t, nil
+}
+
+// importCSV merges the trades in r into the payload stored at path
+func importCSV(path string, r io.Reader, mapping CSVMapping) (int, int, error) {
+	txs, err := parseCSV(r, mapping)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer lockFile(path)()
+	payload := loadExisting(path)
+	// rows fetched before the ledger was kept are skipped
+	txs, untracked := payload.Untracked(txs)
+	imported, err := payload.Merge(txs)
+	if err != nil {
+		return 0, 0, err
+	}
+	if imported > 0 {
+		err = payload.Persist(path)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	return imported, len(txs) - imported + untracked, nil
+}
+
+func ImportHandler(store string, verbose bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Content-Type", "application/json")
+		key := r.Header.Get("X-API-Key")
+		if key == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "X-API-Key is required"})
+			return
+		}
+		mapping, err := mappingFromQuery(r.URL.Query())
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		path := fmt.Sprintf("%s/%s.json", store, key)
+		imported, skipped, err := importCSV(path, r.Body, mapping)
+		if err != nil {
+			fmt.Println(err)
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		if verbose {
+			fmt.Printf("imported %d trades. %d duplicates skipped\n", imported, skipped)
+		}
+		json.NewEncoder(w).Encode(map[string]int{"imported": imported, "skipped": skipped})
+	}
+}
+
+// importCommand handles `binalysis import -k key [flags] file.csv...`
+func importCommand(args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	store := fs.String("s", ".", "Directory for storing json. Relative to home")
+	key := fs.String("k", "", "Binance API key the report is stored under")
+	preset := fs.String("preset", "", "column preset: binance, kucoin")
+	source := fs.String("source", "", "source to merge into: binance, kucoin")
+	columns := map[string]*string{}
+	for _, c := range []string{"time", "pair", "side", "price", "qty", "fee", "fee_asset", "time_layout", "pair_separator"} {
+		columns[c] = fs.String(c, "", fmt.Sprintf("%s column or option. Overrides preset", c))
+	}
+	fs.Parse(args)
+	if *key == "" || fs.NArg() < 1 {
+		fmt.Println("usage: binalysis import -k key [-preset binance] file.csv...")
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+	q := map[string][]string{"preset": {*preset}, "source": {*source}}
+	for c, v := range columns {
+		q[c] = []string{*v}
+	}
+	mapping, err := mappingFromQuery(q)
+	if err != nil {
+		log.Fatal(err)
+	}
+	path := fmt.Sprintf("%s/%s.json", *store, *key)
+	for _, name := range fs.Args() {
+		file, err := os.Open(name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		imported, skipped, err := importCSV(path, file, mapping)
+		file.Close()
+		if err != nil {
+			log.Fatal(errors.Wrap(err, name))
+		}
+		fmt.Printf("%s: imported %d trades. %d duplicates skipped\n", name, imported, skipped)
+	}
+}
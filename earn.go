@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	binance2 "github.com/adshao/go-binance/v2"
+	"github.com/pkg/errors"
+)
+
+type earnRows[T any] struct {
+	Rows  []T `json:"rows"`
+	Total int `json:"total"`
+}
+
+type flexiblePosition struct {
+	Asset                  string `json:"asset"`
+	TotalAmount            string `json:"totalAmount"`
+	CumulativeTotalRewards string `json:"cumulativeTotalRewards"`
+}
+
+type lockedPosition struct {
+	Asset     string `json:"asset"`
+	Amount    string `json:"amount"`
+	RewardAmt string `json:"rewardAmt"`
+}
+
+type earnRedemption struct {
+	Asset  string `json:"asset"`
+	Amount string `json:"amount"`
+	Time   int64  `json:"time"`
+	Status string `json:"status"`
+}
+
+// earnPages fetches every page of a simple earn list endpoint
+func earnPages[T any](ctx context.Context, client *binance2.Client, endpoint string, params url.Values) ([]T, error) {
+	var rows []T
+	for current := 1; ; current++ {
+		if params == nil {
+			params = url.Values{}
+		}
+		params.Set("current", strconv.Itoa(current))
+		params.Set("size", "100")
+		var page earnRows[T]
+		err := signedGet(ctx, client, endpoint, params, &page)
+		if err != nil {
+			return rows, errors.Wrap(err, endpoint)
+		}
+		rows = append(rows, page.Rows...)
+		if len(page.Rows) < 100 || len(rows) >= page.Total {
+			return rows, nil
+		}
+	}
+}
+
+func parseAmounts(values ...string) ([]float64, error) {
+	var amounts []float64
+	for _, v := range values {
+		if v == "" {
+			amounts = append(amounts, 0)
+			continue
+		}
+		a, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, err
+		}
+		amounts = append(amounts, a)
+	}
+	return amounts, nil
+}
+
+// fetchEarn replaces the earn holdings of assets with their current simple earn positions
+// and adds redemptions since the last fetch
+func fetchEarn(ctx context.Context, client *binance2.Client, assets map[string]Asset, verbose bool) error {
+	flexible, err := earnPages[flexiblePosition](ctx, client, "/sapi/v1/simple-earn/flexible/position", nil)
+	if err != nil {
+		return err
+	}
+	locked, err := earnPages[lockedPosition](ctx, client, "/sapi/v1/simple-earn/locked/position", nil)
+	if err != nil {
+		return err
+	}
+
+	earned := map[string]Earn{}
+	for _, p := range flexible {
+		amounts, err := parseAmounts(p.TotalAmount, p.CumulativeTotalRewards)
+		if err != nil {
+			return err
+		}
+		e := earned[p.Asset]
+		e.Flexible += amounts[0]
+		e.Rewards += amounts[1]
+		earned[p.Asset] = e
+	}
+	for _, p := range locked {
+		amounts, err := parseAmounts(p.Amount, p.RewardAmt)
+		if err != nil {
+			return err
+		}
+		e := earned[p.Asset]
+		e.Locked += amounts[0]
+		e.Rewards += amounts[1]
+		earned[p.Asset] = e
+	}
+
+	for symbol, asset := range assets {
+		if _, ok := earned[symbol]; ok || asset.Earn.Holdings() > 0 {
+			e := earned[symbol]
+			e.Redeemed = asset.Earn.Redeemed
+			e.LatestRedemptionTime = asset.Earn.LatestRedemptionTime
+			asset.Earn = e
+			assets[symbol] = asset
+		}
+	}
+	for symbol, e := range earned {
+		if _, ok := assets[symbol]; !ok {
+			if verbose {
+				fmt.Println("new earn asset", symbol)
+			}
+			assets[symbol] = Asset{Earn: e}
+		}
+	}
+
+	// redemption history is kept for 6 months and queried 3 months at a time
+	since := time.Now().AddDate(0, -6, 0)
+	latest := map[string]int64{}
+	for _, endpoint := range []string{
+		"/sapi/v1/simple-earn/flexible/history/redemptionRecord",
+		"/sapi/v1/simple-earn/locked/history/redemptionRecord",
+	} {
+		for start := since; start.Before(time.Now()); start = start.AddDate(0, 3, 0) {
+			params := url.Values{}
+			params.Set("startTime", strconv.FormatInt(start.UnixMilli(), 10))
+			params.Set("endTime", strconv.FormatInt(start.AddDate(0, 3, 0).UnixMilli(), 10))
+			redemptions, err := earnPages[earnRedemption](ctx, client, endpoint, params)
+			if err != nil {
+				return err
+			}
+			for _, r := range redemptions {
+				asset, ok := assets[r.Asset]
+				if !ok || r.Time <= asset.Earn.LatestRedemptionTime || (r.Status != "" && r.Status != "PAID") {
+					continue
+				}
+				amount, err := strconv.ParseFloat(r.Amount, 64)
+				if err != nil {
+					return err
+				}
+				asset.Earn.Redeemed += amount
+				assets[r.Asset] = asset
+				if r.Time > latest[r.Asset] {
+					latest[r.Asset] = r.Time
+				}
+			}
+		}
+	}
+	// move the cursor only after both flexible and locked are counted
+	for symbol, t := range latest {
+		asset := assets[symbol]
+		asset.Earn.LatestRedemptionTime = t
+		assets[symbol] = asset
+	}
+	if verbose {
+		fmt.Printf("%d flexible and %d locked earn positions\n", len(flexible), len(locked))
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/enzosv/binalysis/model"
+)
+
+// ExportHandler serves the stored report as csv in ?format=koinly, cointracker or journal
+func ExportHandler(store string, verbose bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Content-Type", "application/json")
+		key := r.Header.Get("X-API-Key")
+		path := fmt.Sprintf("%s/%s.json", store, key)
+		if _, err := os.Stat(path); key == "" || err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": "no report for this key. Update first"})
+			return
+		}
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = "journal"
+		}
+		export, err := model.ParseExporter(format)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"binalysis-%s.csv\"", format))
+		err = export(loadExisting(path), w)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if verbose {
+			fmt.Printf("exported %s\n", format)
+		}
+	}
+}
+
+// exportCommand handles `binalysis export -k key [-format koinly] [-o file.csv]`
+func exportCommand(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	store := fs.String("s", ".", "Directory for storing json. Relative to home")
+	key := fs.String("k", "", "Binance API key the report is stored under")
+	format := fs.String("format", "journal", "koinly, cointracker or journal")
+	output := fs.String("o", "", "file to write. Standard output when empty")
+	fs.Parse(args)
+	if *key == "" {
+		fmt.Println("usage: binalysis export -k key [-format koinly] [-o file.csv]")
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+	export, err := model.ParseExporter(*format)
+	if err != nil {
+		log.Fatal(err)
+	}
+	path := fmt.Sprintf("%s/%s.json", *store, *key)
+	if _, err := os.Stat(path); err != nil {
+		log.Fatal(err)
+	}
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
+		w = file
+	}
+	err = export(loadExisting(path), w)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	binance2 "github.com/adshao/go-binance/v2"
+	"github.com/enzosv/binalysis/model"
+	"github.com/pkg/errors"
+)
+
+// fiat and p2p history can only be queried 30 days at a time
+const fiatWindow = 30 * 24 * time.Hour
+
+// card purchases launch. No fiat or p2p history before this
+var fiatEpoch = time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+
+type p2pOrder struct {
+	OrderNumber string `json:"orderNumber"`
+	TradeType   string `json:"tradeType"`
+	Asset       string `json:"asset"`
+	Fiat        string `json:"fiat"`
+	Amount      string `json:"amount"`
+	TotalPrice  string `json:"totalPrice"`
+	UnitPrice   string `json:"unitPrice"`
+	OrderStatus string `json:"orderStatus"`
+	CreateTime  int64  `json:"createTime"`
+	Commission  string `json:"commission"`
+}
+
+type p2pHistory struct {
+	Data  []p2pOrder `json:"data"`
+	Total int        `json:"total"`
+}
+
+// fiatPurchase is an acquisition of asset paid for in fiat.
+// The cost stays in the fiat so reports convert it to their currency at the rate on the day.
+// Fiat with no exchange rate is recorded as is and noted
+func fiatPurchase(id, method string, t time.Time, asset string, qty float64, fiat string, cost, fee float64) Transaction {
+	fiat = strings.ToUpper(fiat)
+	note := fmt.Sprintf("%s %g %s", method, cost+fee, fiat)
+	if !model.IsFiat(fiat) {
+		note += ". Cost not converted"
+	}
+	return Transaction{
+		ID:       id,
+		Source:   "binance",
+		Base:     asset,
+		Quote:    fiat,
+		Time:     t,
+		IsBuyer:  true,
+		Price:    cost / qty,
+		Qty:      qty,
+		Fee:      fee,
+		FeeAsset: fiat,
+		Note:     note,
+	}
+}
+
+// fetchFiatPayments reads crypto bought with a card or bank transfer since the given time
+func fetchFiatPayments(ctx context.Context, client *binance2.Client, since time.Time, verbose bool) ([]Transaction, error) {
+	var txs []Transaction
+	for start := since; start.Before(time.Now()); start = start.Add(fiatWindow) {
+		for page := int32(1); ; page++ {
+			history, err := client.NewFiatPaymentsHistoryService().
+				TransactionType(binance2.TransactionTypeBuy).
+				BeginTime(start.UnixMilli()).
+				EndTime(start.Add(fiatWindow).UnixMilli()).
+				Page(page).Rows(500).
+				Do(ctx)
+			if err != nil {
+				return txs, errors.Wrap(err, "fetching fiat payments")
+			}
+			for _, p := range history.Data {
+				if p.Status != "Completed" {
+					continue
+				}
+				amounts, err := parseAmounts(p.SourceAmount, p.ObtainAmount, p.TotalFee)
+				if err != nil {
+					return txs, err
+				}
+				if amounts[1] <= 0 {
+					continue
+				}
+				// source amount includes the fee
+				txs = append(txs, fiatPurchase(fmt.Sprintf("binance:fiat:payment:%s", p.OrderNo), "card", time.UnixMilli(p.CreateTime),
+					p.CryptoCurrency, amounts[1], p.FiatCurrency, amounts[0]-amounts[2], amounts[2]))
+			}
+			if len(history.Data) < 500 {
+				break
+			}
+		}
+	}
+	if verbose {
+		fmt.Printf("fetched %d fiat payments since %s\n", len(txs), since.Format("2006-01-02"))
+	}
+	return txs, nil
+}
+
+// fetchFiatDeposits reads fiat deposited to the account since the given time.
+// The fiat balance is acquired at its face value so it is valued at the rate on the day of the deposit
+func fetchFiatDeposits(ctx context.Context, client *binance2.Client, since time.Time, verbose bool) ([]Transaction, error) {
+	var txs []Transaction
+	for start := since; start.Before(time.Now()); start = start.Add(fiatWindow) {
+		for page := int32(1); ; page++ {
+			history, err := client.NewFiatDepositWithdrawHistoryService().
+				TransactionType(binance2.TransactionTypeDeposit).
+				BeginTime(start.UnixMilli()).
+				EndTime(start.Add(fiatWindow).UnixMilli()).
+				Page(page).Rows(500).
+				Do(ctx)
+			if err != nil {
+				return txs, errors.Wrap(err, "fetching fiat deposits")
+			}
+			for _, d := range history.Data {
+				if d.Status != "Successful" {
+					continue
+				}
+				amounts, err := parseAmounts(d.Amount, d.TotalFee)
+				if err != nil {
+					return txs, err
+				}
+				if amounts[0] <= 0 {
+					continue
+				}
+				// amount is what was credited after the fee
+				txs = append(txs, fiatPurchase(fmt.Sprintf("binance:fiat:deposit:%s", d.OrderNo), d.Method, time.UnixMilli(d.CreateTime),
+					d.FiatCurrency, amounts[0], d.FiatCurrency, amounts[0], amounts[1]))
+			}
+			if len(history.Data) < 500 {
+				break
+			}
+		}
+	}
+	if verbose {
+		fmt.Printf("fetched %d fiat deposits since %s\n", len(txs), since.Format("2006-01-02"))
+	}
+	return txs, nil
+}
+
+// fetchP2P reads crypto bought from other users since the given time
+func fetchP2P(ctx context.Context, client *binance2.Client, since time.Time, verbose bool) ([]Transaction, error) {
+	var txs []Transaction
+	for start := since; start.Before(time.Now()); start = start.Add(fiatWindow) {
+		for page := 1; ; page++ {
+			params := url.Values{}
+			params.Set("tradeType", "BUY")
+			params.Set("startTimestamp", strconv.FormatInt(start.UnixMilli(), 10))
+			params.Set("endTimestamp", strconv.FormatInt(start.Add(fiatWindow).UnixMilli(), 10))
+			params.Set("page", strconv.Itoa(page))
+			params.Set("rows", "100")
+			var history p2pHistory
+			err := signedGet(ctx, client, "/sapi/v1/c2c/orderMatch/listUserOrderHistory", params, &history)
+			if err != nil {
+				return txs, errors.Wrap(err, "fetching p2p orders")
+			}
+			for _, o := range history.Data {
+				if o.OrderStatus != "COMPLETED" {
+					continue
+				}
+				amounts, err := parseAmounts(o.Amount, o.TotalPrice)
+				if err != nil {
+					return txs, err
+				}
+				if amounts[0] <= 0 {
+					continue
+				}
+				tx := fiatPurchase(fmt.Sprintf("binance:p2p:%s", o.OrderNumber), "p2p", time.UnixMilli(o.CreateTime),
+					o.Asset, amounts[0], o.Fiat, amounts[1], 0)
+				// makers pay commission in the asset
+				if commission, err := strconv.ParseFloat(o.Commission, 64); err == nil && commission > 0 {
+					tx.Fee = commission
+					tx.FeeAsset = o.Asset
+				}
+				txs = append(txs, tx)
+			}
+			if len(history.Data) < 100 {
+				break
+			}
+		}
+	}
+	if verbose {
+		fmt.Printf("fetched %d p2p orders since %s\n", len(txs), since.Format("2006-01-02"))
+	}
+	return txs, nil
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFiatPurchase(t *testing.T) {
+	at := time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC)
+	tx := fiatPurchase("binance:fiat:payment:1", "card", at, "BTC", 0.5, "eur", 1000, 20)
+	if tx.Quote != "EUR" || tx.Price != 2000 || tx.Fee != 20 || tx.FeeAsset != "EUR" {
+		t.Errorf("got %g BTC at %g %s with a fee of %g %s, want 2000 EUR and 20 EUR", tx.Qty, tx.Price, tx.Quote, tx.Fee, tx.FeeAsset)
+	}
+	if strings.Contains(tx.Note, "not converted") {
+		t.Errorf("EUR noted as not converted: %s", tx.Note)
+	}
+
+	// no exchange rate but still recorded
+	tx = fiatPurchase("binance:p2p:2", "p2p", at, "USDT", 100, "NGN", 75000, 0)
+	if tx.Quote != "NGN" || tx.Price != 750 || !strings.Contains(tx.Note, "not converted") {
+		t.Errorf("got %g %s noted %q, want 750 NGN noted as not converted", tx.Price, tx.Quote, tx.Note)
+	}
+}
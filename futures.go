@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	binance2 "github.com/adshao/go-binance/v2"
+	"github.com/enzosv/binalysis/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	usdMargined  = "usdm"
+	coinMargined = "coinm"
+)
+
+type incomeRecord struct {
+	TranID     int64  `json:"tranId"`
+	Symbol     string `json:"symbol"`
+	Asset      string `json:"asset"`
+	Income     string `json:"income"`
+	IncomeType string `json:"incomeType"`
+	Time       int64  `json:"time"`
+}
+
+// income history is only kept for 3 months.
+// Starts at the cursor's millisecond since more records can share it
+func incomeSince(cursor int64) int64 {
+	since := time.Now().AddDate(0, -3, 0).UnixMilli()
+	if cursor > since {
+		return cursor
+	}
+	return since
+}
+
+func incomeKind(incomeType string) string {
+	switch incomeType {
+	case "REALIZED_PNL":
+		return model.FuturesPnlPayment
+	case "FUNDING_FEE":
+		return model.FundingPayment
+	case "COMMISSION":
+		return model.FuturesFeePayment
+	}
+	return model.FuturesOtherPayment
+}
+
+// addIncome adds records not seen before to the totals and returns them as payments.
+// Records at or before counted are only returned, they are in the totals from before payments were kept
+func addIncome(f *Futures, market string, counted int64, seen map[string]bool, records []incomeRecord) ([]Payment, error) {
+	var payments []Payment
+	for _, r := range records {
+		// a trade's pnl and commission share its tranId
+		id := fmt.Sprintf("futures:%s:%d:%s", market, r.TranID, r.IncomeType)
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		amount, err := strconv.ParseFloat(r.Income, 64)
+		if err != nil {
+			return payments, err
+		}
+		if r.IncomeType != "TRANSFER" {
+			payments = append(payments, Payment{
+				ID:     id,
+				Source: "futures:" + market,
+				Asset:  r.Asset,
+				Kind:   incomeKind(r.IncomeType),
+				Time:   time.UnixMilli(r.Time),
+				Amount: amount,
+				Note:   r.Symbol,
+			})
+		}
+		if r.Time <= counted {
+			continue
+		}
+		f.Income[r.Asset] = f.Income[r.Asset].Add(r.IncomeType, amount)
+		if r.Time > f.LatestIncomeTime[market] {
+			f.LatestIncomeTime[market] = r.Time
+		}
+	}
+	return payments, nil
+}
+
+// nextPage starts at the time of the last record. Records sharing it are fetched again and skipped by id.
+// Past it when the whole page shares it
+func nextPage(cursor int64, records []incomeRecord) int64 {
+	next := cursor
+	for _, r := range records {
+		if r.Time > next {
+			next = r.Time
+		}
+	}
+	if next == cursor {
+		return cursor + 1
+	}
+	return next
+}
+
+func fetchUSDMFutures(ctx context.Context, client *binance2.Client, f *Futures, seen map[string]bool, backfill bool) ([]Payment, error) {
+	var payments []Payment
+	c := binance2.NewFuturesClient(client.APIKey, client.SecretKey)
+	cursor, counted := f.LatestIncomeTime[usdMargined], int64(0)
+	if backfill {
+		cursor, counted = 0, cursor
+	}
+	for {
+		history, err := c.NewGetIncomeHistoryService().
+			StartTime(incomeSince(cursor)).
+			Limit(1000).
+			Do(ctx)
+		if err != nil {
+			return payments, errors.Wrap(err, "fetching usdm income")
+		}
+		var records []incomeRecord
+		for _, h := range history {
+			records = append(records, incomeRecord{h.TranID, h.Symbol, h.Asset, h.Income, h.IncomeType, h.Time})
+		}
+		page, err := addIncome(f, usdMargined, counted, seen, records)
+		payments = append(payments, page...)
+		if err != nil {
+			return payments, err
+		}
+		if len(history) < 1000 {
+			break
+		}
+		cursor = nextPage(incomeSince(cursor), records)
+	}
+	risks, err := c.NewGetPositionRiskService().Do(ctx)
+	if err != nil {
+		return payments, errors.Wrap(err, "fetching usdm positions")
+	}
+	for _, r := range risks {
+		amounts, err := parseAmounts(r.PositionAmt, r.EntryPrice, r.MarkPrice, r.UnRealizedProfit, r.Leverage)
+		if err != nil {
+			return payments, err
+		}
+		if amounts[0] == 0 {
+			continue
+		}
+		_, quote, err := splitPair(r.Symbol, "")
+		if err != nil {
+			quote = "USDT"
+		}
+		f.Positions = append(f.Positions, position(usdMargined, r.Symbol, r.PositionSide, quote, amounts))
+	}
+	return payments, nil
+}
+
+func fetchCOINMFutures(ctx context.Context, client *binance2.Client, f *Futures, seen map[string]bool, backfill bool) ([]Payment, error) {
+	var payments []Payment
+	cursor, counted := f.LatestIncomeTime[coinMargined], int64(0)
+	if backfill {
+		cursor, counted = 0, cursor
+	}
+	for {
+		params := url.Values{}
+		params.Set("startTime", strconv.FormatInt(incomeSince(cursor), 10))
+		params.Set("limit", "1000")
+		var records []incomeRecord
+		err := signedGetURL(ctx, client, "https://dapi.binance.com", "/dapi/v1/income", params, &records)
+		if err != nil {
+			return payments, errors.Wrap(err, "fetching coinm income")
+		}
+		page, err := addIncome(f, coinMargined, counted, seen, records)
+		payments = append(payments, page...)
+		if err != nil {
+			return payments, err
+		}
+		if len(records) < 1000 {
+			break
+		}
+		cursor = nextPage(incomeSince(cursor), records)
+	}
+	c := binance2.NewDeliveryClient(client.APIKey, client.SecretKey)
+	risks, err := c.NewGetPositionRiskService().Do(ctx)
+	if err != nil {
+		return payments, errors.Wrap(err, "fetching coinm positions")
+	}
+	for _, r := range risks {
+		amounts, err := parseAmounts(r.PositionAmt, r.EntryPrice, r.MarkPrice, r.UnRealizedProfit, r.Leverage)
+		if err != nil {
+			return payments, err
+		}
+		if amounts[0] == 0 {
+			continue
+		}
+		// e.g. BTCUSD_PERP, ETHUSD_220624
+		base := strings.SplitN(r.Symbol, "USD", 2)[0]
+		f.Positions = append(f.Positions, position(coinMargined, r.Symbol, r.PositionSide, base, amounts))
+	}
+	return payments, nil
+}
+
+func position(market, symbol, side, asset string, amounts []float64) Position {
+	return Position{
+		Market:        market,
+		Symbol:        symbol,
+		Side:          side,
+		Amount:        amounts[0],
+		EntryPrice:    amounts[1],
+		MarkPrice:     amounts[2],
+		UnrealizedPnl: amounts[3],
+		Leverage:      amounts[4],
+		Asset:         asset,
+	}
+}
+
+// fetchFutures adds income since the last fetch, records each income as a payment and replaces open positions
+func fetchFutures(ctx context.Context, client *binance2.Client, p *Payload, verbose bool) error {
+	existing := p.Futures
+	f := Futures{
+		Income:           map[string]Income{},
+		LatestIncomeTime: map[string]int64{},
+	}
+	for k, v := range existing.Income {
+		f.Income[k] = v
+	}
+	for k, v := range existing.LatestIncomeTime {
+		f.LatestIncomeTime[k] = v
+	}
+	// records already in the totals
+	seen := map[string]bool{}
+	for _, payment := range p.Payments {
+		seen[payment.ID] = true
+	}
+	usdm, errUSDM := fetchUSDMFutures(ctx, client, &f, seen, !p.PaymentsBackfilled)
+	coinm, errCOINM := fetchCOINMFutures(ctx, client, &f, seen, !p.PaymentsBackfilled)
+	if errUSDM != nil && errCOINM != nil {
+		// futures not enabled
+		return fmt.Errorf("%v. %v", errUSDM, errCOINM)
+	}
+	p.Futures = f
+	p.RecordPayments(append(usdm, coinm...))
+	if verbose {
+		fmt.Printf("%d open futures positions\n", len(f.Positions))
+	}
+	if errUSDM != nil {
+		return errUSDM
+	}
+	return errCOINM
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestIncomePages(t *testing.T) {
+	since := incomeSince(0)
+	var history []incomeRecord
+	// a trade's pnl and commission share a tranId, and pages end within a millisecond
+	for i := int64(0); i < 1500; i++ {
+		history = append(history,
+			incomeRecord{i, "BTCUSDT", "USDT", "1", "REALIZED_PNL", since + 10 + i/300},
+			incomeRecord{i, "BTCUSDT", "USDT", "-0.1", "COMMISSION", since + 10 + i/300})
+	}
+	// oldest first from start, 1000 at a time
+	page := func(start int64) []incomeRecord {
+		var records []incomeRecord
+		for _, r := range history {
+			if r.Time >= start && len(records) < 1000 {
+				records = append(records, r)
+			}
+		}
+		return records
+	}
+
+	f := &Futures{Income: map[string]Income{}, LatestIncomeTime: map[string]int64{}}
+	seen := map[string]bool{}
+	count := func() int {
+		n := 0
+		cursor := f.LatestIncomeTime[usdMargined]
+		for {
+			records := page(incomeSince(cursor))
+			payments, err := addIncome(f, usdMargined, 0, seen, records)
+			if err != nil {
+				t.Fatal(err)
+			}
+			n += len(payments)
+			if len(records) < 1000 {
+				return n
+			}
+			cursor = nextPage(incomeSince(cursor), records)
+		}
+	}
+	if n := count(); n != len(history) {
+		t.Errorf("got %d payments, want %d", n, len(history))
+	}
+	// more income later in the same millisecond
+	history = append(history, incomeRecord{1500, "BTCUSDT", "USDT", "1", "REALIZED_PNL", since + 14})
+	if n := count(); n != 1 {
+		t.Errorf("got %d new payments, want 1", n)
+	}
+	income := f.Income["USDT"]
+	if income.RealizedPnl != 1501 || income.Commission > -149.99 || income.Commission < -150.01 {
+		t.Errorf("got pnl %g and commission %g, want 1501 and -150", income.RealizedPnl, income.Commission)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/enzosv/binalysis/model"
+)
+
+// HistoryHandler serves daily portfolio value replayed from the ledger.
+// It is kept next to the report and only replayed again after an update, on a new day
+// or for another currency or cost basis method
+func HistoryHandler(store string, mappings []CoinMapping, self string, verbose bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Content-Type", "application/json")
+		req, ok := loadReport(w, r, store, mappings)
+		if !ok {
+			return
+		}
+		historyPath := fmt.Sprintf("%s/%s.history.json", store, req.key)
+		history := loadHistory(historyPath)
+		if history.Stale(req.payload, req.opts) {
+			var err error
+			history, err = model.EvaluateHistory(req.client, req.payload, req.mappings, self, req.opts)
+			if err != nil {
+				evaluationFailed(w, err)
+				return
+			}
+			err = persistHistory(historyPath, history)
+			if err != nil {
+				fmt.Println(err)
+			}
+			if verbose {
+				fmt.Printf("replayed %d days in %s\n", len(history.Snapshots), history.Currency)
+			}
+		}
+		json.NewEncoder(w).Encode(history)
+	}
+}
+
+// ReturnsHandler serves time and money-weighted returns over ?periods=ytd,1y,all
+func ReturnsHandler(store string, mappings []CoinMapping, self string, verbose bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Content-Type", "application/json")
+		req, ok := loadReport(w, r, store, mappings)
+		if !ok {
+			return
+		}
+		periods := model.Periods
+		if p := r.URL.Query().Get("periods"); p != "" {
+			periods = strings.Split(strings.ToLower(p), ",")
+		}
+		err := model.ValidatePeriods(periods)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		returns, err := model.EvaluateReturns(req.client, req.payload, req.mappings, self, req.opts, periods)
+		if err != nil {
+			evaluationFailed(w, err)
+			return
+		}
+		if verbose {
+			fmt.Printf("returns over %s\n", strings.Join(periods, ","))
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"currency": req.opts.Currency, "method": req.opts.Method, "returns": returns})
+	}
+}
+
+// BenchmarkHandler compares the portfolio against the same money held in ?benchmark=btc or a basket like btc:0.6,eth:0.4
+func BenchmarkHandler(store string, mappings []CoinMapping, self string, verbose bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Content-Type", "application/json")
+		req, ok := loadReport(w, r, store, mappings)
+		if !ok {
+			return
+		}
+		against := r.URL.Query().Get("benchmark")
+		if against == "" {
+			against = "btc"
+		}
+		benchmark, err := model.ParseBenchmark(against)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		comparison, err := model.EvaluateBenchmark(req.client, req.payload, req.mappings, self, req.opts, benchmark)
+		if err != nil {
+			evaluationFailed(w, err)
+			return
+		}
+		if verbose {
+			fmt.Printf("compared against %s\n", comparison.Benchmark)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"currency": req.opts.Currency, "method": req.opts.Method, "comparison": comparison})
+	}
+}
+
+// RiskHandler serves drawdown, volatility over ?window=30 days, Sharpe and Sortino over ?risk_free=0.04
+// and the share of the ?top=3 largest holdings
+func RiskHandler(store string, mappings []CoinMapping, self string, verbose bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Content-Type", "application/json")
+		req, ok := loadReport(w, r, store, mappings)
+		if !ok {
+			return
+		}
+		risk, err := riskOptions(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		measured, err := model.EvaluateRisk(req.client, req.payload, req.mappings, self, req.opts, risk)
+		if err != nil {
+			evaluationFailed(w, err)
+			return
+		}
+		if verbose {
+			fmt.Printf("measured risk over %d days\n", measured.Days)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"currency": req.opts.Currency, "method": req.opts.Method, "risk": measured})
+	}
+}
+
+// riskOptions reads window, top and risk_free from the query
+func riskOptions(r *http.Request) (model.RiskOptions, error) {
+	q := r.URL.Query()
+	var opts model.RiskOptions
+	var err error
+	if window := q.Get("window"); window != "" {
+		opts.Window, err = strconv.Atoi(window)
+		if err != nil {
+			return opts, fmt.Errorf("window must be a number of days")
+		}
+	}
+	if top := q.Get("top"); top != "" {
+		opts.Top, err = strconv.Atoi(top)
+		if err != nil {
+			return opts, fmt.Errorf("top must be a number")
+		}
+	}
+	if riskFree := q.Get("risk_free"); riskFree != "" {
+		opts.RiskFree, err = strconv.ParseFloat(riskFree, 64)
+		if err != nil {
+			return opts, fmt.Errorf("risk_free must be a number")
+		}
+	}
+	opts = opts.Defaults()
+	return opts, opts.Validate()
+}
+
+func loadHistory(path string) model.History {
+	var history model.History
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return history
+	}
+	json.Unmarshal(content, &history)
+	return history
+}
+
+func persistHistory(path string, history model.History) error {
+	content, err := json.Marshal(history)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, content, 0644)
+}
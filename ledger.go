@@ -0,0 +1,22 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/binance-exchange/go-binance"
+)
+
+func binanceTransaction(base, quote string, t *binance.Trade) Transaction {
+	return Transaction{
+		ID:       fmt.Sprintf("binance:%s%s:%d", base, quote, t.ID),
+		Source:   "binance",
+		Base:     base,
+		Quote:    quote,
+		Time:     t.Time,
+		IsBuyer:  t.IsBuyer,
+		Price:    t.Price,
+		Qty:      t.Qty,
+		Fee:      t.Commission,
+		FeeAsset: t.CommissionAsset,
+	}
+}
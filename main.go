@@ -11,6 +11,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/Kucoin/kucoin-go-sdk"
@@ -18,6 +19,7 @@ import (
 	binance2 "github.com/adshao/go-binance/v2"
 	common "github.com/adshao/go-binance/v2/common"
 	"github.com/binance-exchange/go-binance"
+	"github.com/enzosv/binalysis/model"
 	"github.com/gorilla/mux"
 	"github.com/pkg/errors"
 )
@@ -30,99 +32,75 @@ type PairsResponse struct {
 		Selling string `json:"q"`
 	} `json:"data"`
 }
-type Asset struct {
-	Balance                float64         `json:"balance"`
-	Pairs                  map[string]Pair `json:"pairs"`
-	LatestDistributionTime int64           `json:"latest_distribution_time"`
-	DistributionTotal      float64         `json:"distribution_total"`
-}
-
-type Pair struct {
-	BuyQty        float64            `json:"buy_qty"`
-	Cost          float64            `json:"cost"`
-	SellQty       float64            `json:"sell_qty"`
-	Revenue       float64            `json:"revenue"`
-	Fees          map[string]float64 `json:"fees"`
-	EarliestTrade *binance.Trade     `json:"earliest_trade"`
-	LatestTrade   *binance.Trade     `json:"latest_trade"`
-}
-
-type Payload struct {
-	LastUpdate time.Time        `json:"last_update"`
-	Assets     map[string]Asset `json:"binance"`
-	Kucoin     map[string]Asset `json:"kucoin"`
-}
 
-func (p Payload) persist(path string) error {
-	p.LastUpdate = time.Now()
-	file, err := json.Marshal(p)
-	if err != nil {
-		err = errors.Wrap(err, "encoding")
-		return err
-	}
-	// TODO: encrypt
-	err = ioutil.WriteFile(path, file, 0644)
-	if err != nil {
-		err = errors.Wrap(err, "persisting")
-		return err
-	}
-	return nil
-}
-
-func (a Asset) compute(selling string, trades []*binance.Trade) Asset {
-	pair := Pair{}
-	if value, ok := a.Pairs[selling]; ok {
-		pair = value
-	}
-	earliest := pair.EarliestTrade
-	latest := pair.LatestTrade
-	fees := map[string]float64{}
-	for _, t := range trades {
-		fees[t.CommissionAsset] += t.Commission
-		if t.IsBuyer {
-			pair.BuyQty += t.Qty
-			pair.Cost += t.Price * t.Qty
-		}
-		if !t.IsBuyer {
-			pair.SellQty += t.Qty
-			pair.Revenue += t.Price * t.Qty
-		}
-		if earliest == nil {
-			earliest = t
-		}
-		if latest == nil {
-			latest = t
-		}
-		if earliest.Time.Unix() > t.Time.Unix() {
-			earliest = t
-		}
-		if latest.Time.Unix() < t.Time.Unix() {
-			latest = t
-		}
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		importCommand(os.Args[2:])
+		return
 	}
-	pair.EarliestTrade = earliest
-	pair.LatestTrade = latest
-	pair.Fees = fees
-	new := a
-	if new.Pairs == nil {
-		new.Pairs = map[string]Pair{}
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		exportCommand(os.Args[2:])
+		return
 	}
-	new.Pairs[selling] = pair
-	return new
-}
-
-func main() {
 	port := flag.Int("p", 8080, "port to use")
 	store := flag.String("s", ".", "Directory for storing json. Relative to home")
 	verbose := flag.Bool("v", false, "print info logs")
+	rpcURL := flag.String("rpc", "", "Ethereum JSON-RPC endpoint for wallet tracking. e.g. http://localhost:8545")
+	native := flag.String("native", "ETH", "Symbol of the rpc chain's native asset")
+	tokens := flag.String("tokens", "", "JSON file of ERC-20 tokens to track. [{\"symbol\", \"address\", \"decimals\"}]")
+	maxScan := flag.Uint64("rpc-scan", 10000, "Most blocks to scan for wallet transfers at a time")
+	startBlock := flag.Uint64("rpc-start", 0, "Block to scan new wallets from unless they set their own. The last rpc-scan blocks when 0")
+	coins := flag.String("coins", "", "JSON file of symbol to coingecko id overrides. [{\"exchange\", \"symbol\", \"coin_id\"}]")
+	coingeckoURL := flag.String("coingecko", "https://api.coingecko.com/api/v3", "Coingecko api or a mirror of it for the price cache")
+	priceInterval := flag.Duration("price-interval", 5*time.Minute, "How often cached prices are refreshed. 0 to let browsers call coingecko directly")
+	alertInterval := flag.Duration("alert-interval", 5*time.Minute, "How often price alerts are checked. 0 to turn them off")
 	flag.Parse()
+	mappings, err := loadMappings(*coins)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var rpc *RPC
+	if *rpcURL != "" {
+		var err error
+		rpc, err = NewRPC(*rpcURL, *native, *tokens, *maxScan, *startBlock)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
 	r := mux.NewRouter()
 	r.HandleFunc("/ping", func(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintf(w, "binalysis pong")
 	})
 	r.HandleFunc("/latest", LatestHandler(*store, *verbose)).Methods("GET")
-	r.HandleFunc("/update", UpdateHandler(*store, *verbose)).Methods("POST")
+	r.HandleFunc("/update", UpdateHandler(*store, rpc, *verbose)).Methods("POST")
 	r.HandleFunc("/del", DeleteHandler(*store, *verbose)).Methods("DELETE")
+	r.HandleFunc("/import", ImportHandler(*store, *verbose)).Methods("POST")
+	r.HandleFunc("/manual", ManualHandler(*store, *verbose)).Methods("GET", "POST")
+	r.HandleFunc("/manual/{id}", ManualHandler(*store, *verbose)).Methods("PUT", "DELETE")
+	r.HandleFunc("/wallets", WalletsHandler(*store, *verbose)).Methods("GET", "POST")
+	r.HandleFunc("/mappings", MappingsHandler(*store, mappings, *verbose)).Methods("GET", "POST", "DELETE")
+	self := fmt.Sprintf("http://localhost:%d/latest", *port)
+	r.HandleFunc("/report", ReportHandler(*store, mappings, self, *verbose)).Methods("GET")
+	r.HandleFunc("/history", HistoryHandler(*store, mappings, self, *verbose)).Methods("GET")
+	r.HandleFunc("/returns", ReturnsHandler(*store, mappings, self, *verbose)).Methods("GET")
+	r.HandleFunc("/benchmark", BenchmarkHandler(*store, mappings, self, *verbose)).Methods("GET")
+	r.HandleFunc("/risk", RiskHandler(*store, mappings, self, *verbose)).Methods("GET")
+	r.HandleFunc("/export", ExportHandler(*store, *verbose)).Methods("GET")
+	r.HandleFunc("/tax", TaxHandler(*store, mappings, self, *verbose)).Methods("GET")
+	r.HandleFunc("/statement", StatementHandler(*store, mappings, self, *verbose)).Methods("GET")
+	r.HandleFunc("/alerts", AlertsHandler(*store, *verbose)).Methods("GET", "POST", "DELETE")
+	if *priceInterval > 0 {
+		cache, err := NewPriceCache(*coingeckoURL, *store, *priceInterval, *verbose)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go cache.Run()
+		r.HandleFunc("/prices", PricesHandler(cache, *verbose)).Methods("GET")
+		r.HandleFunc("/coins", CoinsHandler(cache)).Methods("GET")
+	}
+	if *alertInterval > 0 {
+		go NewAlertMonitor(*store, mappings, self, *alertInterval, *verbose).Run()
+	}
 	r.PathPrefix("/").Handler(gziphandler.GzipHandler(http.FileServer(http.Dir("./web/"))))
 	// r.PathPrefix("/").Handler(http.FileServer(http.Dir("./web/")))
 	if *verbose {
@@ -143,7 +121,7 @@ func LatestHandler(store string, verbose bool) http.HandlerFunc {
 	}
 }
 
-func UpdateHandler(store string, verbose bool) http.HandlerFunc {
+func UpdateHandler(store string, rpc *RPC, verbose bool) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		w.Header().Set("Access-Control-Allow-Origin", "*")
@@ -151,9 +129,12 @@ func UpdateHandler(store string, verbose bool) http.HandlerFunc {
 		// This is not secure
 		key := r.Header.Get("X-API-Key")
 		path := fmt.Sprintf("%s/%s.json", store, key)
+		// held until the update is saved so edits made meanwhile wait instead of being overwritten
+		unlock := lockFile(path)
 		existing := loadExisting(path)
 		nextAvailable := existing.LastUpdate.Add(time.Minute * 1)
 		if time.Now().Unix() < nextAvailable.Unix() {
+			unlock()
 			response := map[string]string{"error": fmt.Sprintf("Updated recently. Try again at %s", nextAvailable.Add(time.Minute).Format("3:04PM"))}
 			w.WriteHeader(http.StatusTooManyRequests)
 			json.NewEncoder(w).Encode(response)
@@ -181,6 +162,7 @@ func UpdateHandler(store string, verbose bool) http.HandlerFunc {
 
 		payload, err := fetchBalances(b, existing, verbose)
 		if err != nil {
+			unlock()
 			response := map[string]string{"error": err.Error()}
 			fmt.Println(err)
 			w.WriteHeader(http.StatusInternalServerError)
@@ -189,8 +171,9 @@ func UpdateHandler(store string, verbose bool) http.HandlerFunc {
 		}
 
 		// save payload
-		payload.persist(path)
+		payload.Persist(path)
 		if err != nil {
+			unlock()
 			response := map[string]string{"error": err.Error()}
 			fmt.Println(err)
 			w.WriteHeader(http.StatusInternalServerError)
@@ -199,6 +182,7 @@ func UpdateHandler(store string, verbose bool) http.HandlerFunc {
 		}
 
 		go func(ctx context.Context, client *binance2.Client, path string) {
+			defer unlock()
 			start := time.Now().Unix()
 			if kkey != "" && ksecret != "" && kpass != "" {
 				// TODO: async
@@ -224,21 +208,100 @@ func UpdateHandler(store string, verbose bool) http.HandlerFunc {
 					fmt.Println(err)
 					return
 				}
-				kt, err := fetchKucoinTrades(ks, klast+1, time.Now().UnixMilli(), 1, kb, verbose)
+				payload.Kucoin = kb
+				ktxs, err := fetchKucoinTrades(ks, klast+1, time.Now().UnixMilli(), 1, kb, nil, verbose)
 				if err != nil {
 					fmt.Println(err)
 					return
 				}
-				payload.Kucoin = kt
-				payload.persist(path)
+				// kept in the ledger so imports and exports see them
+				_, err = payload.Merge(ktxs)
+				if err != nil {
+					fmt.Println(err)
+					return
+				}
+				fetched := time.Now()
+				ktransfers, err := fetchKucoinTransfers(ks, payload.TransfersSince("kucoin", binanceEpoch), verbose)
+				if err != nil {
+					fmt.Println(err)
+				} else {
+					payload.FetchedTransfers("kucoin", fetched)
+				}
+				payload.RecordTransfers(ktransfers)
+				payload.Persist(path)
+			}
+
+			fetched := time.Now()
+			transfers, err := fetchBinanceTransfers(context.Background(), client, payload.TransfersSince("binance", binanceEpoch), verbose)
+			if err != nil {
+				fmt.Println(err)
+			} else {
+				payload.FetchedTransfers("binance", fetched)
+			}
+			payload.RecordTransfers(transfers)
+			if rpc != nil {
+				err = rpc.sync(&payload, verbose)
+				if err != nil {
+					fmt.Println(err)
+				}
+			}
+			payload.MatchTransfers()
+			// received coins keep the cost basis they had before the transfer
+			_, err = payload.CarryCostBasis()
+			if err != nil {
+				fmt.Println(err)
+			}
+			err = fetchEarn(context.Background(), client, payload.Binance, verbose)
+			if err != nil {
+				fmt.Println(err)
+			}
+			// conversions never appear in mytrades
+			converts, err := fetchConverts(context.Background(), client, payload.LatestLedger("binance:convert:", convertEpoch), verbose)
+			if err != nil {
+				fmt.Println(err)
+			}
+			dust, err := fetchDust(context.Background(), client, verbose)
+			if err != nil {
+				fmt.Println(err)
+			}
+			_, err = payload.Merge(append(converts, dust...))
+			if err != nil {
+				fmt.Println(err)
+			}
+			// fiat purchases never appear in mytrades either
+			payments, err := fetchFiatPayments(context.Background(), client, payload.LatestLedger("binance:fiat:payment:", fiatEpoch), verbose)
+			if err != nil {
+				fmt.Println(err)
+			}
+			deposits, err := fetchFiatDeposits(context.Background(), client, payload.LatestLedger("binance:fiat:deposit:", fiatEpoch), verbose)
+			if err != nil {
+				fmt.Println(err)
+			}
+			p2p, err := fetchP2P(context.Background(), client, payload.LatestLedger("binance:p2p:", fiatEpoch), verbose)
+			if err != nil {
+				fmt.Println(err)
+			}
+			_, err = payload.Merge(append(append(payments, deposits...), p2p...))
+			if err != nil {
+				fmt.Println(err)
+			}
+			err = fetchMargin(context.Background(), client, &payload, verbose)
+			if err != nil {
+				fmt.Println(err)
+			}
+			err = fetchFutures(context.Background(), client, &payload, verbose)
+			if err != nil {
+				fmt.Println(err)
 			}
+			payload.Persist(path)
 
-			_, err := update(ctx, b, client, payload, path, verbose)
+			_, err = update(ctx, b, client, &payload, path, verbose)
 			if err != nil {
 				fmt.Println(err)
 				return
 			}
-			payload.persist(path)
+			payload.PaymentsBackfilled = true
+			payload.Persist(path)
 
 			if verbose {
 				fmt.Printf("%s done after %d seconds\n", r.RemoteAddr, time.Now().Unix()-start)
@@ -253,8 +316,10 @@ func DeleteHandler(store string, verbose bool) http.HandlerFunc {
 		key := r.Header.Get("X-API-Key")
 		// no extra auth. anyone with key can delete
 		path := fmt.Sprintf("%s/%s.json", store, key)
-
+		// a running update would save the report again
+		unlock := lockFile(path)
 		err := os.Remove(path)
+		unlock()
 		if err != nil {
 			if os.IsNotExist(err) {
 				http.Error(w, "File not found", http.StatusNotFound)
@@ -264,72 +329,23 @@ func DeleteHandler(store string, verbose bool) http.HandlerFunc {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		// derived from the report. Missing until first requested
+		os.Remove(fmt.Sprintf("%s/%s.history.json", store, key))
+		unlock = lockFile(alertsPath(store, key))
+		os.Remove(alertsPath(store, key))
+		unlock()
 		response := map[string]bool{"deleted": true}
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(response)
 	}
 }
 
-// func fetchLocked(ctx context.Context, client binance2.Client) error {
-// 	r2, err := client.NewGetSavingsFixedAndActivityPositionService().
-// 		Asset("LUNA").
-// 		ProjectId("CLUNA90DAYSS001").
-// 		Status("HOLDING").
-// 		Do(ctx)
-// 	if err != nil {
-// 		return err
-// 	}
-// 	for _, p := range r2 {
-// 		fmt.Println(p)
-// 	}
-// 	res, err := client.NewGetLendingPurchaseRecordService().
-// 		LendingType("CUSTOMIZED_FIXED").
-// 		Size(100).Current(1).
-// 		StartTime(time.Now().Unix() - 2592000).
-// 		EndTime(time.Now().Unix() - 3600).
-// 		Do(ctx)
-// 	if err != nil {
-// 		return err
-// 	}
-// 	for _, p := range res {
-// 		fmt.Println(p.Asset, p.Amount)
-// 	}
-// 	// return
-// 	res2, err := client.NewListSavingsFixedAndActivityProductsService().
-// 		// Asset("LUNA").
-// 		Type("CUSTOMIZED_FIXED").Status("ALL").
-// 		Current(1).
-// 		Size(100).
-// 		Do(ctx)
-// 	if err != nil {
-// 		return err
-// 	}
-// 	for _, s := range res2 {
-// 		// if !strings.Contains(s.Asset, "LUNA") {
-// 		// 	fmt.Println(s.ProjectId)
-// 		// 	continue
-// 		// }
-// 		fmt.Println(s.Asset, s.ProjectId)
-// 		r2, err := client.NewGetSavingsFixedAndActivityPositionService().
-// 			Status("HOLDING").
-// 			// Asset(s.Asset).ProjectId(s.ProjectId).
-// 			// Asset("LUNA").ProjectId("Luna*30").
-// 			Asset("LUNA").ProjectId("CLUNA30DAYSS001").
-// 			Do(ctx)
-// 		if err != nil {
-// 			return err
-// 		}
-// 		for _, p := range r2 {
-// 			fmt.Println(p)
-// 		}
-// 	}
-// 	return nil
-// }
-
-func fetchDistributions(ctx context.Context, client *binance2.Client, symbol string, total float64, start int64, verbose bool) (int64, float64, error) {
+// fetchDistributions reads an asset's distributions after start in milliseconds.
+// Every one still in the history when start is 0
+func fetchDistributions(ctx context.Context, client *binance2.Client, symbol string, start int64, verbose bool) ([]Payment, error) {
 	request := client.NewAssetDividendService().Asset(symbol).Limit(500)
 	if start > 0 {
-		request = request.StartTime(start + 1).EndTime(time.Now().Unix())
+		request = request.StartTime(start + 1).EndTime(time.Now().UnixMilli())
 	}
 	distributions, err := request.Do(ctx)
 	if err != nil {
@@ -341,59 +357,66 @@ func fetchDistributions(ctx context.Context, client *binance2.Client, symbol str
 					fmt.Printf("[%s] Waiting for limit to refresh distributions\n", symbol)
 				}
 				time.Sleep(time.Minute)
-				return fetchDistributions(ctx, client, symbol, total, start, verbose)
+				return fetchDistributions(ctx, client, symbol, start, verbose)
 			}
 		}
 		err = errors.Wrap(err, fmt.Sprintf("[%s] fetching distributions", symbol))
 		fmt.Println(err)
-		return 0, 0, err
+		return nil, err
 	}
-	rows := *distributions.Rows
-	newTotal := total
-	for _, d := range rows {
+	var payments []Payment
+	for _, d := range *distributions.Rows {
 		amount, err := strconv.ParseFloat(d.Amount, 64)
 		if err != nil {
 			fmt.Println(err)
-			return 0, 0, err
+			return nil, err
 		}
-		newTotal += amount
-	}
-	if len(rows) >= 500 {
-		// TODO: fetch more distributions
-		// return fetchDistributions(ctx, client, symbol, newTotal, rows[0].Time+1)
-	}
+		kind := model.DistributionPayment
+		// earn interest and staking rewards are paid out as distributions
+		info := strings.ToLower(d.Info)
+		if strings.Contains(info, "earn") || strings.Contains(info, "staking") || strings.Contains(info, "savings") {
+			kind = model.RewardPayment
+		}
+		payments = append(payments, Payment{
+			ID:     fmt.Sprintf("binance:distribution:%s:%d", symbol, d.ID),
+			Source: "binance",
+			Asset:  symbol,
+			Kind:   kind,
+			Time:   time.UnixMilli(d.Time),
+			Amount: amount,
+			Note:   d.Info,
+		})
+	}
+	// TODO: fetch more than 500 distributions
 	if verbose {
-		fmt.Printf("[%s] %.2f distributed\n", symbol, newTotal)
-	}
-	if len(rows) < 1 {
-		return start, newTotal, nil
+		fmt.Printf("[%s] %d distributions\n", symbol, len(payments))
 	}
-	return rows[0].Time, newTotal, nil
+	return payments, nil
 }
 
 func fetchBalances(b binance.Binance, existing Payload, verbose bool) (Payload, error) {
-	// TODO: fetch withdraws, desposits, dust conversions, transfers
-	// TODO: fetch earn distributions and balances
-	// https://www.reddit.com/r/binance/comments/k6b1r7/accessing_earn_with_api/
-	// https://www.binance.com/bapi/earn/v1/private/lending/daily/token/position?pageIndex=2&pageSize=20
-	// https://www.binance.com/bapi/capital/v1/private/streamer/trade/get-user-trades
-	// https://binance-docs.github.io/apidocs/spot/en/#lending-account-user_data
 	account, err := b.Account(binance.AccountRequest{
 		RecvWindow: 60 * time.Second,
 		Timestamp:  time.Now(),
 	})
 	if err != nil {
-		return Payload{time.Now(), existing.Assets, nil}, err
+		payload := existing
+		payload.LastUpdate = time.Now()
+		return payload, err
 	}
 
 	// zero out balances
 	assets := map[string]Asset{}
-	for i, bal := range existing.Assets {
+	for i, bal := range existing.Binance {
 		new := bal
 		new.Balance = 0
 		assets[i] = new
 	}
 
+	listed := map[string]bool{}
+	for _, bal := range account.Balances {
+		listed[bal.Asset] = true
+	}
 	for _, bal := range account.Balances {
 
 		value := bal.Free + bal.Locked
@@ -402,22 +425,34 @@ func fetchBalances(b binance.Binance, existing Payload, verbose bool) (Payload,
 		// continue
 		// }
 
-		symbol := strings.TrimPrefix(bal.Asset, "LD")
+		symbol := bal.Asset
+		// flexible savings are listed as LD + asset. LDO is not one
+		savings := strings.HasPrefix(symbol, "LD") && listed[strings.TrimPrefix(symbol, "LD")]
+		if savings {
+			symbol = strings.TrimPrefix(symbol, "LD")
+		}
 		var new Asset
 		if existing_asset, ok := assets[symbol]; ok {
 			new = existing_asset
-			new.Balance = value
 		} else {
 			new = Asset{}
-			new.Balance = value
 			if verbose {
 				fmt.Println("new asset", symbol)
 			}
 		}
+		if savings {
+			// replaced by simple earn positions when those are fetched
+			new.Earn.Flexible = value
+		} else {
+			new.Balance = value
+		}
 		assets[symbol] = new
 	}
 
-	return Payload{time.Now(), assets, nil}, nil
+	payload := existing
+	payload.LastUpdate = time.Now()
+	payload.Binance = assets
+	return payload, nil
 }
 
 func fetchPairs() (PairsResponse, error) {
@@ -438,13 +473,13 @@ func fetchPairs() (PairsResponse, error) {
 	return pairs, nil
 }
 
-func update(ctx context.Context, b binance.Binance, client *binance2.Client, payload Payload, path string, verbose bool) (map[string]Asset, error) {
+func update(ctx context.Context, b binance.Binance, client *binance2.Client, payload *Payload, path string, verbose bool) (map[string]Asset, error) {
 	// persists while waiting
 	pairs, err := fetchPairs()
 	if err != nil {
 		return nil, err
 	}
-	bals := payload.Assets
+	bals := payload.Binance
 	var total int = 0
 	// TODO: prioritize balances that have changed and are > 0
 	for k, existing := range bals {
@@ -462,6 +497,10 @@ func update(ctx context.Context, b binance.Binance, client *binance2.Client, pay
 				// get latest fromID from persisted to save on requests
 				// +1 because mytrades is inclusive on fromid
 				fromID = value.LatestTrade.ID + 1
+				if value.LastID >= fromID {
+					// latest trade was imported
+					fromID = value.LastID + 1
+				}
 			}
 			for {
 				// keep fetching trades against product until error or < 1 trades returned
@@ -479,8 +518,9 @@ func update(ctx context.Context, b binance.Binance, client *binance2.Client, pay
 						go func(bals map[string]Asset, path string, total int, verbose bool) {
 							// ok to ignore persist error. It will be retried
 							// persist despite nothing new to update last_update
-							p := Payload{time.Now(), bals, payload.Kucoin}
-							err := p.persist(path)
+							p := *payload
+							p.Binance = bals
+							err := p.Persist(path)
 							if err != nil {
 								return
 							}
@@ -516,10 +556,32 @@ func update(ctx context.Context, b binance.Binance, client *binance2.Client, pay
 			// update asset with fetched product trades
 			// ignore products with no trades
 			if len(trades) > 0 {
-				new = new.compute(p.Selling, trades)
+				// skip trades that were already imported
+				var txs []Transaction
+				for _, t := range trades {
+					txs = append(txs, binanceTransaction(k, p.Selling, t))
+				}
+				recorded := map[string]bool{}
+				for _, t := range payload.Record(txs) {
+					recorded[t.ID] = true
+				}
+				var fresh []*model.Trade
+				for i, t := range trades {
+					if recorded[txs[i].ID] {
+						trade := model.Trade(*t)
+						fresh = append(fresh, &trade)
+					}
+				}
+				if len(fresh) > 0 {
+					new = new.Compute(p.Selling, fresh)
+				}
+				if pair, ok := new.Pairs[p.Selling]; ok && pair.LastID < fromID-1 {
+					pair.LastID = fromID - 1
+					new.Pairs[p.Selling] = pair
+				}
 			}
 		}
-		if new.Pairs == nil {
+		if new.Pairs == nil && new.Earn.Holdings() <= 0 {
 			// remove untraded
 			if verbose {
 				fmt.Printf("%s untraded. Removing\n", k)
@@ -531,10 +593,23 @@ func update(ctx context.Context, b binance.Binance, client *binance2.Client, pay
 			fmt.Printf("[%s] fetching distributions\n", k)
 		}
 		// fetch distributions
-		dlatest, dtotal, err := fetchDistributions(context.Background(), client, k, existing.DistributionTotal, existing.LatestDistributionTime, verbose)
+		since := existing.LatestDistributionTime
+		if !payload.PaymentsBackfilled {
+			// kept one by one from before they were only totalled
+			since = 0
+		}
+		distributions, err := fetchDistributions(context.Background(), client, k, since, verbose)
 		if err == nil {
-			new.DistributionTotal = dtotal
-			new.LatestDistributionTime = dlatest
+			for _, d := range distributions {
+				// older ones are already in the total
+				if t := d.Time.UnixMilli(); t > existing.LatestDistributionTime {
+					new.DistributionTotal += d.Amount
+					if t > new.LatestDistributionTime {
+						new.LatestDistributionTime = t
+					}
+				}
+			}
+			payload.RecordPayments(distributions)
 		}
 		// update asset map after fetching all trades and distributions for an asset
 		bals[k] = new
@@ -545,15 +620,34 @@ func update(ctx context.Context, b binance.Binance, client *binance2.Client, pay
 	return bals, err
 }
 
+// fileLocks keep handlers and background work from overwriting each other's changes to a file
+var fileLocks = struct {
+	sync.Mutex
+	paths map[string]*sync.Mutex
+}{paths: map[string]*sync.Mutex{}}
+
+// lockFile locks a user's file and returns its unlock
+func lockFile(path string) func() {
+	fileLocks.Lock()
+	lock, ok := fileLocks.paths[path]
+	if !ok {
+		lock = &sync.Mutex{}
+		fileLocks.paths[path] = lock
+	}
+	fileLocks.Unlock()
+	lock.Lock()
+	return lock.Unlock
+}
+
 func loadExisting(path string) Payload {
 	content, err := ioutil.ReadFile(path)
 	if err != nil {
-		return Payload{time.Time{}, map[string]Asset{}, map[string]Asset{}}
+		return Payload{LastUpdate: time.Time{}, Binance: map[string]Asset{}, Kucoin: map[string]Asset{}}
 	}
 	var payload Payload
 	json.Unmarshal(content, &payload)
-	if payload.Assets == nil {
-		payload.Assets = map[string]Asset{}
+	if payload.Binance == nil {
+		payload.Binance = map[string]Asset{}
 	}
 	if payload.Kucoin == nil {
 		payload.Kucoin = map[string]Asset{}
@@ -610,7 +704,9 @@ func getPrice(o *kucoin.OrderModel, qty float64) (float64, error) {
 	return price, nil
 }
 
-func fetchKucoinTrades(s *kucoin.ApiService, startAt, endAt, page int64, assets map[string]Asset, verbose bool) (map[string]Asset, error) {
+// fetchKucoinTrades adds filled orders from startAt until endAt to txs.
+// Once a range has none left it goes on with orders older than anything on record
+func fetchKucoinTrades(s *kucoin.ApiService, startAt, endAt, page int64, assets map[string]Asset, txs []Transaction, verbose bool) ([]Transaction, error) {
 	if verbose {
 		fmt.Printf("fetching more kucoin trades from %d page %d\n", startAt, page)
 	}
@@ -630,10 +726,6 @@ func fetchKucoinTrades(s *kucoin.ApiService, startAt, endAt, page int64, assets
 	if err != nil {
 		return nil, err
 	}
-	newAssets := assets
-	if newAssets == nil {
-		newAssets = map[string]Asset{}
-	}
 	earliest := endAt
 	for _, o := range os {
 		if o.CreatedAt < earliest {
@@ -659,67 +751,44 @@ func fetchKucoinTrades(s *kucoin.ApiService, startAt, endAt, page int64, assets
 			return nil, err
 		}
 		symbols := strings.Split(o.Symbol, "-")
-		asset := Asset{}
-		if value, ok := assets[symbols[0]]; ok {
-			asset = value
-		}
-		if asset.Pairs == nil {
-			asset.Pairs = map[string]Pair{}
-		}
-		pair := Pair{}
-		pair.Fees = map[string]float64{}
-		pair.Fees[o.FeeCurrency] = fee
-		if value, ok := asset.Pairs[symbols[1]]; ok {
-			pair = value
-		}
-		if _, ok := pair.Fees[o.FeeCurrency]; ok {
-			pair.Fees[o.FeeCurrency] += fee
-		}
-		if o.Side == "buy" {
-			pair.BuyQty += qty
-			pair.Cost += (price * qty)
-		}
-		if o.Side == "sell" {
-			pair.SellQty += qty
-			pair.Revenue += (price * qty)
-		}
 		if verbose {
 			fmt.Printf("%s %.2f %s for %.2f at %.2f on %d\n", o.Side, qty, o.Symbol, (price * qty), price, o.CreatedAt)
 		}
-		t := time.UnixMilli(o.CreatedAt)
-		trade := binance.Trade{}
-		// trade.ID = o.Id
-		trade.Time = t
-		trade.IsBuyer = o.Side == "buy"
-		trade.Price = price
-		trade.Qty = qty
-		trade.Commission = fee
-		trade.CommissionAsset = o.FeeCurrency
-		if pair.LatestTrade == nil || pair.LatestTrade.Time.UnixMilli() < o.CreatedAt {
-			pair.LatestTrade = &trade
-		}
-		if pair.EarliestTrade == nil || pair.EarliestTrade.Time.UnixMilli() > o.CreatedAt {
-			pair.EarliestTrade = &trade
-		}
-
-		pair.Fees[o.FeeCurrency] += fee
-		asset.Pairs[symbols[1]] = pair
-		newAssets[symbols[0]] = asset
+		txs = append(txs, Transaction{
+			ID:       "kucoin:" + o.Id,
+			Source:   "kucoin",
+			Base:     symbols[0],
+			Quote:    symbols[1],
+			Time:     time.UnixMilli(o.CreatedAt),
+			IsBuyer:  o.Side == "buy",
+			Price:    price,
+			Qty:      qty,
+			Fee:      fee,
+			FeeAsset: o.FeeCurrency,
+		})
 	}
 	if pd.TotalPage > page {
-		return fetchKucoinTrades(s, startAt, endAt, page+1, newAssets, verbose)
+		return fetchKucoinTrades(s, startAt, endAt, page+1, assets, txs, verbose)
 	}
 	// fetch older than earliest
 	if len(os) > 0 {
 		for _, a := range assets {
 			for _, p := range a.Pairs {
+				if p.EarliestTrade == nil {
+					continue
+				}
 				t := p.EarliestTrade.Time.UnixMilli()
 				if t < earliest {
 					earliest = t
 				}
 			}
 		}
-		return fetchKucoinTrades(s, 0, earliest-1, 1, newAssets, verbose)
+		for _, t := range txs {
+			if t.Time.UnixMilli() < earliest {
+				earliest = t.Time.UnixMilli()
+			}
+		}
+		return fetchKucoinTrades(s, 0, earliest-1, 1, assets, txs, verbose)
 	}
-	return newAssets, nil
+	return txs, nil
 }
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+func manualID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return manualSource + ":" + hex.EncodeToString(b)
+}
+
+func decodeTransaction(r *http.Request) (Transaction, error) {
+	var t Transaction
+	err := json.NewDecoder(r.Body).Decode(&t)
+	if err != nil {
+		return t, err
+	}
+	t.Source = manualSource
+	t.Base = strings.ToUpper(strings.TrimSpace(t.Base))
+	t.Quote = strings.ToUpper(strings.TrimSpace(t.Quote))
+	t.FeeAsset = strings.ToUpper(strings.TrimSpace(t.FeeAsset))
+	return t, t.Validate()
+}
+
+func ManualHandler(store string, verbose bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Content-Type", "application/json")
+		key := r.Header.Get("X-API-Key")
+		if key == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "X-API-Key is required"})
+			return
+		}
+		path := fmt.Sprintf("%s/%s.json", store, key)
+		defer lockFile(path)()
+		payload := loadExisting(path)
+		id := mux.Vars(r)["id"]
+
+		switch r.Method {
+		case http.MethodGet:
+			json.NewEncoder(w).Encode(payload.ManualTransactions())
+			return
+		case http.MethodPost:
+			t, err := decodeTransaction(r)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+				return
+			}
+			t.ID = manualID()
+			payload.Ledger = append(payload.Ledger, t)
+		case http.MethodPut, http.MethodDelete:
+			index := -1
+			for i, t := range payload.Ledger {
+				if t.ID == id && t.Source == manualSource {
+					index = i
+					break
+				}
+			}
+			if index < 0 {
+				w.WriteHeader(http.StatusNotFound)
+				json.NewEncoder(w).Encode(map[string]string{"error": "transaction not found"})
+				return
+			}
+			if r.Method == http.MethodDelete {
+				payload.Ledger = append(payload.Ledger[:index], payload.Ledger[index+1:]...)
+				break
+			}
+			t, err := decodeTransaction(r)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+				return
+			}
+			t.ID = id
+			payload.Ledger[index] = t
+		}
+
+		payload.RecomputeManual()
+		err := payload.Persist(path)
+		if err != nil {
+			fmt.Println(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		if verbose {
+			fmt.Printf("%s manual transaction %s\n", r.Method, id)
+		}
+		json.NewEncoder(w).Encode(payload.ManualTransactions())
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+func mappingKey(m CoinMapping) string {
+	return strings.ToLower(m.Exchange) + ":" + strings.ToUpper(m.Symbol)
+}
+
+// symbols coingecko lists under another ticker or shares with other coins
+var knownMappings = []CoinMapping{
+	{Symbol: "BTC", CoinID: "bitcoin"},
+	{Symbol: "ETH", CoinID: "ethereum"},
+	{Symbol: "BNB", CoinID: "binancecoin"},
+	{Symbol: "USDT", CoinID: "tether"},
+	{Symbol: "USDC", CoinID: "usd-coin"},
+	{Symbol: "BUSD", CoinID: "binance-usd"},
+	{Symbol: "DAI", CoinID: "dai"},
+	{Symbol: "XRP", CoinID: "ripple"},
+	{Symbol: "ADA", CoinID: "cardano"},
+	{Symbol: "SOL", CoinID: "solana"},
+	{Symbol: "DOT", CoinID: "polkadot"},
+	{Symbol: "DOGE", CoinID: "dogecoin"},
+	{Symbol: "MATIC", CoinID: "matic-network"},
+	{Symbol: "AVAX", CoinID: "avalanche-2"},
+	{Symbol: "LINK", CoinID: "chainlink"},
+	{Symbol: "UNI", CoinID: "uniswap"},
+	{Symbol: "ATOM", CoinID: "cosmos"},
+	{Symbol: "LTC", CoinID: "litecoin"},
+	{Symbol: "TRX", CoinID: "tron"},
+	{Symbol: "SHIB", CoinID: "shiba-inu"},
+	{Symbol: "ONE", CoinID: "harmony"},
+	{Symbol: "UST", CoinID: "terrausd"},
+	{Symbol: "LUNC", CoinID: "terra-luna"},
+	// coingecko lists it as miota
+	{Symbol: "IOTA", CoinID: "iota"},
+	// binance renamed these after the terra collapse
+	{Exchange: "binance", Symbol: "LUNA", CoinID: "terra-luna-2"},
+	{Exchange: "binance", Symbol: "USTC", CoinID: "terrausd"},
+}
+
+// loadMappings reads server overrides. A list of {"exchange", "symbol", "coin_id"}
+func loadMappings(path string) ([]CoinMapping, error) {
+	if path == "" {
+		return nil, nil
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var mappings []CoinMapping
+	err = json.Unmarshal(content, &mappings)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range mappings {
+		if m.Symbol == "" || m.CoinID == "" {
+			return nil, fmt.Errorf("%s: symbol and coin_id are required", path)
+		}
+	}
+	return mappings, nil
+}
+
+// mergeMappings applies each layer over the ones before it.
+// The result is in increasing precedence: an overridden mapping moves to its layer
+// and mappings for every exchange come before those for one exchange within a layer
+func mergeMappings(layers ...[]CoinMapping) []CoinMapping {
+	merged := []CoinMapping{}
+	for _, layer := range layers {
+		ordered := append([]CoinMapping{}, layer...)
+		sort.SliceStable(ordered, func(i, j int) bool {
+			return ordered[i].Exchange == "" && ordered[j].Exchange != ""
+		})
+		for _, m := range ordered {
+			m.Exchange = strings.ToLower(m.Exchange)
+			m.Symbol = strings.ToUpper(m.Symbol)
+			kept := merged[:0]
+			for _, existing := range merged {
+				if mappingKey(existing) != mappingKey(m) {
+					kept = append(kept, existing)
+				}
+			}
+			merged = append(kept, m)
+		}
+	}
+	return merged
+}
+
+// MappingsHandler lists the effective symbol to coin mappings
+// and adds or removes the user's own overrides
+func MappingsHandler(store string, server []CoinMapping, verbose bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Content-Type", "application/json")
+		key := r.Header.Get("X-API-Key")
+		if key == "" && r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "X-API-Key is required"})
+			return
+		}
+		var payload Payload
+		path := fmt.Sprintf("%s/%s.json", store, key)
+		if key != "" {
+			defer lockFile(path)()
+			payload = loadExisting(path)
+		}
+		if r.Method == http.MethodPost || r.Method == http.MethodDelete {
+			if _, err := os.Stat(path); err != nil {
+				w.WriteHeader(http.StatusNotFound)
+				json.NewEncoder(w).Encode(map[string]string{"error": "no report for this key. Update first"})
+				return
+			}
+			var m CoinMapping
+			err := json.NewDecoder(r.Body).Decode(&m)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+				return
+			}
+			if m.Symbol == "" || (r.Method == http.MethodPost && m.CoinID == "") {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": "symbol and coin_id are required"})
+				return
+			}
+			var kept []CoinMapping
+			for _, existing := range payload.Mappings {
+				if mappingKey(existing) != mappingKey(m) {
+					kept = append(kept, existing)
+				}
+			}
+			if r.Method == http.MethodPost {
+				kept = append(kept, m)
+			}
+			payload.Mappings = mergeMappings(kept)
+			err = payload.Persist(path)
+			if err != nil {
+				fmt.Println(err)
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+				return
+			}
+			if verbose {
+				fmt.Printf("%d coin mappings\n", len(payload.Mappings))
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"mappings": mergeMappings(knownMappings, server, payload.Mappings),
+			"user":     payload.Mappings,
+		})
+	}
+}
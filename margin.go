@@ -0,0 +1,449 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	binance2 "github.com/adshao/go-binance/v2"
+	"github.com/enzosv/binalysis/model"
+	"github.com/pkg/errors"
+)
+
+type marginInterest struct {
+	TxID                int64  `json:"txId"`
+	Asset               string `json:"asset"`
+	Interest            string `json:"interest"`
+	InterestAccuredTime int64  `json:"interestAccuredTime"`
+	IsolatedSymbol      string `json:"isolatedSymbol"`
+}
+
+// marginLoan is a loan or repayment record
+type marginLoan struct {
+	TxID      int64  `json:"txId"`
+	Asset     string `json:"asset"`
+	Principal string `json:"principal"`
+	Timestamp int64  `json:"timestamp"`
+	Status    string `json:"status"`
+}
+
+type marginLiquidation struct {
+	OrderID     int64  `json:"orderId"`
+	Symbol      string `json:"symbol"`
+	UpdatedTime int64  `json:"updatedTime"`
+}
+
+// margin history is read this many rows a page
+const marginPage = 100
+
+// margin history older than 6 months is archived.
+// Starts at the cursor's millisecond since more records can share it
+func marginSince(cursor int64) int64 {
+	since := time.Now().AddDate(0, -6, 0).UnixMilli()
+	if cursor > since {
+		return cursor
+	}
+	return since
+}
+
+// pageHistory reads every page of a margin history from since. All pages are read from the same start.
+// Rows read before, the seen ids at the cursor's millisecond, are left out. Before ids were kept
+// every row at the cursor's millisecond was read. Returns the new rows and the cursor and ids after them,
+// to be stored only once every page is read
+func pageHistory[T any](since, cursor int64, seen []int64, key func(T) (int64, int64), fetch func(since int64, current int) ([]T, error)) ([]T, int64, []int64, error) {
+	skip := map[int64]bool{}
+	for _, id := range seen {
+		skip[id] = true
+	}
+	latest, ids := cursor, append([]int64{}, seen...)
+	var fresh []T
+	for current := 1; ; current++ {
+		rows, err := fetch(since, current)
+		if err != nil {
+			return nil, cursor, seen, err
+		}
+		for _, r := range rows {
+			id, at := key(r)
+			if skip[id] || (at == cursor && cursor > 0 && len(seen) == 0) {
+				continue
+			}
+			skip[id] = true
+			fresh = append(fresh, r)
+			if at > latest {
+				latest, ids = at, nil
+			}
+			if at == latest {
+				ids = append(ids, id)
+			}
+		}
+		if len(rows) < marginPage {
+			break
+		}
+	}
+	return fresh, latest, ids, nil
+}
+
+// marginPair is a cross or isolated margin account's trading pair
+type marginPair struct {
+	symbol   string
+	base     string
+	quote    string
+	isolated bool
+}
+
+// prefix of the pair's trade ids on the ledger
+func (m marginPair) prefix() string {
+	if m.isolated {
+		return fmt.Sprintf("margin:isolated:%s:", m.symbol)
+	}
+	return fmt.Sprintf("margin:%s:", m.symbol)
+}
+
+// lastMarginTrades are the highest trade id on the ledger under each id prefix
+func lastMarginTrades(ledger []Transaction) map[string]int64 {
+	last := map[string]int64{}
+	for _, t := range ledger {
+		i := strings.LastIndex(t.ID, ":")
+		if !strings.HasPrefix(t.ID, marginSource+":") || i < 0 {
+			continue
+		}
+		id, err := strconv.ParseInt(t.ID[i+1:], 10, 64)
+		if err != nil {
+			continue
+		}
+		if prefix := t.ID[:i+1]; id > last[prefix] {
+			last[prefix] = id
+		}
+	}
+	return last
+}
+
+// fetchMarginTrades reads cross and isolated margin trades since each account's last trade on the ledger.
+// Isolated trades used to share cross margin's ids and are renamed when read again
+func fetchMarginTrades(ctx context.Context, client *binance2.Client, p *Payload, pairs []marginPair, verbose bool) error {
+	index := map[string]int{}
+	for i, t := range p.Ledger {
+		index[t.ID] = i
+	}
+	for _, pair := range pairs {
+		var txs []Transaction
+		fromID := int64(0)
+		// read again after each pair since renamed isolated trades leave cross margin's ids
+		if id, ok := lastMarginTrades(p.Ledger)[pair.prefix()]; ok {
+			fromID = id + 1
+		}
+		for {
+			trades, err := client.NewListMarginTradesService().
+				Symbol(pair.symbol).
+				IsIsolated(pair.isolated).
+				FromID(fromID).
+				Limit(1000).
+				Do(ctx)
+			if err != nil {
+				return errors.Wrap(err, fmt.Sprintf("[%s] fetching margin trades", pair.symbol))
+			}
+			if len(trades) < 1 {
+				break
+			}
+			for _, t := range trades {
+				if t.ID >= fromID {
+					fromID = t.ID + 1
+				}
+				id := fmt.Sprintf("%s%d", pair.prefix(), t.ID)
+				if i, ok := index[fmt.Sprintf("margin:%s:%d", pair.symbol, t.ID)]; ok && pair.isolated {
+					p.Ledger[i].ID = id
+					continue
+				}
+				amounts, err := parseAmounts(t.Price, t.Quantity, t.Commission)
+				if err != nil {
+					return err
+				}
+				txs = append(txs, Transaction{
+					ID:       id,
+					Source:   marginSource,
+					Base:     pair.base,
+					Quote:    pair.quote,
+					Time:     time.UnixMilli(t.Time),
+					IsBuyer:  t.IsBuyer,
+					Price:    amounts[0],
+					Qty:      amounts[1],
+					Fee:      amounts[2],
+					FeeAsset: t.CommissionAsset,
+				})
+			}
+			if verbose {
+				fmt.Printf("[%s] fetched %d margin trades\n", pair.symbol, len(trades))
+			}
+		}
+		_, err := p.Merge(txs)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loanHistory is what an account borrowed, repaid and was charged since its cursors
+type loanHistory struct {
+	loaned   float64
+	repaid   float64
+	interest float64
+	cursors  LoanCursors
+	payments []Payment
+}
+
+// fetchLoans reads an asset's loans, repayments and interest in cross margin,
+// or in an isolated pair's account when isolatedSymbol is set.
+// Interest is also returned one by one, from as far back as it is kept when backfill is set
+func fetchLoans(ctx context.Context, client *binance2.Client, asset, isolatedSymbol string, cursors LoanCursors, backfill bool) (loanHistory, error) {
+	h := loanHistory{cursors: cursors}
+	params := func(since int64, current int) url.Values {
+		params := url.Values{}
+		params.Set("asset", asset)
+		if isolatedSymbol != "" {
+			params.Set("isolatedSymbol", isolatedSymbol)
+		}
+		params.Set("startTime", strconv.FormatInt(since, 10))
+		params.Set("current", strconv.Itoa(current))
+		params.Set("size", strconv.Itoa(marginPage))
+		return params
+	}
+	// confirmed loans or repayments
+	principal := func(endpoint, name string, cursor int64, seen []int64) (float64, int64, []int64, error) {
+		rows, latest, ids, err := pageHistory(marginSince(cursor), cursor, seen,
+			func(l marginLoan) (int64, int64) {
+				return l.TxID, l.Timestamp
+			},
+			func(since int64, current int) ([]marginLoan, error) {
+				var res earnRows[marginLoan]
+				err := signedGet(ctx, client, endpoint, params(since, current), &res)
+				if err != nil {
+					return nil, errors.Wrap(err, fmt.Sprintf("[%s] fetching %s", asset, name))
+				}
+				// pending ones are read again once confirmed
+				var confirmed []marginLoan
+				for _, l := range res.Rows {
+					if l.Status == "CONFIRMED" {
+						confirmed = append(confirmed, l)
+					}
+				}
+				return confirmed, nil
+			})
+		if err != nil {
+			return 0, cursor, seen, err
+		}
+		total := 0.0
+		for _, l := range rows {
+			amount, err := strconv.ParseFloat(l.Principal, 64)
+			if err != nil {
+				return 0, cursor, seen, err
+			}
+			total += amount
+		}
+		return total, latest, ids, nil
+	}
+	var err error
+	h.loaned, h.cursors.LatestLoanTime, h.cursors.LoanIDs, err = principal("/sapi/v1/margin/loan", "loans", cursors.LatestLoanTime, cursors.LoanIDs)
+	if err != nil {
+		return h, err
+	}
+	h.repaid, h.cursors.LatestRepayTime, h.cursors.RepayIDs, err = principal("/sapi/v1/margin/repay", "repayments", cursors.LatestRepayTime, cursors.RepayIDs)
+	if err != nil {
+		return h, err
+	}
+
+	since, cursor, seen := marginSince(cursors.LatestInterestTime), cursors.LatestInterestTime, cursors.InterestIDs
+	if backfill {
+		since, cursor, seen = marginSince(0), 0, nil
+	}
+	rows, latest, ids, err := pageHistory(since, cursor, seen,
+		func(i marginInterest) (int64, int64) {
+			return i.TxID, i.InterestAccuredTime
+		},
+		func(since int64, current int) ([]marginInterest, error) {
+			var res earnRows[marginInterest]
+			err := signedGet(ctx, client, "/sapi/v1/margin/interestHistory", params(since, current), &res)
+			if err != nil {
+				return nil, errors.Wrap(err, fmt.Sprintf("[%s] fetching interest", asset))
+			}
+			return res.Rows, nil
+		})
+	if err != nil {
+		return h, err
+	}
+	for _, i := range rows {
+		interest, err := strconv.ParseFloat(i.Interest, 64)
+		if err != nil {
+			return h, err
+		}
+		h.payments = append(h.payments, Payment{
+			ID:     fmt.Sprintf("margin:interest:%s:%d", asset, i.TxID),
+			Source: marginSource,
+			Asset:  asset,
+			Kind:   model.InterestPayment,
+			Time:   time.UnixMilli(i.InterestAccuredTime),
+			Amount: -interest,
+			Note:   i.IsolatedSymbol,
+		})
+		if backfill && i.InterestAccuredTime <= cursors.LatestInterestTime {
+			// already in the total
+			continue
+		}
+		h.interest += interest
+	}
+	if latest >= cursors.LatestInterestTime {
+		h.cursors.LatestInterestTime, h.cursors.InterestIDs = latest, ids
+	}
+	return h, nil
+}
+
+// fetchMargin reads margin balances, borrowing and trades into the payload's margin assets
+func fetchMargin(ctx context.Context, client *binance2.Client, p *Payload, verbose bool) error {
+	account, err := client.NewGetMarginAccountService().Do(ctx)
+	if err != nil {
+		return errors.Wrap(err, "fetching margin account")
+	}
+	isolatedAccount, err := client.NewGetIsolatedMarginAccountService().Do(ctx)
+	if err != nil {
+		return errors.Wrap(err, "fetching isolated margin account")
+	}
+	if p.Margin == nil {
+		p.Margin = map[string]Asset{}
+	}
+	// zero out balances
+	for k, a := range p.Margin {
+		a.Balance = 0
+		a.Loan.Borrowed = 0
+		p.Margin[k] = a
+	}
+	active := map[string]bool{}
+	for k := range p.Margin {
+		active[k] = true
+	}
+	add := func(symbol, netAsset, borrowed string) error {
+		amounts, err := parseAmounts(netAsset, borrowed)
+		if err != nil {
+			return err
+		}
+		if amounts[0] == 0 && amounts[1] == 0 && !active[symbol] {
+			return nil
+		}
+		a := p.Margin[symbol]
+		a.Balance += amounts[0]
+		a.Loan.Borrowed += amounts[1]
+		p.Margin[symbol] = a
+		active[symbol] = true
+		return nil
+	}
+	for _, a := range account.UserAssets {
+		err := add(a.Asset, a.NetAsset, a.Borrowed)
+		if err != nil {
+			return err
+		}
+	}
+	var pairs []marginPair
+	// base of each symbol traded on margin
+	bases := map[string]string{}
+	for _, a := range isolatedAccount.Assets {
+		if !a.IsolatedCreated {
+			continue
+		}
+		for _, ua := range []binance2.IsolatedUserAsset{a.BaseAsset, a.QuoteAsset} {
+			err := add(ua.Asset, ua.NetAsset, ua.Borrowed)
+			if err != nil {
+				return err
+			}
+		}
+		pairs = append(pairs, marginPair{a.Symbol, a.BaseAsset.Asset, a.QuoteAsset.Asset, true})
+		bases[a.Symbol] = a.BaseAsset.Asset
+	}
+
+	crossPairs, err := client.NewGetMarginAllPairsService().Do(ctx)
+	if err != nil {
+		return errors.Wrap(err, "fetching margin pairs")
+	}
+	for _, c := range crossPairs {
+		if active[c.Base] {
+			pairs = append(pairs, marginPair{c.Symbol, c.Base, c.Quote, false})
+			bases[c.Symbol] = c.Base
+		}
+	}
+	// isolated first so trades they used to share ids with are renamed before cross margin reads from its last trade
+	err = fetchMarginTrades(ctx, client, p, pairs, verbose)
+	if err != nil {
+		return err
+	}
+
+	backfill := !p.PaymentsBackfilled
+	for symbol := range active {
+		a := p.Margin[symbol]
+		h, err := fetchLoans(ctx, client, symbol, "", a.Loan.LoanCursors, backfill)
+		if err != nil {
+			return err
+		}
+		a.Loan.Loaned += h.loaned
+		a.Loan.Repaid += h.repaid
+		a.Loan.Interest += h.interest
+		a.Loan.LoanCursors = h.cursors
+		p.RecordPayments(h.payments)
+		p.Margin[symbol] = a
+	}
+	for _, pair := range pairs {
+		if !pair.isolated {
+			continue
+		}
+		for _, asset := range []string{pair.base, pair.quote} {
+			a := p.Margin[asset]
+			h, err := fetchLoans(ctx, client, asset, pair.symbol, a.Loan.Isolated[pair.symbol], backfill)
+			if err != nil {
+				return err
+			}
+			a.Loan.Loaned += h.loaned
+			a.Loan.Repaid += h.repaid
+			a.Loan.Interest += h.interest
+			if a.Loan.Isolated == nil {
+				a.Loan.Isolated = map[string]LoanCursors{}
+			}
+			a.Loan.Isolated[pair.symbol] = h.cursors
+			p.RecordPayments(h.payments)
+			p.Margin[asset] = a
+		}
+	}
+
+	liquidations, latest, ids, err := pageHistory(marginSince(p.LatestLiquidationTime), p.LatestLiquidationTime, p.LiquidationIDs,
+		func(l marginLiquidation) (int64, int64) {
+			return l.OrderID, l.UpdatedTime
+		},
+		func(since int64, current int) ([]marginLiquidation, error) {
+			params := url.Values{}
+			params.Set("startTime", strconv.FormatInt(since, 10))
+			params.Set("current", strconv.Itoa(current))
+			params.Set("size", strconv.Itoa(marginPage))
+			var res earnRows[marginLiquidation]
+			err := signedGet(ctx, client, "/sapi/v1/margin/forceLiquidationRec", params, &res)
+			if err != nil {
+				return nil, errors.Wrap(err, "fetching liquidations")
+			}
+			return res.Rows, nil
+		})
+	if err != nil {
+		return err
+	}
+	for _, l := range liquidations {
+		base, ok := bases[l.Symbol]
+		if !ok {
+			continue
+		}
+		a := p.Margin[base]
+		a.Loan.Liquidations++
+		p.Margin[base] = a
+	}
+	p.LatestLiquidationTime, p.LiquidationIDs = latest, ids
+	if verbose {
+		fmt.Printf("%d margin assets\n", len(p.Margin))
+	}
+	return nil
+}
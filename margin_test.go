@@ -0,0 +1,88 @@
+package main
+
+import (
+	"sort"
+	"testing"
+)
+
+type marginRow struct {
+	id   int64
+	time int64
+}
+
+// marginHistory serves rows from since, newest first, a page at a time
+func marginHistory(rows []marginRow) func(since int64, current int) ([]marginRow, error) {
+	return func(since int64, current int) ([]marginRow, error) {
+		var matched []marginRow
+		for _, r := range rows {
+			if r.time >= since {
+				matched = append(matched, r)
+			}
+		}
+		sort.Slice(matched, func(i, j int) bool {
+			return matched[i].time > matched[j].time
+		})
+		start := (current - 1) * marginPage
+		if start >= len(matched) {
+			return nil, nil
+		}
+		end := start + marginPage
+		if end > len(matched) {
+			end = len(matched)
+		}
+		return matched[start:end], nil
+	}
+}
+
+func TestPageHistory(t *testing.T) {
+	key := func(r marginRow) (int64, int64) {
+		return r.id, r.time
+	}
+	since := marginSince(0)
+	var rows []marginRow
+	// more rows share a millisecond than fit in a page
+	for i := int64(0); i < 3*marginPage; i++ {
+		rows = append(rows, marginRow{id: i, time: since + 10 + i/(2*marginPage)})
+	}
+	counted := map[int64]int{}
+	count := func(fresh []marginRow) {
+		for _, r := range fresh {
+			counted[r.id]++
+		}
+	}
+
+	fresh, cursor, seen, err := pageHistory(since, 0, nil, key, marginHistory(rows))
+	if err != nil {
+		t.Fatal(err)
+	}
+	count(fresh)
+	if cursor != since+11 || len(seen) != marginPage {
+		t.Fatalf("cursor %d with %d ids, want %d with %d", cursor, len(seen), since+11, marginPage)
+	}
+
+	// more records arrive at the cursor's millisecond and after
+	rows = append(rows, marginRow{id: 1000, time: since + 11}, marginRow{id: 1001, time: since + 12})
+	fresh, cursor, seen, err = pageHistory(marginSince(cursor), cursor, seen, key, marginHistory(rows))
+	if err != nil {
+		t.Fatal(err)
+	}
+	count(fresh)
+	if cursor != since+12 || len(seen) != 1 || seen[0] != 1001 {
+		t.Fatalf("cursor %d with ids %v, want %d with [1001]", cursor, seen, since+12)
+	}
+
+	for _, r := range rows {
+		if counted[r.id] != 1 {
+			t.Errorf("row %d read %d times", r.id, counted[r.id])
+		}
+	}
+
+	// nothing new keeps the cursor
+	fresh, next, ids, err := pageHistory(marginSince(cursor), cursor, seen, key, marginHistory(rows))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fresh) != 0 || next != cursor || len(ids) != 1 {
+		t.Errorf("read %d rows again, cursor %d with ids %v", len(fresh), next, ids)
+	}
+}
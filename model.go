@@ -0,0 +1,26 @@
+package main
+
+import "github.com/enzosv/binalysis/model"
+
+// the stored report format, shared with the web client
+type (
+	Payload     = model.Payload
+	Asset       = model.Asset
+	Pair        = model.Pair
+	Earn        = model.Earn
+	Loan        = model.Loan
+	LoanCursors = model.LoanCursors
+	Futures     = model.Futures
+	Income      = model.Income
+	Position    = model.Position
+	Transaction = model.Transaction
+	Transfer    = model.Transfer
+	Payment     = model.Payment
+	CoinMapping = model.CoinMapping
+)
+
+const (
+	marginSource = model.MarginSource
+	manualSource = model.ManualSource
+	importPrefix = model.ImportPrefix
+)
@@ -0,0 +1,241 @@
+package model
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// what an alert watches
+const (
+	// current price against the average buy price
+	AverageBuyAlert = "average_buy"
+	// percent difference of the current price from the average buy price
+	PercentDifAlert = "percent_dif"
+	// percent change of the price in the last 24 hours
+	ChangeAlert = "change_24h"
+)
+
+// which side of the threshold triggers an alert
+const (
+	Above = "above"
+	Below = "below"
+	// either side of zero by more than the threshold
+	Beyond = "beyond"
+)
+
+// most alert events kept
+const maxAlertEvents = 100
+
+// AlertRule triggers when an asset's watched value is on one side of a threshold
+type AlertRule struct {
+	ID     string `json:"id"`
+	Symbol string `json:"symbol"`
+	Kind   string `json:"kind"`
+	// above, below or beyond
+	Direction string `json:"direction"`
+	// percent for percent_dif and change_24h. Unused for average_buy
+	Threshold float64 `json:"threshold"`
+}
+
+func (a AlertRule) Validate() error {
+	if strings.TrimSpace(a.Symbol) == "" {
+		return fmt.Errorf("symbol is required")
+	}
+	switch a.Kind {
+	case AverageBuyAlert:
+		if a.Direction != Above && a.Direction != Below {
+			return fmt.Errorf("average_buy alerts are above or below")
+		}
+	case PercentDifAlert, ChangeAlert:
+		if a.Direction != Above && a.Direction != Below && a.Direction != Beyond {
+			return fmt.Errorf("direction must be above, below or beyond")
+		}
+		if a.Direction == Beyond && a.Threshold < 0 {
+			return fmt.Errorf("beyond needs a positive threshold")
+		}
+	default:
+		return fmt.Errorf("kind must be %s, %s or %s", AverageBuyAlert, PercentDifAlert, ChangeAlert)
+	}
+	return nil
+}
+
+func (a AlertRule) String() string {
+	symbol := strings.ToUpper(a.Symbol)
+	switch a.Kind {
+	case AverageBuyAlert:
+		return fmt.Sprintf("%s price %s average buy", symbol, a.Direction)
+	case PercentDifAlert:
+		return fmt.Sprintf("%s %s %g%% from average buy", symbol, a.Direction, a.Threshold)
+	}
+	return fmt.Sprintf("%s 24h change %s %g%%", symbol, a.Direction, a.Threshold)
+}
+
+// watched is the value the rule compares and whether it is on the triggering side
+func (a AlertRule) watched(c Clean) (float64, bool) {
+	var value, threshold float64
+	switch a.Kind {
+	case AverageBuyAlert:
+		value, threshold = c.Coin.Price, c.AverageBuy
+	case PercentDifAlert:
+		value, threshold = c.PercentDif, a.Threshold
+	case ChangeAlert:
+		value, threshold = c.Coin.Change, a.Threshold
+	}
+	switch a.Direction {
+	case Above:
+		return value, value > threshold
+	case Below:
+		return value, value < threshold
+	}
+	return value, math.Abs(value) > threshold
+}
+
+// AlertState is whether a rule is triggered. An alert is only sent again after it clears
+type AlertState struct {
+	Triggered bool      `json:"triggered"`
+	Since     time.Time `json:"since"`
+	Value     float64   `json:"value"`
+	Checked   time.Time `json:"checked"`
+}
+
+// AlertEvent is a rule triggering or clearing
+type AlertEvent struct {
+	RuleID    string    `json:"rule_id"`
+	Symbol    string    `json:"symbol"`
+	Triggered bool      `json:"triggered"`
+	Value     float64   `json:"value"`
+	Time      time.Time `json:"time"`
+	Message   string    `json:"message"`
+}
+
+// Alerts are a user's rules and what they last saw
+type Alerts struct {
+	// prices are compared in this currency. usd when empty
+	Currency string `json:"currency"`
+	// events are posted here as json when set
+	Webhook string                `json:"webhook"`
+	Rules   []AlertRule           `json:"rules"`
+	States  map[string]AlertState `json:"states"`
+	// newest last
+	Events []AlertEvent `json:"events"`
+	// events not yet sent to the webhook. Sent again until it accepts them
+	Pending []AlertEvent `json:"pending,omitempty"`
+}
+
+// Check compares report rows against every rule and returns rules that changed state.
+// Rules on assets without a price are left as they were
+func (a *Alerts) Check(cleaned []Clean, now time.Time) []AlertEvent {
+	if a.States == nil {
+		a.States = map[string]AlertState{}
+	}
+	rows := map[string]Clean{}
+	for _, c := range cleaned {
+		rows[strings.ToUpper(c.Symbol)] = c
+	}
+	var events []AlertEvent
+	for _, rule := range a.Rules {
+		c, ok := rows[strings.ToUpper(rule.Symbol)]
+		if !ok || c.Coin.Price <= 0 {
+			continue
+		}
+		if rule.Kind != ChangeAlert && c.AverageBuy <= 0 {
+			// never bought
+			continue
+		}
+		value, triggered := rule.watched(c)
+		state := a.States[rule.ID]
+		if triggered != state.Triggered {
+			state.Triggered = triggered
+			state.Since = now
+			message := rule.String()
+			if !triggered {
+				message = "cleared: " + message
+			}
+			events = append(events, AlertEvent{rule.ID, strings.ToUpper(rule.Symbol), triggered, value, now, message})
+		}
+		state.Value = value
+		state.Checked = now
+		a.States[rule.ID] = state
+	}
+	a.Events = append(a.Events, events...)
+	if len(a.Events) > maxAlertEvents {
+		a.Events = a.Events[len(a.Events)-maxAlertEvents:]
+	}
+	if a.Webhook != "" {
+		a.Pending = append(a.Pending, events...)
+		if len(a.Pending) > maxAlertEvents {
+			a.Pending = a.Pending[len(a.Pending)-maxAlertEvents:]
+		}
+	}
+	return events
+}
+
+// Sent drops events the webhook accepted from those pending
+func (a *Alerts) Sent(events []AlertEvent) {
+	sent := map[string]bool{}
+	for _, e := range events {
+		sent[e.RuleID+"|"+e.Time.String()] = true
+	}
+	var pending []AlertEvent
+	for _, e := range a.Pending {
+		if !sent[e.RuleID+"|"+e.Time.String()] {
+			pending = append(pending, e)
+		}
+	}
+	a.Pending = pending
+}
+
+// AlertBasis is what alerts compare current prices with. It only changes with the payload
+// so exchange rates and the prices of past trades are fetched once for it
+type AlertBasis struct {
+	Currency   string
+	LastUpdate time.Time
+	Fetched    time.Time
+	// average buy price of each symbol
+	AverageBuy map[string]float64
+	fx         FX
+}
+
+// EvaluateAlertBasis reports on a payload for the average buy price of each asset
+func EvaluateAlertBasis(client *http.Client, payload Payload, mappings []CoinMapping, latestURL string, currency string) (AlertBasis, error) {
+	v, opts, err := valuer(client, payload, mappings, latestURL, Options{Currency: currency})
+	if err != nil {
+		return AlertBasis{}, err
+	}
+	cleaned, _ := Report(payload, v, opts)
+	b := AlertBasis{Currency: opts.Currency, LastUpdate: payload.LastUpdate, Fetched: time.Now(), AverageBuy: map[string]float64{}, fx: v.fx}
+	for _, c := range cleaned {
+		b.AverageBuy[strings.ToUpper(c.Symbol)] = c.AverageBuy
+	}
+	return b, nil
+}
+
+// Stale is whether the basis no longer matches the payload or is a day old
+func (b AlertBasis) Stale(payload Payload, currency string) bool {
+	if currency == "" {
+		currency = "usd"
+	}
+	return !b.LastUpdate.Equal(payload.LastUpdate) || b.Currency != strings.ToLower(currency) || time.Since(b.Fetched) > 24*time.Hour
+}
+
+// Rows fetches only the current prices of symbols, enough to check alerts on them
+func (b AlertBasis) Rows(client *http.Client, payload Payload, mappings []CoinMapping, latestURL string, symbols []string) []Clean {
+	opts := Options{Currency: b.Currency}.Defaults()
+	var lower []string
+	for _, s := range symbols {
+		lower = append(lower, strings.ToLower(s))
+	}
+	coins := FetchPrices(Providers(opts.Providers, client, b.fx, payload.CoinIDs(mappings), latestURL), lower, opts.Currency)
+	var rows []Clean
+	for _, s := range lower {
+		c := Clean{Symbol: s, Coin: coins[s], AverageBuy: b.AverageBuy[strings.ToUpper(s)]}
+		if c.AverageBuy != 0 {
+			c.difference()
+		}
+		rows = append(rows, c)
+	}
+	return rows
+}
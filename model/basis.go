@@ -0,0 +1,183 @@
+package model
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// cost basis methods
+const (
+	// every sale costs the running average of what is held
+	AverageCost = "average"
+	// sales use up the oldest lots first
+	FIFO = "fifo"
+	// sales use up the newest lots first
+	LIFO = "lifo"
+)
+
+func validMethod(method string) bool {
+	return method == AverageCost || method == FIFO || method == LIFO
+}
+
+type lot struct {
+	time time.Time
+	qty  float64
+	// in the reporting currency
+	cost float64
+}
+
+// basis splits an asset's gains into realized and unrealized by cost basis method.
+// Trades on record before the ledger was kept are one lot at their average cost, older than the rest.
+// Transfers between sources are in the totals but keep their lots. Only the fee sent and never received leaves them.
+// Fees and interest are left out of both
+func (v Valuer) basis(clean *Clean, txs []Transaction, method string) {
+	sort.SliceStable(txs, func(i, j int) bool {
+		return txs[i].Time.Before(txs[j].Time)
+	})
+	var buys, sells, buyCost float64
+	// received by each transfer
+	received := map[string]float64{}
+	for _, t := range txs {
+		if t.IsBuyer {
+			buys += t.Qty
+			buyCost += v.value(t.Quote, t.Price*t.Qty, t.Time)
+		} else {
+			sells += t.Qty
+		}
+		if id, ok := transferID(t); ok && t.IsBuyer {
+			received[id] += t.Qty
+		}
+	}
+	var lots []lot
+	realized := 0.0
+	if untracked := clean.BuyQty - buys; untracked > 1e-9 {
+		cost := clean.Cost - clean.TotalFee - clean.Interest - buyCost
+		if cost < 0 {
+			cost = 0
+		}
+		lots = append(lots, lot{qty: untracked, cost: cost})
+	}
+	if untracked := clean.SellQty - sells; untracked > 1e-9 {
+		// sold before the ledger was kept. Proceeds are what is left of revenue
+		proceeds := clean.Revenue
+		for _, t := range txs {
+			if !t.IsBuyer {
+				proceeds -= v.value(t.Quote, t.Price*t.Qty, t.Time)
+			}
+		}
+		realized += proceeds - consume(&lots, untracked, method)
+	}
+	for _, t := range txs {
+		if id, ok := transferID(t); ok {
+			if !t.IsBuyer && t.Qty-received[id] > 1e-12 {
+				consume(&lots, t.Qty-received[id], method)
+			}
+			continue
+		}
+		amount := v.value(t.Quote, t.Price*t.Qty, t.Time)
+		if t.IsBuyer {
+			lots = append(lots, lot{t.Time, t.Qty, amount})
+			continue
+		}
+		realized += amount - consume(&lots, t.Qty, method)
+	}
+	held := 0.0
+	for _, l := range lots {
+		held += l.cost
+	}
+	clean.Method = method
+	clean.Realized = realized
+	clean.CostBasis = held
+	clean.Unrealized = clean.Balance*clean.Coin.Price - held
+}
+
+// consume takes qty out of lots and returns the cost of what was taken.
+// Selling more than the lots hold costs nothing for the excess
+func consume(lots *[]lot, qty float64, method string) float64 {
+	if method == AverageCost {
+		var held, cost float64
+		for _, l := range *lots {
+			held += l.qty
+			cost += l.cost
+		}
+		if held <= 0 {
+			return 0
+		}
+		taken := qty
+		if taken > held {
+			taken = held
+		}
+		remaining := held - taken
+		*lots = []lot{{qty: remaining, cost: cost * remaining / held}}
+		return cost * taken / held
+	}
+	cost := 0.0
+	for qty > 1e-12 && len(*lots) > 0 {
+		i := 0
+		if method == LIFO {
+			i = len(*lots) - 1
+		}
+		l := (*lots)[i]
+		taken := qty
+		if taken > l.qty {
+			taken = l.qty
+		}
+		if l.qty > 0 {
+			cost += l.cost * taken / l.qty
+			l.cost -= l.cost * taken / l.qty
+		}
+		l.qty -= taken
+		qty -= taken
+		(*lots)[i] = l
+		if l.qty <= 1e-12 {
+			*lots = append((*lots)[:i], (*lots)[i+1:]...)
+		}
+	}
+	return cost
+}
+
+// transferID is the transfer a carried trade moved lots for
+func transferID(t Transaction) (string, bool) {
+	if !strings.HasPrefix(t.ID, "transfer:") {
+		return "", false
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(t.ID, ":out"), ":in"), true
+}
+
+// assetLedger is the ledger of each asset across sources
+func assetLedger(ledger []Transaction) map[string][]Transaction {
+	byAsset := map[string][]Transaction{}
+	for _, t := range ledger {
+		k := strings.ToUpper(t.Base)
+		byAsset[k] = append(byAsset[k], t)
+	}
+	return byAsset
+}
+
+// Options change how a report is computed
+type Options struct {
+	// reporting currency. usd when empty
+	Currency string
+	// price providers in priority order. DefaultProviders when empty
+	Providers []string
+	// cost basis method. Average cost when empty
+	Method string
+	// rows holding less than this in the reporting currency are left out.
+	// Fully sold assets are kept and totals still include everything
+	Dust float64
+}
+
+func (o Options) Validate() error {
+	if o.Currency != "" && !IsFiat(o.Currency) {
+		return fmt.Errorf("unsupported currency %s", o.Currency)
+	}
+	if o.Method != "" && !validMethod(o.Method) {
+		return fmt.Errorf("unknown cost basis method %s", o.Method)
+	}
+	if o.Dust < 0 {
+		return fmt.Errorf("dust cannot be negative")
+	}
+	return nil
+}
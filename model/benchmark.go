@@ -0,0 +1,172 @@
+package model
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Benchmark is what money could have been held in instead. Weights of each asset add up to 1
+type Benchmark map[string]float64
+
+// ParseBenchmark reads a benchmark like btc or btc:0.6,eth:0.4. Weights are relative
+func ParseBenchmark(s string) (Benchmark, error) {
+	b := Benchmark{}
+	total := 0.0
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		asset, weight := part, 1.0
+		if i := strings.Index(part, ":"); i >= 0 {
+			w, err := strconv.ParseFloat(part[i+1:], 64)
+			if err != nil || w <= 0 {
+				return nil, fmt.Errorf("invalid weight in %s", part)
+			}
+			asset, weight = part[:i], w
+		}
+		asset = strings.ToUpper(strings.TrimSpace(asset))
+		if asset == "" {
+			return nil, fmt.Errorf("missing asset in %s", part)
+		}
+		b[asset] += weight
+		total += weight
+	}
+	if len(b) < 1 {
+		return nil, fmt.Errorf("empty benchmark")
+	}
+	for asset := range b {
+		b[asset] /= total
+	}
+	return b, nil
+}
+
+// Symbols are the benchmark's assets in lowercase
+func (b Benchmark) Symbols() []string {
+	var symbols []string
+	for asset := range b {
+		symbols = append(symbols, strings.ToLower(asset))
+	}
+	sort.Strings(symbols)
+	return symbols
+}
+
+func (b Benchmark) String() string {
+	var parts []string
+	for asset, weight := range b {
+		parts = append(parts, fmt.Sprintf("%s:%g", asset, weight))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+// Versus is what was put in, what it is worth and what it would be worth held in the benchmark
+type Versus struct {
+	Invested  float64 `json:"invested"`
+	Withdrawn float64 `json:"withdrawn"`
+	Value     float64 `json:"value"`
+	Benchmark float64 `json:"benchmark"`
+	// value over the benchmark. Negative when holding the benchmark would have done better
+	Difference float64 `json:"difference"`
+}
+
+// BenchmarkPoint is the portfolio and the benchmark at a day's close
+type BenchmarkPoint struct {
+	Date      string  `json:"date"`
+	Value     float64 `json:"value"`
+	Benchmark float64 `json:"benchmark"`
+}
+
+// Comparison is the portfolio and each asset against the same money held in a benchmark
+type Comparison struct {
+	Benchmark string            `json:"benchmark"`
+	Total     Versus            `json:"total"`
+	Assets    map[string]Versus `json:"assets"`
+	Points    []BenchmarkPoint  `json:"points"`
+}
+
+// basket is units of each benchmark asset bought with the money put in
+type basket map[string]float64
+
+func (b basket) value(v Valuer, d day) float64 {
+	total := 0.0
+	for asset, units := range b {
+		total += v.value(asset, units, d.time)
+	}
+	return total
+}
+
+// move buys the benchmark by weight with money put in on a day.
+// Money taken out sells the same share of every benchmark asset, never more than is held
+func (b basket) move(v Valuer, benchmark Benchmark, d day, flow float64) {
+	if flow > 0 {
+		for asset, weight := range benchmark {
+			if price := v.value(asset, 1, d.time); price > 0 {
+				b[asset] += flow * weight / price
+			}
+		}
+		return
+	}
+	held := b.value(v, d)
+	if held <= 0 {
+		return
+	}
+	share := -flow / held
+	if share > 1 {
+		share = 1
+	}
+	for asset := range b {
+		b[asset] -= b[asset] * share
+	}
+}
+
+// Compare replays every buy into the benchmark at the day's price and every sell out of it.
+// Each asset is compared against its own money. The total against all money put in and taken out
+func (v Valuer) Compare(payload Payload, method string, benchmark Benchmark) Comparison {
+	c := Comparison{Benchmark: benchmark.String(), Assets: map[string]Versus{}}
+	days := v.replay(payload, method)
+	if len(days) < 1 {
+		return c
+	}
+	baskets := map[string]basket{}
+	total := basket{}
+	for _, d := range days {
+		flow := 0.0
+		for asset, f := range d.flows {
+			if baskets[asset] == nil {
+				baskets[asset] = basket{}
+			}
+			baskets[asset].move(v, benchmark, d, f)
+			versus := c.Assets[asset]
+			if f > 0 {
+				versus.Invested += f
+			} else {
+				versus.Withdrawn -= f
+			}
+			c.Assets[asset] = versus
+			flow += f
+		}
+		if flow != 0 {
+			total.move(v, benchmark, d, flow)
+		}
+		if flow > 0 {
+			c.Total.Invested += flow
+		} else {
+			c.Total.Withdrawn -= flow
+		}
+		c.Points = append(c.Points, BenchmarkPoint{d.snapshot.Date, d.snapshot.Value, total.value(v, d)})
+	}
+	last := days[len(days)-1]
+	for asset, versus := range c.Assets {
+		versus.Value = last.values[asset]
+		versus.Benchmark = baskets[asset].value(v, last)
+		versus.Difference = versus.Value - versus.Benchmark
+		c.Assets[asset] = versus
+	}
+	c.Total.Value = last.snapshot.Value
+	c.Total.Benchmark = total.value(v, last)
+	c.Total.Difference = c.Total.Value - c.Total.Benchmark
+	return c
+}
@@ -0,0 +1,143 @@
+package model
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Evaluate fetches exchange rates and prices and reports on a payload.
+// latestURL is the server the payload came from. Its price cache and static prices are tried first
+func Evaluate(client *http.Client, payload Payload, mappings []CoinMapping, latestURL string, opts Options) ([]Clean, Summary, error) {
+	v, opts, err := valuer(client, payload, mappings, latestURL, opts)
+	if err != nil {
+		return nil, Summary{}, err
+	}
+	cleaned, summary := Report(payload, v, opts)
+	return cleaned, summary, nil
+}
+
+// EvaluateHistory values the payload's ledger on every day since its first trade
+func EvaluateHistory(client *http.Client, payload Payload, mappings []CoinMapping, latestURL string, opts Options) (History, error) {
+	v, opts, err := valuer(client, payload, mappings, latestURL, opts)
+	if err != nil {
+		return History{}, err
+	}
+	return History{
+		Currency:   opts.Currency,
+		Method:     opts.Method,
+		LastUpdate: payload.LastUpdate,
+		Snapshots:  v.History(payload, opts.Method),
+	}, nil
+}
+
+// EvaluateReturns are time and money-weighted returns of the portfolio and each asset over periods
+func EvaluateReturns(client *http.Client, payload Payload, mappings []CoinMapping, latestURL string, opts Options, periods []string) ([]Returns, error) {
+	if err := ValidatePeriods(periods); err != nil {
+		return nil, err
+	}
+	v, opts, err := valuer(client, payload, mappings, latestURL, opts)
+	if err != nil {
+		return nil, err
+	}
+	return v.Returns(payload, opts.Method, periods)
+}
+
+// Defaults fills in what was left empty
+func (o Options) Defaults() Options {
+	o.Currency = strings.ToLower(o.Currency)
+	if o.Currency == "" {
+		o.Currency = "usd"
+	}
+	if o.Method == "" {
+		o.Method = AverageCost
+	}
+	if len(o.Providers) < 1 {
+		o.Providers = DefaultProviders
+	}
+	return o
+}
+
+// EvaluateBenchmark compares the portfolio and each asset against the same money held in a benchmark
+func EvaluateBenchmark(client *http.Client, payload Payload, mappings []CoinMapping, latestURL string, opts Options, benchmark Benchmark) (Comparison, error) {
+	v, opts, err := valuer(client, payload, mappings, latestURL, opts, benchmark.Symbols()...)
+	if err != nil {
+		return Comparison{}, err
+	}
+	return v.Compare(payload, opts.Method, benchmark), nil
+}
+
+// EvaluateRisk measures drawdown, volatility and risk-adjusted returns of the payload's history
+// and how concentrated its current holdings are
+func EvaluateRisk(client *http.Client, payload Payload, mappings []CoinMapping, latestURL string, opts Options, risk RiskOptions) (Risk, error) {
+	risk = risk.Defaults()
+	if err := risk.Validate(); err != nil {
+		return Risk{}, err
+	}
+	v, opts, err := valuer(client, payload, mappings, latestURL, opts)
+	if err != nil {
+		return Risk{}, err
+	}
+	cleaned, _ := Report(payload, v, opts)
+	return v.Risk(payload, opts.Method, cleaned, risk), nil
+}
+
+// EvaluateTax reports disposals in a tax year by rule
+func EvaluateTax(client *http.Client, payload Payload, mappings []CoinMapping, latestURL string, opts Options, rule TaxRule, year int) (TaxReport, error) {
+	v, _, err := valuer(client, payload, mappings, latestURL, opts)
+	if err != nil {
+		return TaxReport{}, err
+	}
+	return v.Tax(payload, rule, year), nil
+}
+
+// EvaluateStatement reports on the trades from start until end and what is held now
+func EvaluateStatement(client *http.Client, payload Payload, mappings []CoinMapping, latestURL string, opts Options, start, end time.Time) (Statement, error) {
+	v, opts, err := valuer(client, payload, mappings, latestURL, opts)
+	if err != nil {
+		return Statement{}, err
+	}
+	return v.Statement(payload, opts, start, end), nil
+}
+
+// valuer values the payload's assets and any extra symbols in the reporting currency
+func valuer(client *http.Client, payload Payload, mappings []CoinMapping, latestURL string, opts Options, extra ...string) (Valuer, Options, error) {
+	opts = opts.Defaults()
+	err := opts.Validate()
+	if err != nil {
+		return Valuer{}, opts, err
+	}
+	currencies, since := payload.Fiats()
+	currencies[opts.Currency] = true
+	currencies["usd"] = true
+	var needed []string
+	for c := range currencies {
+		needed = append(needed, c)
+	}
+	fx, err := FetchFX(client, needed, since)
+	if err != nil {
+		fmt.Println(err)
+		return Valuer{}, opts, err
+	}
+	symbols := payload.Symbols()
+	known := map[string]bool{}
+	for _, s := range symbols {
+		known[s] = true
+	}
+	for _, s := range extra {
+		if !known[s] {
+			symbols = append(symbols, s)
+		}
+	}
+	ids := payload.CoinIDs(mappings)
+	for k := range ids {
+		// priced apart where exchanges hold different coins under one symbol
+		if strings.Contains(k, ":") {
+			symbols = append(symbols, k)
+		}
+	}
+	coins := FetchPrices(Providers(opts.Providers, client, fx, ids, latestURL), symbols, opts.Currency)
+	graph := NewGraph(client, payload, since)
+	return NewValuer(opts.Currency, coins, fx, graph), opts, nil
+}
@@ -0,0 +1,291 @@
+package model
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Exporters write a payload's trades, fees, transfers and payments in another tool's import format
+var Exporters = map[string]func(Payload, io.Writer) error{
+	"koinly":      exportKoinly,
+	"cointracker": exportCoinTracker,
+	"journal":     exportJournal,
+}
+
+// ParseExporter finds an exporter by name
+func ParseExporter(name string) (func(Payload, io.Writer) error, error) {
+	export, ok := Exporters[strings.ToLower(name)]
+	if !ok {
+		var names []string
+		for n := range Exporters {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown export format %s. Use one of %s", name, strings.Join(names, ", "))
+	}
+	return export, nil
+}
+
+// movement is one thing that happened to the portfolio in the shape most import formats share.
+// Trades both send and receive. Deposits and payments received only receive. Withdrawals, fees and payments made only send
+type movement struct {
+	time            time.Time
+	source          string
+	sent            float64
+	sentAsset       string
+	received        float64
+	receivedAsset   string
+	fee             float64
+	feeAsset        string
+	kind            string
+	description     string
+	txHash          string
+	transferMatched bool
+	// kind of payment
+	payment string
+}
+
+// movement kinds
+const (
+	tradeMovement      = "trade"
+	depositMovement    = "deposit"
+	withdrawalMovement = "withdrawal"
+	paymentMovement    = "payment"
+	// a fee paid apart from a trade
+	feeMovement = "fee"
+)
+
+// paymentLabel is how a kind of payment is tagged in Koinly and CoinTracker
+// and the journal account on the other side of it
+type paymentLabel struct {
+	koinly      string
+	cointracker string
+	account     string
+}
+
+var paymentLabels = map[string]paymentLabel{
+	DistributionPayment: {"airdrop", "airdrop", "Income:Distributions"},
+	RewardPayment:       {"reward", "staked", "Income:Rewards"},
+	InterestPayment:     {"margin fee", "", "Expenses:Interest"},
+	FuturesPnlPayment:   {"realized gain", "", "Income:Futures"},
+	FundingPayment:      {"realized gain", "", "Income:Funding"},
+	FuturesFeePayment:   {"margin fee", "", "Expenses:Fees"},
+	FuturesOtherPayment: {"", "", "Income:Futures"},
+}
+
+// movements are the payload's ledger, trades before it, transfers and payments in time order.
+// Ledger entries recorded for transfers are left out since the transfers themselves are exported
+func (p Payload) movements() []movement {
+	var all []movement
+	trade := func(t Transaction) movement {
+		m := movement{
+			time:        t.Time,
+			source:      t.Source,
+			fee:         t.Fee,
+			feeAsset:    strings.ToUpper(t.FeeAsset),
+			kind:        tradeMovement,
+			description: strings.TrimSpace(t.ID + " " + t.Note),
+		}
+		base, quote := strings.ToUpper(t.Base), strings.ToUpper(t.Quote)
+		if t.IsBuyer {
+			m.sent, m.sentAsset = t.Price*t.Qty, quote
+			m.received, m.receivedAsset = t.Qty, base
+		} else {
+			m.sent, m.sentAsset = t.Qty, base
+			m.received, m.receivedAsset = t.Price*t.Qty, quote
+		}
+		return m
+	}
+	for _, t := range p.Ledger {
+		if strings.HasPrefix(t.ID, "transfer:") {
+			continue
+		}
+		all = append(all, trade(t))
+	}
+	for _, t := range p.unrecorded() {
+		var assets []string
+		for asset := range t.fees {
+			assets = append(assets, asset)
+		}
+		sort.Strings(assets)
+		m := trade(t.Transaction)
+		for i, asset := range assets {
+			if i == 0 {
+				m.fee, m.feeAsset = t.fees[asset], asset
+				continue
+			}
+			// a movement has room for one fee
+			all = append(all, movement{
+				time:        t.Time,
+				source:      t.Source,
+				sent:        t.fees[asset],
+				sentAsset:   asset,
+				kind:        feeMovement,
+				description: m.description,
+			})
+		}
+		all = append(all, m)
+	}
+	for _, t := range p.Transfers {
+		m := movement{
+			time:            t.Time,
+			source:          t.Source,
+			fee:             t.Fee,
+			description:     t.ID,
+			txHash:          t.TxHash,
+			transferMatched: t.Match != "",
+		}
+		if t.Fee > 0 {
+			m.feeAsset = strings.ToUpper(t.Asset)
+		}
+		if t.Deposit {
+			m.kind = depositMovement
+			m.received, m.receivedAsset = t.Amount, strings.ToUpper(t.Asset)
+		} else {
+			m.kind = withdrawalMovement
+			m.sent, m.sentAsset = t.Amount, strings.ToUpper(t.Asset)
+		}
+		all = append(all, m)
+	}
+	for _, t := range append(p.unrecordedDistributions(), p.Payments...) {
+		if t.Amount == 0 {
+			continue
+		}
+		m := movement{
+			time:        t.Time,
+			source:      t.Source,
+			kind:        paymentMovement,
+			payment:     t.Kind,
+			description: strings.TrimSpace(t.ID + " " + t.Note),
+		}
+		if t.Amount > 0 {
+			m.received, m.receivedAsset = t.Amount, strings.ToUpper(t.Asset)
+		} else {
+			m.sent, m.sentAsset = -t.Amount, strings.ToUpper(t.Asset)
+		}
+		all = append(all, m)
+	}
+	sort.SliceStable(all, func(i, j int) bool {
+		if all[i].time.Equal(all[j].time) {
+			return all[i].description < all[j].description
+		}
+		return all[i].time.Before(all[j].time)
+	})
+	return all
+}
+
+func formatAmount(amount float64) string {
+	if amount == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(amount, 'f', -1, 64)
+}
+
+// exportKoinly writes Koinly's universal format
+func exportKoinly(p Payload, w io.Writer) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"Date", "Sent Amount", "Sent Currency", "Received Amount", "Received Currency",
+		"Fee Amount", "Fee Currency", "Net Worth Amount", "Net Worth Currency", "Label", "Description", "TxHash"})
+	for _, m := range p.movements() {
+		label := paymentLabels[m.payment].koinly
+		if m.kind == feeMovement {
+			label = "cost"
+		}
+		out.Write([]string{
+			m.time.UTC().Format("2006-01-02 15:04:05 UTC"),
+			formatAmount(m.sent), m.sentAsset,
+			formatAmount(m.received), m.receivedAsset,
+			formatAmount(m.fee), m.feeAsset,
+			"", "",
+			label,
+			strings.TrimSpace(m.source + " " + m.description),
+			m.txHash,
+		})
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// exportCoinTracker writes CoinTracker's csv import format
+func exportCoinTracker(p Payload, w io.Writer) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"Date", "Received Quantity", "Received Currency", "Sent Quantity", "Sent Currency",
+		"Fee Amount", "Fee Currency", "Tag"})
+	for _, m := range p.movements() {
+		tag := paymentLabels[m.payment].cointracker
+		out.Write([]string{
+			m.time.UTC().Format("01/02/2006 15:04:05"),
+			formatAmount(m.received), m.receivedAsset,
+			formatAmount(m.sent), m.sentAsset,
+			formatAmount(m.fee), m.feeAsset,
+			tag,
+		})
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// exportJournal writes a double-entry journal. Every entry balances in each asset:
+// trades exchange through an Equity:Trading account, transfers between sources pass through Assets:Transit
+// and money from or to outside the portfolio is Equity:External
+func exportJournal(p Payload, w io.Writer) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"Date", "Entry", "Account", "Asset", "Debit", "Credit", "Memo"})
+	for i, m := range p.movements() {
+		entry := strconv.Itoa(i + 1)
+		date := m.time.UTC().Format("2006-01-02 15:04:05")
+		line := func(account, asset string, debit, credit float64) {
+			out.Write([]string{date, entry, account, asset, formatAmount(debit), formatAmount(credit), m.description})
+		}
+		account := func(asset string) string {
+			return fmt.Sprintf("Assets:%s:%s", m.source, asset)
+		}
+		switch m.kind {
+		case tradeMovement:
+			line(account(m.receivedAsset), m.receivedAsset, m.received, 0)
+			line("Equity:Trading", m.receivedAsset, 0, m.received)
+			line("Equity:Trading", m.sentAsset, m.sent, 0)
+			line(account(m.sentAsset), m.sentAsset, 0, m.sent)
+		case depositMovement:
+			other := "Equity:External"
+			if m.transferMatched {
+				other = "Assets:Transit"
+			}
+			line(account(m.receivedAsset), m.receivedAsset, m.received, 0)
+			line(other, m.receivedAsset, 0, m.received)
+		case withdrawalMovement:
+			other := "Equity:External"
+			if m.transferMatched {
+				other = "Assets:Transit"
+			}
+			line(other, m.sentAsset, m.sent, 0)
+			line(account(m.sentAsset), m.sentAsset, 0, m.sent)
+		case paymentMovement:
+			other := paymentLabels[m.payment].account
+			if other == "" {
+				other = "Income:Other"
+			}
+			if m.received > 0 {
+				line(account(m.receivedAsset), m.receivedAsset, m.received, 0)
+				line(other, m.receivedAsset, 0, m.received)
+			} else {
+				line(other, m.sentAsset, m.sent, 0)
+				line(account(m.sentAsset), m.sentAsset, 0, m.sent)
+			}
+		case feeMovement:
+			line("Expenses:Fees", m.sentAsset, m.sent, 0)
+			line(account(m.sentAsset), m.sentAsset, 0, m.sent)
+		}
+		if m.fee > 0 && m.feeAsset != "" {
+			line("Expenses:Fees", m.feeAsset, m.fee, 0)
+			line(account(m.feeAsset), m.feeAsset, 0, m.fee)
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
@@ -0,0 +1,72 @@
+package model
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files")
+
+func TestExporters(t *testing.T) {
+	at := func(date string) time.Time {
+		d, err := time.Parse("2006-01-02 15:04", date)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	payload := Payload{
+		Binance: map[string]Asset{
+			"BTC": {
+				Pairs: map[string]Pair{"USDT": {
+					BuyQty: 2, Cost: 200, SellQty: 1, Revenue: 300,
+					Fees:          map[string]float64{"BNB": 0.01, "USDT": 1},
+					EarliestTrade: &Trade{Time: at("2021-01-10 10:00")},
+					LatestTrade:   &Trade{Time: at("2023-06-01 12:00")},
+				}},
+			},
+			"DOT": {DistributionTotal: 3, LatestDistributionTime: at("2023-03-01 00:00").UnixMilli()},
+		},
+		Ledger: []Transaction{
+			{ID: "binance:BTCUSDT:1", Source: "binance", Base: "BTC", Quote: "USDT", Time: at("2023-06-01 12:00"), IsBuyer: false, Price: 300, Qty: 1, Fee: 0.3, FeeAsset: "USDT"},
+			{ID: "transfer:1", Source: "binance", Base: "USDT", Quote: "USD", Time: at("2021-01-01 00:00"), IsBuyer: true, Price: 1, Qty: 500},
+		},
+		Transfers: []Transfer{
+			{ID: "binance:deposit:1", Source: "binance", Asset: "usdt", Amount: 500, Time: at("2021-01-01 00:00"), Deposit: true, TxHash: "0xabc"},
+			{ID: "binance:withdrawal:1", Source: "binance", Asset: "btc", Amount: 0.5, Fee: 0.0005, Time: at("2023-07-01 00:00"), Match: "wallet:1"},
+		},
+		Payments: []Payment{
+			{ID: "binance:distribution:DOT:1", Source: "binance", Asset: "DOT", Kind: DistributionPayment, Time: at("2023-02-01 00:00"), Amount: 1, Note: "airdrop"},
+			{ID: "binance:distribution:BNB:2", Source: "binance", Asset: "BNB", Kind: RewardPayment, Time: at("2023-02-02 00:00"), Amount: 0.1, Note: "Simple Earn"},
+			{ID: "margin:interest:USDT:3", Source: "margin", Asset: "USDT", Kind: InterestPayment, Time: at("2023-02-03 00:00"), Amount: -0.5},
+			{ID: "futures:usdm:4", Source: "futures:usdm", Asset: "USDT", Kind: FuturesPnlPayment, Time: at("2023-02-04 00:00"), Amount: 12, Note: "BTCUSDT"},
+			{ID: "futures:usdm:5", Source: "futures:usdm", Asset: "USDT", Kind: FundingPayment, Time: at("2023-02-04 08:00"), Amount: -0.25, Note: "BTCUSDT"},
+			{ID: "futures:usdm:6", Source: "futures:usdm", Asset: "USDT", Kind: FuturesFeePayment, Time: at("2023-02-04 08:00"), Amount: -0.1, Note: "BTCUSDT"},
+		},
+	}
+	for format, export := range Exporters {
+		t.Run(format, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := export(payload, &out); err != nil {
+				t.Fatal(err)
+			}
+			golden := filepath.Join("testdata", "export_"+format+".csv")
+			if *update {
+				if err := os.WriteFile(golden, out.Bytes(), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(out.Bytes(), want) {
+				t.Errorf("got\n%s\nwant\n%s", out.String(), want)
+			}
+		})
+	}
+}
@@ -0,0 +1,242 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// assets most markets are quoted in. Tried when routing an asset with no known market
+var hubs = []string{"USDT", "BUSD", "BTC", "BNB", "ETH"}
+
+// longest route from an asset to a fiat or stablecoin
+const maxHops = 3
+
+// Graph routes assets to a fiat or stablecoin through binance markets
+// so they can be valued at the time of a trade
+type Graph struct {
+	client *http.Client
+	since  time.Time
+	// known markets between assets. Both directions
+	edges map[string]map[string]bool
+	// daily closes keyed by binance symbol then date. Nil when the market does not exist
+	closes map[string]map[string]float64
+	routes map[string][]string
+}
+
+func NewGraph(client *http.Client, payload Payload, since time.Time) *Graph {
+	g := &Graph{
+		client: client,
+		since:  since,
+		edges:  map[string]map[string]bool{},
+		closes: map[string]map[string]float64{},
+		routes: map[string][]string{},
+	}
+	for _, assets := range []map[string]Asset{payload.Binance, payload.Kucoin, payload.Manual, payload.Margin, payload.Wallet} {
+		for base, a := range assets {
+			for quote := range a.Pairs {
+				g.connect(base, quote)
+			}
+		}
+	}
+	return g
+}
+
+func (g *Graph) connect(a, b string) {
+	a = strings.ToUpper(a)
+	b = strings.ToUpper(b)
+	if a == b {
+		return
+	}
+	if g.edges[a] == nil {
+		g.edges[a] = map[string]bool{}
+	}
+	if g.edges[b] == nil {
+		g.edges[b] = map[string]bool{}
+	}
+	g.edges[a][b] = true
+	g.edges[b][a] = true
+}
+
+// neighbors are known markets first, then hubs
+func (g *Graph) neighbors(asset string) []string {
+	var next []string
+	for n := range g.edges[asset] {
+		next = append(next, n)
+	}
+	for _, h := range hubs {
+		if !g.edges[asset][h] && h != asset {
+			next = append(next, h)
+		}
+	}
+	return next
+}
+
+// route is the shortest path of existing markets from asset to a fiat or stablecoin
+func (g *Graph) route(asset string) ([]string, bool) {
+	asset = strings.ToUpper(asset)
+	if r, ok := g.routes[asset]; ok {
+		return r, r != nil
+	}
+	// markets are only checked when a route uses them.
+	// Search again whenever a route had a missing market
+	for {
+		r := g.search(asset)
+		if r == nil {
+			g.routes[asset] = nil
+			return nil, false
+		}
+		valid := true
+		for i := 0; i+1 < len(r); i++ {
+			if !g.market(r[i], r[i+1]) {
+				delete(g.edges[r[i]], r[i+1])
+				delete(g.edges[r[i+1]], r[i])
+				valid = false
+				break
+			}
+		}
+		if valid {
+			g.routes[asset] = r
+			return r, true
+		}
+	}
+}
+
+func (g *Graph) search(asset string) []string {
+	type node struct {
+		asset string
+		path  []string
+	}
+	visited := map[string]bool{asset: true}
+	queue := []node{{asset, []string{asset}}}
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		if peg(n.asset) != "" {
+			return n.path
+		}
+		if len(n.path) > maxHops {
+			continue
+		}
+		for _, next := range g.neighbors(n.asset) {
+			if visited[next] || g.missing(n.asset, next) {
+				continue
+			}
+			visited[next] = true
+			path := append(append([]string{}, n.path...), next)
+			queue = append(queue, node{next, path})
+		}
+	}
+	return nil
+}
+
+func (g *Graph) missing(a, b string) bool {
+	ab, checkedAB := g.closes[a+b]
+	ba, checkedBA := g.closes[b+a]
+	return checkedAB && checkedBA && ab == nil && ba == nil
+}
+
+// market is whether a and b trade against each other in either direction
+func (g *Graph) market(a, b string) bool {
+	return g.fetch(a+b) != nil || g.fetch(b+a) != nil
+}
+
+type kline []interface{}
+
+// fetch reads daily closes of a binance symbol since the graph's earliest trade
+func (g *Graph) fetch(symbol string) map[string]float64 {
+	if closes, ok := g.closes[symbol]; ok {
+		return closes
+	}
+	g.closes[symbol] = nil
+	closes := map[string]float64{}
+	for start := g.since; start.Before(time.Now()); {
+		url := fmt.Sprintf("https://api.binance.com/api/v3/klines?symbol=%s&interval=1d&startTime=%d&limit=1000", symbol, start.UnixMilli())
+		res, err := g.client.Get(url)
+		if err != nil {
+			fmt.Println(err)
+			return nil
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil || res.StatusCode != http.StatusOK {
+			// invalid symbol
+			return nil
+		}
+		var klines []kline
+		err = json.Unmarshal(body, &klines)
+		if err != nil || len(klines) < 1 {
+			break
+		}
+		for _, k := range klines {
+			if len(k) < 5 {
+				continue
+			}
+			open, _ := k[0].(float64)
+			c, err := strconv.ParseFloat(fmt.Sprint(k[4]), 64)
+			if err != nil {
+				continue
+			}
+			closes[time.UnixMilli(int64(open)).UTC().Format("2006-01-02")] = c
+		}
+		last, _ := klines[len(klines)-1][0].(float64)
+		start = time.UnixMilli(int64(last)).Add(24 * time.Hour)
+		if len(klines) < 1000 {
+			break
+		}
+	}
+	if len(closes) < 1 {
+		return nil
+	}
+	g.closes[symbol] = closes
+	return closes
+}
+
+// closeAt is the daily close of a symbol on t or the nearest day after it.
+// Markets listed after a trade are priced at their first close
+func closeAt(closes map[string]float64, t time.Time) float64 {
+	for day := t.UTC(); !day.After(time.Now().Add(24 * time.Hour)); day = day.Add(24 * time.Hour) {
+		if c, ok := closes[day.Format("2006-01-02")]; ok {
+			return c
+		}
+	}
+	return 0
+}
+
+// rate is what one unit of asset was worth in the fiat its route ends in at t
+func (g *Graph) rate(asset string, t time.Time) (string, float64, bool) {
+	r, ok := g.route(asset)
+	if !ok {
+		return "", 0, false
+	}
+	rate := 1.0
+	for i := 0; i+1 < len(r); i++ {
+		a, b := r[i], r[i+1]
+		if closes := g.fetch(a + b); closes != nil {
+			rate *= closeAt(closes, t)
+			continue
+		}
+		c := closeAt(g.fetch(b+a), t)
+		if c == 0 {
+			return "", 0, false
+		}
+		rate /= c
+	}
+	if rate == 0 {
+		return "", 0, false
+	}
+	return peg(r[len(r)-1]), rate, true
+}
+
+// path describes how an asset is valued
+func (g *Graph) path(asset, currency string) string {
+	r, ok := g.route(asset)
+	if !ok {
+		return ""
+	}
+	return strings.Join(append(append([]string{}, r...), strings.ToUpper(currency)), " → ")
+}
@@ -0,0 +1,158 @@
+package model
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// Snapshot is the portfolio at the end of a day in the reporting currency
+type Snapshot struct {
+	Date  string  `json:"date"`
+	Value float64 `json:"value"`
+	// cost basis of what is held
+	Invested   float64 `json:"invested"`
+	Realized   float64 `json:"realized"`
+	Unrealized float64 `json:"unrealized"`
+}
+
+// History is daily snapshots replayed from the ledger
+type History struct {
+	Currency string `json:"currency"`
+	Method   string `json:"method"`
+	// last update of the payload it was replayed from
+	LastUpdate time.Time  `json:"last_update"`
+	Snapshots  []Snapshot `json:"snapshots"`
+}
+
+// Stale is whether the history no longer matches the payload or the request
+func (h History) Stale(payload Payload, opts Options) bool {
+	if len(h.Snapshots) < 1 || !h.LastUpdate.Equal(payload.LastUpdate) {
+		return true
+	}
+	opts = opts.Defaults()
+	if h.Currency != opts.Currency || h.Method != opts.Method {
+		return true
+	}
+	return h.Snapshots[len(h.Snapshots)-1].Date != time.Now().UTC().Format("2006-01-02")
+}
+
+// day is the portfolio and each asset at a day's close
+type day struct {
+	time     time.Time
+	snapshot Snapshot
+	// value of each asset held
+	values map[string]float64
+	// money put into each asset that day. Negative when sold
+	flows map[string]float64
+}
+
+// replay goes through the ledger a day at a time and values what was held at each day's close.
+// Trades quoted in fiat or stablecoins are cash going in and out. Trades quoted in other crypto
+// swap one asset for another and move its lots, as do deposits and withdrawals with no other side.
+// Trades on record before the ledger was kept are replayed like taxEvents does
+func (v Valuer) replay(payload Payload, method string) []day {
+	var txs []Transaction
+	for _, t := range payload.unrecorded() {
+		txs = append(txs, t.Transaction)
+	}
+	for _, t := range payload.Ledger {
+		// transfers between sources never left the portfolio
+		if !strings.HasPrefix(t.ID, "transfer:") {
+			txs = append(txs, t)
+		}
+	}
+	var transfers []Transfer
+	for _, t := range payload.Transfers {
+		if t.Match == "" && peg(t.Asset) == "" {
+			transfers = append(transfers, t)
+		}
+	}
+	if len(txs) < 1 {
+		return nil
+	}
+	sort.SliceStable(txs, func(i, j int) bool {
+		return txs[i].Time.Before(txs[j].Time)
+	})
+	sort.SliceStable(transfers, func(i, j int) bool {
+		return transfers[i].Time.Before(transfers[j].Time)
+	})
+	lots := map[string][]lot{}
+	realized := 0.0
+	var days []day
+	next, nextTransfer := 0, 0
+	first := txs[0].Time
+	if len(transfers) > 0 && transfers[0].Time.Before(first) {
+		first = transfers[0].Time
+	}
+	start := first.UTC().Truncate(24 * time.Hour)
+	for date := start; !date.After(time.Now()); date = date.Add(24 * time.Hour) {
+		d := day{time: date, values: map[string]float64{}, flows: map[string]float64{}}
+		end := date.Add(24 * time.Hour)
+		for ; nextTransfer < len(transfers) && transfers[nextTransfer].Time.Before(end); nextTransfer++ {
+			t := transfers[nextTransfer]
+			asset := strings.ToUpper(t.Asset)
+			amount := v.value(t.Asset, t.Amount, t.Time)
+			if t.Deposit {
+				lots[asset] = append(lots[asset], lot{t.Time, t.Amount, amount})
+				d.flows[asset] += amount
+				continue
+			}
+			held := lots[asset]
+			consume(&held, t.Amount+t.Fee, method)
+			lots[asset] = held
+			d.flows[asset] -= amount
+		}
+		for ; next < len(txs) && txs[next].Time.Before(end); next++ {
+			t := txs[next]
+			asset := strings.ToUpper(t.Base)
+			quote := strings.ToUpper(t.Quote)
+			amount := v.value(t.Quote, t.Price*t.Qty, t.Time)
+			swap := peg(t.Quote) == ""
+			if t.IsBuyer {
+				lots[asset] = append(lots[asset], lot{t.Time, t.Qty, amount})
+				d.flows[asset] += amount
+				if swap {
+					spent := lots[quote]
+					realized += amount - consume(&spent, t.Price*t.Qty, method)
+					lots[quote] = spent
+					d.flows[quote] -= amount
+				}
+				continue
+			}
+			held := lots[asset]
+			realized += amount - consume(&held, t.Qty, method)
+			lots[asset] = held
+			d.flows[asset] -= amount
+			if swap {
+				lots[quote] = append(lots[quote], lot{t.Time, t.Price * t.Qty, amount})
+				d.flows[quote] += amount
+			}
+		}
+		s := Snapshot{Date: date.Format("2006-01-02"), Realized: realized}
+		for asset, held := range lots {
+			qty := 0.0
+			for _, l := range held {
+				qty += l.qty
+				s.Invested += l.cost
+			}
+			if qty > 1e-12 {
+				d.values[asset] = v.value(asset, qty, date)
+				s.Value += d.values[asset]
+			}
+		}
+		s.Unrealized = s.Value - s.Invested
+		d.snapshot = s
+		days = append(days, d)
+	}
+	return days
+}
+
+// History is the portfolio at each day's close since the first trade on record
+func (v Valuer) History(payload Payload, method string) []Snapshot {
+	var snapshots []Snapshot
+	for _, d := range v.replay(payload, method) {
+		snapshots = append(snapshots, d.snapshot)
+	}
+	return snapshots
+}
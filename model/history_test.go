@@ -0,0 +1,60 @@
+package model
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestReplaySwapsQuoteLots(t *testing.T) {
+	v := NewValuer("usd", map[string]Coin{"btc": {Price: 100}, "eth": {Price: 10}}, FX{}, nil)
+	start := time.Now().UTC().AddDate(0, 0, -2)
+	payload := Payload{Ledger: []Transaction{
+		{ID: "1", Base: "BTC", Quote: "USDT", Time: start, IsBuyer: true, Price: 100, Qty: 1},
+		{ID: "2", Base: "ETH", Quote: "BTC", Time: start.Add(time.Hour), IsBuyer: true, Price: 0.1, Qty: 10},
+	}}
+	days := v.replay(payload, FIFO)
+	if len(days) < 1 {
+		t.Fatal("no days replayed")
+	}
+	last := days[len(days)-1].snapshot
+	if math.Abs(last.Value-100) > 1e-9 || math.Abs(last.Invested-100) > 1e-9 {
+		t.Errorf("got value %g invested %g, want 100 and 100", last.Value, last.Invested)
+	}
+	flow := 0.0
+	for _, d := range days {
+		for _, f := range d.flows {
+			flow += f
+		}
+	}
+	if math.Abs(flow-100) > 1e-9 {
+		t.Errorf("got flows of %g, want only the 100 USDT put in", flow)
+	}
+}
+
+func TestReplayUnrecordedTrades(t *testing.T) {
+	v := NewValuer("usd", map[string]Coin{"btc": {Price: 100}}, FX{}, nil)
+	start := time.Now().UTC().AddDate(0, 0, -3)
+	// 2 BTC bought for 100 before the ledger was kept and 1 sold for 60 on it
+	payload := Payload{
+		Binance: map[string]Asset{"BTC": {Pairs: map[string]Pair{"USDT": {
+			BuyQty: 2, Cost: 100, SellQty: 1, Revenue: 60,
+			EarliestTrade: &Trade{Time: start},
+			LatestTrade:   &Trade{Time: start.Add(24 * time.Hour)},
+		}}}},
+		Ledger: []Transaction{
+			{ID: "1", Source: "binance", Base: "BTC", Quote: "USDT", Time: start.Add(24 * time.Hour), Price: 60, Qty: 1},
+		},
+	}
+	days := v.replay(payload, FIFO)
+	if len(days) < 1 {
+		t.Fatal("no days replayed")
+	}
+	if !days[0].time.Equal(start.Truncate(24 * time.Hour)) {
+		t.Errorf("replay starts %s, want the pair's earliest trade", days[0].time)
+	}
+	last := days[len(days)-1].snapshot
+	if math.Abs(last.Value-100) > 1e-9 || math.Abs(last.Invested-50) > 1e-9 || math.Abs(last.Realized-10) > 1e-9 {
+		t.Errorf("got value %g invested %g realized %g, want 100, 50 and 10", last.Value, last.Invested, last.Realized)
+	}
+}
@@ -0,0 +1,332 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ImportPrefix starts the ids of transactions imported from csv
+const ImportPrefix = "import:"
+
+// Transaction is a single trade kept as is so it can be deduplicated
+// against later fetches and imports
+type Transaction struct {
+	ID       string    `json:"id"`
+	Source   string    `json:"source"`
+	Base     string    `json:"base"`
+	Quote    string    `json:"quote"`
+	Time     time.Time `json:"time"`
+	IsBuyer  bool      `json:"is_buyer"`
+	Price    float64   `json:"price"`
+	Qty      float64   `json:"qty"`
+	Fee      float64   `json:"fee"`
+	FeeAsset string    `json:"fee_asset"`
+	Note     string    `json:"note,omitempty"`
+}
+
+// Key identifies a transaction by its contents. Fills of the same order can share one.
+// Exports are only precise to the second so api trades are truncated to match
+func (t Transaction) Key() string {
+	side := "sell"
+	if t.IsBuyer {
+		side = "buy"
+	}
+	return fmt.Sprintf("%s|%s|%s|%s|%d|%g|%g",
+		strings.ToLower(t.Source),
+		strings.ToUpper(t.Base),
+		strings.ToUpper(t.Quote),
+		side,
+		t.Time.Unix(),
+		t.Qty,
+		t.Price)
+}
+
+func (t Transaction) Trade() *Trade {
+	return &Trade{
+		Time:            t.Time,
+		IsBuyer:         t.IsBuyer,
+		Price:           t.Price,
+		Qty:             t.Qty,
+		Commission:      t.Fee,
+		CommissionAsset: t.FeeAsset,
+	}
+}
+
+func (t Transaction) Validate() error {
+	if t.Base == "" || t.Quote == "" {
+		return fmt.Errorf("base and quote are required")
+	}
+	if t.Qty <= 0 {
+		return fmt.Errorf("qty must be positive")
+	}
+	if t.Price < 0 || t.Fee < 0 {
+		return fmt.Errorf("price and fee cannot be negative")
+	}
+	if t.Time.IsZero() {
+		return fmt.Errorf("time is required")
+	}
+	return nil
+}
+
+// Source returns the asset map a transaction source is aggregated into
+func (p *Payload) Source(name string) (map[string]Asset, error) {
+	switch strings.ToLower(name) {
+	case "binance":
+		if p.Binance == nil {
+			p.Binance = map[string]Asset{}
+		}
+		return p.Binance, nil
+	case "kucoin":
+		if p.Kucoin == nil {
+			p.Kucoin = map[string]Asset{}
+		}
+		return p.Kucoin, nil
+	case MarginSource:
+		if p.Margin == nil {
+			p.Margin = map[string]Asset{}
+		}
+		return p.Margin, nil
+	case "wallet":
+		if p.Wallet == nil {
+			p.Wallet = map[string]Asset{}
+		}
+		return p.Wallet, nil
+	case ManualSource:
+		if p.Manual == nil {
+			p.Manual = map[string]Asset{}
+		}
+		return p.Manual, nil
+	}
+	return nil, fmt.Errorf("unknown source %s", name)
+}
+
+// Imported is whether a transaction came from a csv export. Exports have no trade ids
+func (t Transaction) Imported() bool {
+	return strings.HasPrefix(t.ID, ImportPrefix)
+}
+
+// Record appends transactions that are not yet in the ledger
+// and returns only the ones that were added.
+// Transactions are the same when their ids are. Imported rows are also matched by contents
+// against trades from the api so a trade is not counted from both. Rows with the same contents
+// are matched in order and a trade from the api takes the place of a row it was imported as
+func (p *Payload) Record(txs []Transaction) []Transaction {
+	seen := map[string]bool{}
+	fetched := map[string]int{}
+	// where imported rows are in the ledger by contents
+	imported := map[string][]int{}
+	for i, t := range p.Ledger {
+		seen[t.ID] = true
+		if t.Imported() {
+			imported[t.Key()] = append(imported[t.Key()], i)
+		} else {
+			fetched[t.Key()]++
+		}
+	}
+	rows := map[string]int{}
+	var added []Transaction
+	for _, t := range txs {
+		k := t.Key()
+		if t.Imported() {
+			n := rows[k]
+			rows[k]++
+			if seen[t.ID] || n < fetched[k] {
+				continue
+			}
+			imported[k] = append(imported[k], len(p.Ledger))
+		} else {
+			if seen[t.ID] {
+				continue
+			}
+			fetched[k]++
+			if rows := imported[k]; len(rows) > 0 {
+				seen[t.ID] = true
+				p.Ledger[rows[0]] = t
+				imported[k] = rows[1:]
+				continue
+			}
+		}
+		seen[t.ID] = true
+		p.Ledger = append(p.Ledger, t)
+		added = append(added, t)
+	}
+	return added
+}
+
+// Untracked drops transactions within the trades of a pair that are in its totals but not in the ledger.
+// Those were fetched before the ledger was kept so importing them cannot be matched and would count them twice
+func (p *Payload) Untracked(txs []Transaction) ([]Transaction, int) {
+	recorded := map[string]float64{}
+	for _, t := range p.Ledger {
+		recorded[strings.ToLower(t.Source)+"|"+strings.ToUpper(t.Base)+"|"+strings.ToUpper(t.Quote)] += t.Qty
+	}
+	var kept []Transaction
+	for _, t := range txs {
+		assets, err := p.Source(t.Source)
+		if err != nil {
+			kept = append(kept, t)
+			continue
+		}
+		pair, ok := assets[t.Base].Pairs[t.Quote]
+		k := strings.ToLower(t.Source) + "|" + strings.ToUpper(t.Base) + "|" + strings.ToUpper(t.Quote)
+		if ok && pair.EarliestTrade != nil && pair.LatestTrade != nil && pair.BuyQty+pair.SellQty-recorded[k] > 1e-9 &&
+			!t.Time.Before(pair.EarliestTrade.Time) && !t.Time.After(pair.LatestTrade.Time) {
+			continue
+		}
+		kept = append(kept, t)
+	}
+	return kept, len(txs) - len(kept)
+}
+
+// unrecordedTrade is the part of a pair's totals that is not on the ledger as one trade.
+// Trades fetched before the ledger was kept are only in the totals
+type unrecordedTrade struct {
+	Transaction
+	// fees in each asset. Transaction's own fee is unused
+	fees map[string]float64
+}
+
+// unrecorded are the buys of each pair beyond the ledger at the pair's earliest trade
+// and its sells before the first trade on the ledger. Fees beyond the ledger go with the buys if there are any
+func (p Payload) unrecorded() []unrecordedTrade {
+	var trades []unrecordedTrade
+	sources := []string{"binance", "kucoin", "manual", "margin", "wallet"}
+	for i, assets := range []map[string]Asset{p.Binance, p.Kucoin, p.Manual, p.Margin, p.Wallet} {
+		for base, a := range assets {
+			for quote, pair := range a.Pairs {
+				if pair.EarliestTrade == nil || pair.LatestTrade == nil {
+					continue
+				}
+				var buys, cost, sells, revenue float64
+				fees := map[string]float64{}
+				first := pair.LatestTrade.Time
+				for _, t := range p.Ledger {
+					// transfers are in the pair's totals too
+					if t.Source != sources[i] || !strings.EqualFold(t.Base, base) || !strings.EqualFold(t.Quote, quote) {
+						continue
+					}
+					if t.IsBuyer {
+						buys += t.Qty
+						cost += t.Price * t.Qty
+					} else {
+						sells += t.Qty
+						revenue += t.Price * t.Qty
+					}
+					fees[strings.ToUpper(t.FeeAsset)] += t.Fee
+					if t.Time.Before(first) {
+						first = t.Time
+					}
+				}
+				untracked := map[string]float64{}
+				for asset, amount := range pair.Fees {
+					if left := amount - fees[strings.ToUpper(asset)]; left > 1e-12 {
+						untracked[strings.ToUpper(asset)] = left
+					}
+				}
+				trade := func(at time.Time, isBuyer bool, qty, total float64) unrecordedTrade {
+					if total < 0 {
+						total = 0
+					}
+					return unrecordedTrade{Transaction{
+						ID:      fmt.Sprintf("unrecorded:%s:%s%s:%t", sources[i], strings.ToUpper(base), strings.ToUpper(quote), isBuyer),
+						Source:  sources[i],
+						Base:    strings.ToUpper(base),
+						Quote:   strings.ToUpper(quote),
+						Time:    at,
+						IsBuyer: isBuyer,
+						Price:   total / qty,
+						Qty:     qty,
+						Note:    "trades before the ledger was kept",
+					}, nil}
+				}
+				if pair.BuyQty-buys > 1e-9 {
+					t := trade(pair.EarliestTrade.Time, true, pair.BuyQty-buys, pair.Cost-cost)
+					t.fees, untracked = untracked, nil
+					trades = append(trades, t)
+				}
+				if pair.SellQty-sells > 1e-9 {
+					t := trade(first, false, pair.SellQty-sells, pair.Revenue-revenue)
+					t.fees = untracked
+					trades = append(trades, t)
+				}
+			}
+		}
+	}
+	return trades
+}
+
+// Merge records transactions into the ledger and aggregates the new ones
+// into their source's assets
+func (p *Payload) Merge(txs []Transaction) (int, error) {
+	for _, t := range txs {
+		if _, err := p.Source(t.Source); err != nil {
+			return 0, err
+		}
+	}
+	added := p.Record(txs)
+	grouped := map[string]map[string]map[string][]*Trade{}
+	for _, t := range added {
+		source := strings.ToLower(t.Source)
+		if _, ok := grouped[source]; !ok {
+			grouped[source] = map[string]map[string][]*Trade{}
+		}
+		if _, ok := grouped[source][t.Base]; !ok {
+			grouped[source][t.Base] = map[string][]*Trade{}
+		}
+		grouped[source][t.Base][t.Quote] = append(grouped[source][t.Base][t.Quote], t.Trade())
+	}
+	for source, bases := range grouped {
+		assets, _ := p.Source(source)
+		for base, quotes := range bases {
+			asset := assets[base]
+			for quote, trades := range quotes {
+				asset = asset.Compute(quote, trades)
+			}
+			assets[base] = asset
+		}
+	}
+	return len(added), nil
+}
+
+// LatestLedger is the time of the newest transaction whose id starts with prefix
+func (p Payload) LatestLedger(prefix string, fallback time.Time) time.Time {
+	latest := fallback
+	for _, t := range p.Ledger {
+		if strings.HasPrefix(t.ID, prefix) && t.Time.After(latest) {
+			latest = t.Time
+		}
+	}
+	return latest
+}
+
+func (p Payload) ManualTransactions() []Transaction {
+	txs := []Transaction{}
+	for _, t := range p.Ledger {
+		if t.Source == ManualSource {
+			txs = append(txs, t)
+		}
+	}
+	return txs
+}
+
+// RecomputeManual rebuilds the manual assets from the ledger.
+// Unlike fetched trades, manual ones can be edited and deleted
+func (p *Payload) RecomputeManual() {
+	grouped := map[string]map[string][]*Trade{}
+	for _, t := range p.ManualTransactions() {
+		if _, ok := grouped[t.Base]; !ok {
+			grouped[t.Base] = map[string][]*Trade{}
+		}
+		grouped[t.Base][t.Quote] = append(grouped[t.Base][t.Quote], t.Trade())
+	}
+	manual := map[string]Asset{}
+	for base, quotes := range grouped {
+		asset := Asset{}
+		for quote, trades := range quotes {
+			asset = asset.Compute(quote, trades)
+		}
+		manual[base] = asset
+	}
+	p.Manual = manual
+}
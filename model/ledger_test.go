@@ -0,0 +1,34 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecord(t *testing.T) {
+	at := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	fill := func(id string) Transaction {
+		return Transaction{ID: id, Source: "binance", Base: "BTC", Quote: "USDT", Time: at, IsBuyer: true, Price: 100, Qty: 1}
+	}
+	var p Payload
+	if added := p.Record([]Transaction{fill("binance:BTCUSDT:1"), fill("binance:BTCUSDT:2")}); len(added) != 2 {
+		t.Fatalf("got %d fills in the same second, want 2", len(added))
+	}
+	if added := p.Record([]Transaction{fill("binance:BTCUSDT:2")}); len(added) != 0 {
+		t.Errorf("recorded a fetched trade twice")
+	}
+	// one more row than was fetched
+	rows := []Transaction{fill(ImportPrefix + "a"), fill(ImportPrefix + "a-1"), fill(ImportPrefix + "a-2")}
+	if added := p.Record(rows); len(added) != 1 {
+		t.Fatalf("got %d imported rows, want the 1 not fetched", len(added))
+	}
+	if added := p.Record(rows); len(added) != 0 {
+		t.Errorf("recorded %d rows on importing again", len(added))
+	}
+	if added := p.Record([]Transaction{fill("binance:BTCUSDT:3")}); len(added) != 0 {
+		t.Errorf("recorded a fetched trade that was already imported")
+	}
+	if len(p.Ledger) != 3 {
+		t.Errorf("got %d in the ledger, want 3", len(p.Ledger))
+	}
+}
@@ -0,0 +1,248 @@
+// Package model is the stored report format and the computation over it,
+// shared by the server and the web client so both read and write the same json
+package model
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// transaction sources besides the exchanges
+const (
+	// cross and isolated margin
+	MarginSource = "margin"
+	// transactions that never appear in an exchange api.
+	// OTC buys, gifts, lost coins, hacks
+	ManualSource = "manual"
+)
+
+type Payload struct {
+	LastUpdate time.Time        `json:"last_update"`
+	Binance    map[string]Asset `json:"binance"`
+	Kucoin     map[string]Asset `json:"kucoin"`
+	Manual     map[string]Asset `json:"manual"`
+	Wallet     map[string]Asset `json:"wallet"`
+	Margin     map[string]Asset `json:"margin"`
+	Futures    Futures          `json:"futures"`
+	Ledger     []Transaction    `json:"ledger"`
+	Transfers  []Transfer       `json:"transfers"`
+	// when each source's transfers were last fetched up to now. Kept so sources without transfers are not fetched from the start again
+	TransfersFetched map[string]time.Time `json:"transfers_fetched"`
+	// distributions, interest and futures income one by one
+	Payments []Payment `json:"payments"`
+	// payments fetched before they were kept one by one were recorded from the exchange's history
+	PaymentsBackfilled bool `json:"payments_backfilled"`
+	// the user's symbol to coin overrides
+	Mappings []CoinMapping `json:"mappings"`
+	// self custody addresses read through json-rpc
+	Addresses   []string `json:"addresses"`
+	WalletBlock uint64   `json:"wallet_block"`
+	// block to scan the addresses from. The server's default when 0
+	WalletStart uint64 `json:"wallet_start"`
+	// milliseconds
+	LatestLiquidationTime int64 `json:"latest_liquidation_time"`
+	// liquidations at that millisecond
+	LiquidationIDs []int64 `json:"liquidation_ids,omitempty"`
+}
+
+type Asset struct {
+	Balance                float64         `json:"balance"`
+	Pairs                  map[string]Pair `json:"pairs"`
+	LatestDistributionTime int64           `json:"latest_distribution_time"`
+	DistributionTotal      float64         `json:"distribution_total"`
+	Earn                   Earn            `json:"earn"`
+	Loan                   Loan            `json:"loan"`
+}
+
+type Pair struct {
+	BuyQty        float64            `json:"buy_qty"`
+	Cost          float64            `json:"cost"`
+	SellQty       float64            `json:"sell_qty"`
+	Revenue       float64            `json:"revenue"`
+	Fees          map[string]float64 `json:"fees"`
+	EarliestTrade *Trade             `json:"earliest_trade"`
+	LatestTrade   *Trade             `json:"latest_trade"`
+	// highest fetched trade id. Imported trades have none
+	LastID int64 `json:"last_id"`
+}
+
+// Trade is binance-go's trade. Stored without json tags like the original
+// so existing reports still decode
+type Trade struct {
+	ID              int64
+	Price           float64
+	Qty             float64
+	Commission      float64
+	CommissionAsset string
+	Time            time.Time
+	IsBuyer         bool
+	IsMaker         bool
+	IsBestMatch     bool
+}
+
+// Earn is the part of an asset's holdings in Binance Simple Earn
+type Earn struct {
+	Flexible float64 `json:"flexible"`
+	Locked   float64 `json:"locked"`
+	// cumulative flexible rewards and accrued locked rewards.
+	// Paid out rewards are already counted in distributions
+	Rewards              float64 `json:"rewards"`
+	Redeemed             float64 `json:"redeemed"`
+	LatestRedemptionTime int64   `json:"latest_redemption_time"`
+}
+
+func (e Earn) Holdings() float64 {
+	return e.Flexible + e.Locked
+}
+
+// Loan is an asset's borrowing in cross and isolated margin
+type Loan struct {
+	// outstanding principal
+	Borrowed float64 `json:"borrowed"`
+	Loaned   float64 `json:"loaned"`
+	Repaid   float64 `json:"repaid"`
+	// accrued interest. Counted as a cost
+	Interest     float64 `json:"interest"`
+	Liquidations int     `json:"liquidations"`
+	// how far cross margin history was read
+	LoanCursors
+	// how far each isolated pair's history was read. Keyed by symbol
+	Isolated map[string]LoanCursors `json:"isolated,omitempty"`
+}
+
+// LoanCursors are how far an account's loans, repayments and interest were read.
+// Times are in milliseconds. The ids are the records at that millisecond,
+// which are read again when paging resumes from it
+type LoanCursors struct {
+	LatestLoanTime     int64   `json:"latest_loan_time"`
+	LoanIDs            []int64 `json:"loan_ids,omitempty"`
+	LatestRepayTime    int64   `json:"latest_repay_time"`
+	RepayIDs           []int64 `json:"repay_ids,omitempty"`
+	LatestInterestTime int64   `json:"latest_interest_time"`
+	InterestIDs        []int64 `json:"interest_ids,omitempty"`
+}
+
+// Futures is profit and loss from USDⓈ-M and COIN-M futures
+type Futures struct {
+	// keyed by the asset the income is paid in
+	Income    map[string]Income `json:"income"`
+	Positions []Position        `json:"positions"`
+	// milliseconds. Keyed by market
+	LatestIncomeTime map[string]int64 `json:"latest_income_time"`
+}
+
+type Income struct {
+	RealizedPnl float64 `json:"realized_pnl"`
+	Funding     float64 `json:"funding"`
+	Commission  float64 `json:"commission"`
+	// liquidation fees, insurance clear, referral kickbacks
+	Other float64 `json:"other"`
+}
+
+func (i Income) Add(incomeType string, amount float64) Income {
+	switch incomeType {
+	case "REALIZED_PNL":
+		i.RealizedPnl += amount
+	case "FUNDING_FEE":
+		i.Funding += amount
+	case "COMMISSION":
+		i.Commission += amount
+	case "TRANSFER":
+		// moving funds in and out is not profit
+	default:
+		i.Other += amount
+	}
+	return i
+}
+
+// Position is an open futures position
+type Position struct {
+	Market        string  `json:"market"`
+	Symbol        string  `json:"symbol"`
+	Side          string  `json:"side"`
+	Amount        float64 `json:"amount"`
+	EntryPrice    float64 `json:"entry_price"`
+	MarkPrice     float64 `json:"mark_price"`
+	UnrealizedPnl float64 `json:"unrealized_pnl"`
+	Leverage      float64 `json:"leverage"`
+	// asset the pnl is in. The quote for usdm, the base for coinm
+	Asset string `json:"asset"`
+}
+
+// CoinMapping pins an exchange's symbol to a coingecko coin id.
+// An empty exchange applies to every exchange
+type CoinMapping struct {
+	Exchange string `json:"exchange"`
+	Symbol   string `json:"symbol"`
+	CoinID   string `json:"coin_id"`
+}
+
+func (p Payload) Persist(path string) error {
+	p.LastUpdate = time.Now()
+	file, err := json.Marshal(p)
+	if err != nil {
+		err = errors.Wrap(err, "encoding")
+		return err
+	}
+	// TODO: encrypt
+	err = ioutil.WriteFile(path, file, 0644)
+	if err != nil {
+		err = errors.Wrap(err, "persisting")
+		return err
+	}
+	return nil
+}
+
+func (a Asset) Compute(selling string, trades []*Trade) Asset {
+	pair := Pair{}
+	if value, ok := a.Pairs[selling]; ok {
+		pair = value
+	}
+	earliest := pair.EarliestTrade
+	latest := pair.LatestTrade
+	if pair.LastID == 0 && latest != nil {
+		pair.LastID = latest.ID
+	}
+	fees := map[string]float64{}
+	for k, v := range pair.Fees {
+		fees[k] = v
+	}
+	for _, t := range trades {
+		fees[t.CommissionAsset] += t.Commission
+		if t.ID > pair.LastID {
+			pair.LastID = t.ID
+		}
+		if t.IsBuyer {
+			pair.BuyQty += t.Qty
+			pair.Cost += t.Price * t.Qty
+		}
+		if !t.IsBuyer {
+			pair.SellQty += t.Qty
+			pair.Revenue += t.Price * t.Qty
+		}
+		if earliest == nil {
+			earliest = t
+		}
+		if latest == nil {
+			latest = t
+		}
+		if earliest.Time.Unix() > t.Time.Unix() {
+			earliest = t
+		}
+		if latest.Time.Unix() < t.Time.Unix() {
+			latest = t
+		}
+	}
+	pair.EarliestTrade = earliest
+	pair.LatestTrade = latest
+	pair.Fees = fees
+	new := a
+	if new.Pairs == nil {
+		new.Pairs = map[string]Pair{}
+	}
+	new.Pairs[selling] = pair
+	return new
+}
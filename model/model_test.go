@@ -0,0 +1,115 @@
+package model
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// zeroFields are the paths of fields left at their zero value, so new fields
+// are not left out of the round trip
+func zeroFields(v reflect.Value, path string) []string {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return []string{path}
+		}
+		return zeroFields(v.Elem(), path)
+	case reflect.Struct:
+		if v.Type() == reflect.TypeOf(time.Time{}) {
+			if v.Interface().(time.Time).IsZero() {
+				return []string{path}
+			}
+			return nil
+		}
+		var zero []string
+		for i := 0; i < v.NumField(); i++ {
+			if !v.Type().Field(i).IsExported() {
+				continue
+			}
+			zero = append(zero, zeroFields(v.Field(i), path+"."+v.Type().Field(i).Name)...)
+		}
+		return zero
+	case reflect.Slice, reflect.Map:
+		if v.Len() == 0 {
+			return []string{path}
+		}
+		if v.Kind() == reflect.Slice {
+			return zeroFields(v.Index(0), path+"[0]")
+		}
+		iter := v.MapRange()
+		iter.Next()
+		return zeroFields(iter.Value(), path+"["+iter.Key().String()+"]")
+	}
+	if v.IsZero() {
+		return []string{path}
+	}
+	return nil
+}
+
+func TestPayloadRoundTrip(t *testing.T) {
+	at := time.Date(2023, 6, 1, 12, 30, 15, 0, time.UTC)
+	cursors := LoanCursors{
+		LatestLoanTime: at.UnixMilli(), LoanIDs: []int64{1},
+		LatestRepayTime: at.UnixMilli(), RepayIDs: []int64{2},
+		LatestInterestTime: at.UnixMilli(), InterestIDs: []int64{3},
+	}
+	trade := &Trade{ID: 7, Price: 100, Qty: 1.5, Commission: 0.001, CommissionAsset: "BNB", Time: at, IsBuyer: true, IsMaker: true, IsBestMatch: true}
+	asset := Asset{
+		Balance: 1.5,
+		Pairs: map[string]Pair{"USDT": {
+			BuyQty: 2, Cost: 200, SellQty: 0.5, Revenue: 60,
+			Fees:          map[string]float64{"BNB": 0.002},
+			EarliestTrade: trade,
+			LatestTrade:   trade,
+			LastID:        7,
+		}},
+		LatestDistributionTime: at.UnixMilli(),
+		DistributionTotal:      0.1,
+		Earn:                   Earn{Flexible: 1, Locked: 2, Rewards: 0.01, Redeemed: 0.5, LatestRedemptionTime: at.UnixMilli()},
+		Loan: Loan{Borrowed: 10, Loaned: 20, Repaid: 10, Interest: 0.2, Liquidations: 1,
+			LoanCursors: cursors, Isolated: map[string]LoanCursors{"BTCUSDT": cursors}},
+	}
+	payload := Payload{
+		LastUpdate: at,
+		Binance:    map[string]Asset{"BTC": asset},
+		Kucoin:     map[string]Asset{"ETH": asset},
+		Manual:     map[string]Asset{"SOL": asset},
+		Wallet:     map[string]Asset{"ETH": asset},
+		Margin:     map[string]Asset{"BTC": asset},
+		Futures: Futures{
+			Income:           map[string]Income{"USDT": {RealizedPnl: 12, Funding: -0.5, Commission: -0.1, Other: 0.01}},
+			Positions:        []Position{{Market: "usdm", Symbol: "BTCUSDT", Side: "LONG", Amount: 0.1, EntryPrice: 100, MarkPrice: 110, UnrealizedPnl: 1, Leverage: 5, Asset: "USDT"}},
+			LatestIncomeTime: map[string]int64{"usdm": at.UnixMilli()},
+		},
+		Ledger: []Transaction{{ID: "binance:BTCUSDT:7", Source: "binance", Base: "BTC", Quote: "USDT", Time: at, IsBuyer: true,
+			Price: 100, Qty: 1.5, Fee: 0.001, FeeAsset: "BNB", Note: "dca"}},
+		Transfers: []Transfer{{ID: "binance:withdrawal:1", Source: "binance", Asset: "BTC", Amount: 0.5, Fee: 0.0005, Time: at,
+			TxHash: "0xabc", Address: "0xdef", Deposit: true, Match: "wallet:1", Unmatched: true}},
+		TransfersFetched: map[string]time.Time{"binance": at},
+		Payments: []Payment{{ID: "binance:distribution:BTC:1", Source: "binance", Asset: "BTC", Kind: DistributionPayment, Time: at,
+			Amount: 0.1, Note: "airdrop"}},
+		PaymentsBackfilled:    true,
+		Mappings:              []CoinMapping{{Exchange: "binance", Symbol: "LUNA", CoinID: "terra-luna-2"}},
+		Addresses:             []string{"0xdef"},
+		WalletBlock:           17000000,
+		WalletStart:           16000000,
+		LatestLiquidationTime: at.UnixMilli(),
+		LiquidationIDs:        []int64{4},
+	}
+	if zero := zeroFields(reflect.ValueOf(payload), "Payload"); len(zero) > 0 {
+		t.Fatalf("populate %v", zero)
+	}
+	content, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Payload
+	if err := json.Unmarshal(content, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(payload, decoded) {
+		t.Errorf("got\n%+v\nwant\n%+v", decoded, payload)
+	}
+}
@@ -0,0 +1,90 @@
+package model
+
+import (
+	"strings"
+	"time"
+)
+
+// payment kinds
+const (
+	DistributionPayment = "distribution"
+	// simple earn and staking rewards paid out
+	RewardPayment = "reward"
+	// margin interest
+	InterestPayment   = "interest"
+	FuturesPnlPayment = "futures_pnl"
+	FundingPayment    = "funding"
+	FuturesFeePayment = "futures_fee"
+	// liquidation fees, insurance clear, referral kickbacks
+	FuturesOtherPayment = "futures_other"
+)
+
+// Payment is an asset received or paid outside of trading.
+// Kept one by one so exports and statements can date and value each
+type Payment struct {
+	ID     string    `json:"id"`
+	Source string    `json:"source"`
+	Asset  string    `json:"asset"`
+	Kind   string    `json:"kind"`
+	Time   time.Time `json:"time"`
+	// negative when paid
+	Amount float64 `json:"amount"`
+	Note   string  `json:"note,omitempty"`
+}
+
+// RecordPayments appends payments that are not yet recorded
+func (p *Payload) RecordPayments(payments []Payment) int {
+	seen := map[string]bool{}
+	for _, t := range p.Payments {
+		seen[t.ID] = true
+	}
+	added := 0
+	for _, t := range payments {
+		if seen[t.ID] {
+			continue
+		}
+		seen[t.ID] = true
+		p.Payments = append(p.Payments, t)
+		added++
+	}
+	return added
+}
+
+// unrecordedDistributions are distributions in an asset's total that have no payment.
+// Received before payments were kept and no longer in the exchange's history
+func (p Payload) unrecordedDistributions() []Payment {
+	recorded := map[string]float64{}
+	earliest := map[string]time.Time{}
+	for _, t := range p.Payments {
+		if t.Source != "binance" || (t.Kind != DistributionPayment && t.Kind != RewardPayment) {
+			continue
+		}
+		asset := strings.ToUpper(t.Asset)
+		recorded[asset] += t.Amount
+		if e, ok := earliest[asset]; !ok || t.Time.Before(e) {
+			earliest[asset] = t.Time
+		}
+	}
+	var missing []Payment
+	for symbol, a := range p.Binance {
+		asset := strings.ToUpper(symbol)
+		left := a.DistributionTotal - recorded[asset]
+		if left <= 1e-9 {
+			continue
+		}
+		at, ok := earliest[asset]
+		if !ok {
+			at = time.UnixMilli(a.LatestDistributionTime).UTC()
+		}
+		missing = append(missing, Payment{
+			ID:     "binance:distribution:" + asset + ":earlier",
+			Source: "binance",
+			Asset:  asset,
+			Kind:   DistributionPayment,
+			Time:   at,
+			Amount: left,
+			Note:   "distributions before payments were kept",
+		})
+	}
+	return missing
+}
@@ -0,0 +1,523 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// default order prices are looked up in. Later providers fill in what earlier ones miss
+var DefaultProviders = []string{"coingecko", "binance", "kucoin", "static"}
+
+// PriceProvider gives current prices of symbols in the reporting currency
+type PriceProvider interface {
+	Name() string
+	// keyed by lowercase symbol. Symbols it has no price for are left out
+	Prices(symbols []string, currency string) (map[string]Coin, error)
+}
+
+// Providers builds price providers by name in priority order.
+// The static file is read from the same server as the report
+func Providers(names []string, client *http.Client, fx FX, mapped map[string]string, latestURL string) []PriceProvider {
+	var list []PriceProvider
+	for _, name := range names {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "coingecko":
+			// the server's price cache first, then coingecko itself
+			var apis []priceAPI
+			if u, err := url.Parse(latestURL); err == nil {
+				u.Path = ""
+				u.RawQuery = ""
+				apis = append(apis, priceAPI{u.String() + "/coins", u.String() + "/prices"})
+			}
+			apis = append(apis, priceAPI{"https://api.coingecko.com/api/v3/coins/list", "https://api.coingecko.com/api/v3/simple/price"})
+			list = append(list, coingecko{client, mapped, apis})
+		case "binance":
+			list = append(list, binanceTickers{client, fx})
+		case "kucoin":
+			list = append(list, kucoinTickers{client, fx})
+		case "static":
+			u, err := url.Parse(latestURL)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+			u.Path = "/prices.json"
+			list = append(list, staticPrices{client, fx, u.String()})
+		default:
+			fmt.Println("unknown price provider", name)
+		}
+	}
+	return list
+}
+
+// FetchPrices asks each provider in order for the symbols the ones before it had no price for.
+// A provider failing only means the next one is asked
+func FetchPrices(list []PriceProvider, symbols []string, currency string) map[string]Coin {
+	coins := map[string]Coin{}
+	for _, p := range list {
+		var missing []string
+		for _, s := range symbols {
+			if coins[s].Price <= 0 {
+				missing = append(missing, s)
+			}
+		}
+		if len(missing) < 1 {
+			break
+		}
+		prices, err := p.Prices(missing, currency)
+		if err != nil {
+			fmt.Printf("%s prices: %v\n", p.Name(), err)
+			continue
+		}
+		for s, c := range prices {
+			if c.Price <= 0 {
+				continue
+			}
+			c.Source = p.Name()
+			coins[s] = c
+		}
+	}
+	return coins
+}
+
+// Symbols are every asset that needs a price
+func (p Payload) Symbols() []string {
+	set := map[string]bool{}
+	for symbol, asset := range p.Binance {
+		if len(asset.Pairs) < 1 && asset.Earn.Flexible+asset.Earn.Locked <= 0 {
+			continue
+		}
+		set[strings.ToLower(symbol)] = true
+		for quote := range asset.Pairs {
+			set[strings.ToLower(quote)] = true
+		}
+	}
+	for _, assets := range []map[string]Asset{p.Kucoin, p.Manual, p.Margin} {
+		for symbol, asset := range assets {
+			if len(asset.Pairs) < 1 && asset.Loan.Borrowed+asset.Loan.Interest <= 0 {
+				continue
+			}
+			set[strings.ToLower(symbol)] = true
+			for quote := range asset.Pairs {
+				set[strings.ToLower(quote)] = true
+			}
+		}
+	}
+	for symbol, asset := range p.Wallet {
+		if asset.Balance > 0 || len(asset.Pairs) > 0 {
+			set[strings.ToLower(symbol)] = true
+		}
+	}
+	for symbol := range p.Futures.Income {
+		set[strings.ToLower(symbol)] = true
+	}
+	for _, position := range p.Futures.Positions {
+		set[strings.ToLower(position.Asset)] = true
+	}
+	var symbols []string
+	for s := range set {
+		symbols = append(symbols, s)
+	}
+	return symbols
+}
+
+type coingecko struct {
+	client *http.Client
+	// coin ids by lowercase symbol
+	mapped map[string]string
+	// tried in order
+	apis []priceAPI
+}
+
+// priceAPI serves coingecko's coins/list and simple/price
+type priceAPI struct {
+	coins  string
+	prices string
+}
+
+func (c coingecko) Name() string {
+	return "coingecko"
+}
+
+func (c coingecko) Prices(symbols []string, currency string) (map[string]Coin, error) {
+	var err error
+	for _, api := range c.apis {
+		var coinlist []Coin
+		coinlist, err = fetchCoinList(c.client, api.coins)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		var coins map[string]Coin
+		coins, err = matchCoins(c.client, api.prices, symbols, coinlist, currency, c.mapped)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		return coins, nil
+	}
+	return nil, err
+}
+
+func fetchCoinList(client *http.Client, endpoint string) ([]Coin, error) {
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("cache-control", "max-age=86400")
+	req.Header.Add("pragma", "max-age=86400")
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("coin list: %s", res.Status)
+	}
+	var coinlist []Coin
+	err = json.Unmarshal(body, &coinlist)
+	if err != nil {
+		return nil, err
+	}
+	return coinlist, nil
+}
+
+// matchCoins prices symbols by their mapped coin id, or by every coin listed under the symbol.
+// When several coins share an unmapped symbol the one with the highest market cap is used
+// and the others are kept as ambiguous
+func matchCoins(client *http.Client, endpoint string, symbols []string, coinlist []Coin, currency string, mapped map[string]string) (map[string]Coin, error) {
+	wanted := map[string]bool{}
+	// symbols each coin id is a candidate for
+	candidates := map[string][]string{}
+	for _, s := range symbols {
+		if id, ok := mapped[s]; ok {
+			candidates[id] = append(candidates[id], s)
+			continue
+		}
+		wanted[s] = true
+	}
+	names := map[string]Coin{}
+	for _, coin := range coinlist {
+		names[coin.ID] = coin
+		token := strings.ToLower(coin.Symbol)
+		if strings.Contains(strings.ToLower(coin.ID), "wormhole") {
+			// it's never this
+			continue
+		}
+		if wanted[token] {
+			candidates[coin.ID] = append(candidates[coin.ID], token)
+		}
+	}
+	coins := map[string]Coin{}
+	if len(candidates) < 1 {
+		return coins, nil
+	}
+
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	q := req.URL.Query()
+	var ids []string
+	for c := range candidates {
+		ids = append(ids, c)
+	}
+	q.Add("ids", strings.Join(ids, ","))
+	q.Add("vs_currencies", currency)
+	q.Add("include_24hr_change", "true")
+	q.Add("include_market_cap", "true")
+	req.URL.RawQuery = q.Encode()
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		// rate limited
+		return nil, fmt.Errorf("simple price: %s", res.Status)
+	}
+	var data map[string]map[string]float64
+	err = json.Unmarshal(body, &data)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range data {
+		marketCap := v[currency+"_market_cap"]
+		for _, symbol := range candidates[k] {
+			old, ok := coins[symbol]
+			if ok && old.MarketCap >= marketCap {
+				old.Ambiguous = append(old.Ambiguous, k)
+				coins[symbol] = old
+				continue
+			}
+			new := Coin{
+				ID:        k,
+				Name:      names[k].Name,
+				Symbol:    symbol,
+				MarketCap: marketCap,
+				Change:    v[currency+"_24h_change"],
+				Price:     v[currency],
+				Mapped:    mapped[symbol] == k,
+			}
+			if ok {
+				new.Ambiguous = append(old.Ambiguous, old.ID)
+			}
+			coins[symbol] = new
+		}
+	}
+	return coins, nil
+}
+
+// tickers are last prices in usd and 24 hour change keyed by uppercase symbol
+type tickers map[string][2]float64
+
+// usdQuotes are tried in order to price a symbol
+var usdQuotes = []string{"USDT", "BUSD", "USDC", "FDUSD"}
+
+// coins prices symbols against a usd stablecoin or through btc
+func (t tickers) coins(symbols []string, currency string, fx FX, pair func(base, quote string) string) map[string]Coin {
+	coins := map[string]Coin{}
+	now := time.Now()
+	usd := func(symbol string) ([2]float64, bool) {
+		for _, q := range usdQuotes {
+			if ticker, ok := t[pair(symbol, q)]; ok {
+				return ticker, true
+			}
+		}
+		return [2]float64{}, false
+	}
+	for _, s := range symbols {
+		symbol := strings.ToUpper(s)
+		ticker, ok := usd(symbol)
+		if !ok {
+			btc, okBTC := usd("BTC")
+			inBTC, okPair := t[pair(symbol, "BTC")]
+			if !okBTC || !okPair {
+				continue
+			}
+			ticker = [2]float64{inBTC[0] * btc[0], inBTC[1]}
+		}
+		coins[s] = Coin{
+			Symbol: s,
+			Name:   symbol,
+			Price:  fx.convert(ticker[0], "usd", currency, now),
+			Change: ticker[1],
+		}
+	}
+	return coins
+}
+
+type binanceTickers struct {
+	client *http.Client
+	fx     FX
+}
+
+func (b binanceTickers) Name() string {
+	return "binance"
+}
+
+func (b binanceTickers) Prices(symbols []string, currency string) (map[string]Coin, error) {
+	res, err := b.client.Get("https://api.binance.com/api/v3/ticker/24hr")
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("binance tickers: %s", res.Status)
+	}
+	var data []struct {
+		Symbol             string `json:"symbol"`
+		LastPrice          string `json:"lastPrice"`
+		PriceChangePercent string `json:"priceChangePercent"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&data)
+	if err != nil {
+		return nil, err
+	}
+	t := tickers{}
+	for _, d := range data {
+		last, err := strconv.ParseFloat(d.LastPrice, 64)
+		if err != nil || last <= 0 {
+			continue
+		}
+		change, _ := strconv.ParseFloat(d.PriceChangePercent, 64)
+		t[d.Symbol] = [2]float64{last, change}
+	}
+	return t.coins(symbols, currency, b.fx, func(base, quote string) string {
+		return base + quote
+	}), nil
+}
+
+type kucoinTickers struct {
+	client *http.Client
+	fx     FX
+}
+
+func (k kucoinTickers) Name() string {
+	return "kucoin"
+}
+
+func (k kucoinTickers) Prices(symbols []string, currency string) (map[string]Coin, error) {
+	res, err := k.client.Get("https://api.kucoin.com/api/v1/market/allTickers")
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("kucoin tickers: %s", res.Status)
+	}
+	var data struct {
+		Data struct {
+			Ticker []struct {
+				Symbol     string `json:"symbol"`
+				Last       string `json:"last"`
+				ChangeRate string `json:"changeRate"`
+			} `json:"ticker"`
+		} `json:"data"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&data)
+	if err != nil {
+		return nil, err
+	}
+	t := tickers{}
+	for _, d := range data.Data.Ticker {
+		last, err := strconv.ParseFloat(d.Last, 64)
+		if err != nil || last <= 0 {
+			continue
+		}
+		change, _ := strconv.ParseFloat(d.ChangeRate, 64)
+		t[d.Symbol] = [2]float64{last, change * 100}
+	}
+	return t.coins(symbols, currency, k.fx, func(base, quote string) string {
+		return base + "-" + quote
+	}), nil
+}
+
+// staticPrices reads a json file of fixed prices.
+// {"currency": "usd", "prices": {"BTC": 30000}}
+type staticPrices struct {
+	client *http.Client
+	fx     FX
+	url    string
+}
+
+func (s staticPrices) Name() string {
+	return "static"
+}
+
+func (s staticPrices) Prices(symbols []string, currency string) (map[string]Coin, error) {
+	res, err := s.client.Get(s.url)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", s.url, res.Status)
+	}
+	var file struct {
+		Currency string             `json:"currency"`
+		Prices   map[string]float64 `json:"prices"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&file)
+	if err != nil {
+		return nil, err
+	}
+	from := strings.ToLower(file.Currency)
+	if from == "" {
+		from = "usd"
+	}
+	prices := map[string]float64{}
+	for symbol, price := range file.Prices {
+		prices[strings.ToLower(symbol)] = price
+	}
+	coins := map[string]Coin{}
+	for _, symbol := range symbols {
+		price, ok := prices[symbol]
+		if !ok {
+			continue
+		}
+		coins[symbol] = Coin{
+			Symbol: symbol,
+			Name:   strings.ToUpper(symbol),
+			Price:  s.fx.convert(price, from, currency, time.Now()),
+		}
+	}
+	return coins, nil
+}
+
+// CoinIDs resolves each held symbol to a coin id. Mappings are in increasing precedence
+// as the server layers them, built-in, server then user, with mappings for every exchange
+// before those for one exchange within a layer. The last one for the symbol on an exchange wins.
+// Keyed by lowercase symbol, and by exchange:symbol where exchanges holding it resolve it to different coins
+func (p Payload) CoinIDs(mappings []CoinMapping) map[string]string {
+	held := map[string]map[string]Asset{
+		"binance": p.Binance,
+		"kucoin":  p.Kucoin,
+		"margin":  p.Margin,
+		"wallet":  p.Wallet,
+		"manual":  p.Manual,
+	}
+	ids := map[string]string{}
+	for _, m := range mappings {
+		if m.Exchange == "" {
+			ids[strings.ToLower(m.Symbol)] = m.CoinID
+		}
+	}
+	resolve := func(exchange, symbol string) string {
+		id := ""
+		for _, m := range mappings {
+			if strings.EqualFold(m.Symbol, symbol) && (m.Exchange == "" || strings.EqualFold(m.Exchange, exchange)) {
+				id = m.CoinID
+			}
+		}
+		return id
+	}
+	// coin id on each exchange holding a symbol
+	resolved := map[string]map[string]string{}
+	for exchange, assets := range held {
+		for symbol := range assets {
+			s := strings.ToLower(symbol)
+			if resolved[s] == nil {
+				resolved[s] = map[string]string{}
+			}
+			resolved[s][exchange] = resolve(exchange, s)
+		}
+	}
+	for symbol, exchanges := range resolved {
+		// every exchange holding it agrees
+		agreed, first := "", true
+		for _, id := range exchanges {
+			if first {
+				agreed, first = id, false
+			} else if id != agreed {
+				agreed = ""
+				break
+			}
+		}
+		if agreed != "" {
+			ids[symbol] = agreed
+			continue
+		}
+		for exchange, id := range exchanges {
+			if id != "" && id != ids[symbol] {
+				ids[exchange+":"+symbol] = id
+			}
+		}
+	}
+	return ids
+}
@@ -0,0 +1,45 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCoinIDs(t *testing.T) {
+	builtIn := []CoinMapping{
+		{Symbol: "BTC", CoinID: "bitcoin"},
+		{Exchange: "binance", Symbol: "LUNA", CoinID: "terra-luna-2"},
+	}
+	tests := []struct {
+		name     string
+		payload  Payload
+		mappings []CoinMapping
+		want     map[string]string
+	}{
+		{
+			name:     "exchange mapping applies where it is held",
+			payload:  Payload{Binance: map[string]Asset{"LUNA": {}}},
+			mappings: builtIn,
+			want:     map[string]string{"btc": "bitcoin", "luna": "terra-luna-2"},
+		},
+		{
+			name:     "a later layer for every exchange wins over an earlier one for an exchange",
+			payload:  Payload{Binance: map[string]Asset{"LUNA": {}}},
+			mappings: append(builtIn, CoinMapping{Symbol: "LUNA", CoinID: "terra-luna"}),
+			want:     map[string]string{"btc": "bitcoin", "luna": "terra-luna"},
+		},
+		{
+			name:     "exchanges holding different coins are keyed apart",
+			payload:  Payload{Binance: map[string]Asset{"LUNA": {}}, Wallet: map[string]Asset{"LUNA": {}}},
+			mappings: builtIn,
+			want:     map[string]string{"btc": "bitcoin", "binance:luna": "terra-luna-2"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.payload.CoinIDs(test.mappings); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
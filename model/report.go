@@ -0,0 +1,384 @@
+package model
+
+import (
+	"strings"
+	"time"
+)
+
+// Summary is the whole portfolio in the reporting currency
+type Summary struct {
+	Currency      string         `json:"currency"`
+	Cost          float64        `json:"cost"`
+	Revenue       float64        `json:"revenue"`
+	Distributions float64        `json:"distributions"`
+	Fees          float64        `json:"fees"`
+	Profit        float64        `json:"profit"`
+	Futures       FuturesSummary `json:"futures"`
+	// totals per source
+	Exchanges map[string]Breakdown `json:"exchanges"`
+	// transfers with no other side. Deposits like these have no cost basis
+	Unmatched []Transfer `json:"unmatched"`
+	// symbols matched to one of several coins. Pin them with a mapping
+	Ambiguous []Coin `json:"ambiguous"`
+	// cost basis method and the gains it splits profit into. Fees and interest are in neither
+	Method     string  `json:"method"`
+	Realized   float64 `json:"realized"`
+	Unrealized float64 `json:"unrealized"`
+}
+type FuturesSummary struct {
+	RealizedPnl   float64    `json:"realized_pnl"`
+	Funding       float64    `json:"funding"`
+	Commission    float64    `json:"commission"`
+	Other         float64    `json:"other"`
+	UnrealizedPnl float64    `json:"unrealized_pnl"`
+	Profit        float64    `json:"profit"`
+	Positions     []Position `json:"positions"`
+}
+type Coin struct {
+	ID     string `json:"id"`
+	Symbol string `json:"symbol"`
+	Name   string `json:"name"`
+	// in the reporting currency
+	Price     float64 `json:"price"`
+	MarketCap float64 `json:"market_cap"`
+	Change    float64 `json:"change_24h"`
+	// price provider
+	Source string `json:"source"`
+	// from the mapping table rather than matched by symbol
+	Mapped bool `json:"mapped,omitempty"`
+	// other coins listed under the same symbol
+	Ambiguous []string `json:"ambiguous,omitempty"`
+}
+type Clean struct {
+	Symbol            string  `json:"symbol"`
+	Coin              Coin    `json:"coin"`
+	AverageBuy        float64 `json:"average_buy"`
+	AverageSell       float64 `json:"average_sell"`
+	Cost              float64 `json:"cost"`
+	Revenue           float64 `json:"revenue"`
+	BuyQty            float64 `json:"buy_qty"`
+	SellQty           float64 `json:"sell_qty"`
+	EarliestTrade     Trade   `json:"earliest_trade"`
+	LatestTrade       Trade   `json:"latest_trade"`
+	Balance           float64 `json:"balance"`
+	Profit            float64 `json:"profit"`
+	Dif               float64 `json:"dif"`
+	PercentDif        float64 `json:"percent_dif"`
+	TotalFee          float64 `json:"total_fee"`
+	TotalDistibutions float64 `json:"total_distributions"`
+	Earn              Earn    `json:"earn"`
+	Borrowed          float64 `json:"borrowed"`
+	Interest          float64 `json:"interest"`
+	Liquidations      int     `json:"liquidations"`
+	// what each source contributed. Keyed by source
+	Sources map[string]Breakdown `json:"sources"`
+	// how each quote was valued in the reporting currency. e.g. XYZ → BNB → USDT → EUR
+	Paths map[string]string `json:"paths"`
+	// by the report's cost basis method
+	Method     string  `json:"method"`
+	Realized   float64 `json:"realized"`
+	Unrealized float64 `json:"unrealized"`
+	// what the current balance cost
+	CostBasis float64 `json:"cost_basis"`
+}
+
+// Breakdown is what one source contributes to an asset or the whole portfolio
+type Breakdown struct {
+	// quantity. Not set on portfolio totals
+	Balance float64 `json:"balance"`
+	BuyQty  float64 `json:"buy_qty"`
+	SellQty float64 `json:"sell_qty"`
+	Value   float64 `json:"value"`
+	Cost    float64 `json:"cost"`
+	Revenue float64 `json:"revenue"`
+	Fees    float64 `json:"fees"`
+	Profit  float64 `json:"profit"`
+}
+
+// breakdown is what was added to a row between before and after
+func breakdown(before, after Clean) Breakdown {
+	return Breakdown{
+		Balance: after.Balance - before.Balance,
+		BuyQty:  after.BuyQty - before.BuyQty,
+		SellQty: after.SellQty - before.SellQty,
+		Cost:    after.Cost - before.Cost,
+		Revenue: after.Revenue - before.Revenue,
+		Fees:    after.TotalFee - before.TotalFee,
+	}
+}
+
+func (c *Clean) addSource(source string, b Breakdown) {
+	if c.Sources == nil {
+		c.Sources = map[string]Breakdown{}
+	}
+	existing := c.Sources[source]
+	existing.Balance += b.Balance
+	existing.BuyQty += b.BuyQty
+	existing.SellQty += b.SellQty
+	existing.Cost += b.Cost
+	existing.Revenue += b.Revenue
+	existing.Fees += b.Fees
+	c.Sources[source] = existing
+}
+
+// addPairs adds a source's trades of an asset to its row.
+// Ledger trades are valued at their own time and the rest at the pair's latest trade
+func (v Valuer) addPairs(clean *Clean, pairs map[string]Pair, ledger []Transaction) {
+	for quote, pair := range pairs {
+		if pair.EarliestTrade == nil || pair.LatestTrade == nil {
+			continue
+		}
+		if clean.Paths == nil {
+			clean.Paths = map[string]string{}
+		}
+		clean.Paths[strings.ToUpper(quote)] = v.path(quote)
+		latest := pair.LatestTrade.Time
+		cost, revenue := pair.Cost, pair.Revenue
+		for _, t := range ledger {
+			if !strings.EqualFold(t.Quote, quote) {
+				continue
+			}
+			amount := t.Price * t.Qty
+			if t.IsBuyer {
+				cost -= amount
+				clean.Cost += v.value(quote, amount, t.Time)
+			} else {
+				revenue -= amount
+				clean.Revenue += v.value(quote, amount, t.Time)
+			}
+		}
+		// trades fetched before the ledger was kept
+		if cost > 1e-9 {
+			clean.Cost += v.value(quote, cost, latest)
+		}
+		if revenue > 1e-9 {
+			clean.Revenue += v.value(quote, revenue, latest)
+		}
+		for asset, fee := range pair.Fees {
+			value := v.value(asset, fee, latest)
+			clean.Cost += value
+			clean.TotalFee += value
+		}
+		clean.BuyQty += pair.BuyQty
+		clean.SellQty += pair.SellQty
+		earliest := *pair.EarliestTrade
+		earliest.Price = v.value(quote, earliest.Price, earliest.Time)
+		if clean.EarliestTrade.Time.Unix() > earliest.Time.Unix() {
+			clean.EarliestTrade = earliest
+		}
+		last := *pair.LatestTrade
+		last.Price = v.value(quote, last.Price, last.Time)
+		if clean.LatestTrade.Time.Unix() < last.Time.Unix() {
+			clean.LatestTrade = last
+		}
+	}
+}
+
+// difference is how far the current price is from the average buy
+func (c *Clean) difference() {
+	c.Dif = c.Coin.Price - c.AverageBuy
+	divisor := (c.Coin.Price + c.AverageBuy) / 2
+	if divisor != 0 {
+		c.PercentDif = c.Dif * 100 / divisor
+	}
+}
+
+// coin is an asset's current price. Fiat and stablecoins are priced by exchange rate
+// and coins coingecko does not list through their route of markets
+func (v Valuer) coin(symbol string) (Coin, bool) {
+	if coin, ok := v.coins[strings.ToLower(symbol)]; ok && coin.Price > 0 {
+		return coin, true
+	}
+	price := v.price(symbol)
+	if price == 0 {
+		coin, ok := v.coins[strings.ToLower(symbol)]
+		return coin, ok
+	}
+	return Coin{Symbol: strings.ToLower(symbol), Name: strings.ToUpper(symbol), Price: price}, true
+}
+
+// coinOn is an asset's current price on a source that holds a different coin under the symbol,
+// or the symbol's own
+func (v Valuer) coinOn(source, symbol string) (Coin, bool) {
+	if coin, ok := v.coins[source+":"+strings.ToLower(symbol)]; ok && coin.Price > 0 {
+		coin.Symbol = strings.ToLower(symbol)
+		return coin, true
+	}
+	return v.coin(symbol)
+}
+
+// Report values every source's assets in the reporting currency and merges them by symbol
+func Report(payload Payload, v Valuer, opts Options) ([]Clean, Summary) {
+	if opts.Method == "" {
+		opts.Method = AverageCost
+	}
+	ledger := map[string][]Transaction{}
+	for _, t := range payload.Ledger {
+		k := strings.ToLower(t.Source) + "|" + strings.ToUpper(t.Base)
+		ledger[k] = append(ledger[k], t)
+	}
+	var cleaned []Clean
+	for k, a := range payload.Binance {
+		if len(a.Pairs) < 1 && a.Earn.Flexible+a.Earn.Locked <= 0 {
+			continue
+		}
+		coin, ok := v.coinOn("binance", k)
+		if !ok {
+			continue
+		}
+		clean := Clean{}
+		clean.Symbol = k
+		clean.Coin = coin
+		clean.BuyQty = a.DistributionTotal
+		clean.TotalDistibutions = a.DistributionTotal * clean.Coin.Price
+		// earn positions count toward holdings
+		clean.Balance = a.Balance + a.Earn.Flexible + a.Earn.Locked
+		clean.Earn = a.Earn
+
+		clean.EarliestTrade.Time = time.Unix(9223372036854775807, 0)
+		clean.LatestTrade.Time = time.Unix(0, 0)
+		v.addPairs(&clean, a.Pairs, ledger["binance|"+strings.ToUpper(k)])
+		if len(a.Pairs) < 1 {
+			// only held in earn
+			clean.EarliestTrade = Trade{}
+			clean.LatestTrade = Trade{}
+		}
+		clean.addSource("binance", breakdown(Clean{}, clean))
+		cleaned = append(cleaned, clean)
+	}
+	sources := []string{"kucoin", "manual", "margin", "wallet"}
+	for i, assets := range []map[string]Asset{payload.Kucoin, payload.Manual, payload.Margin, payload.Wallet} {
+		// self custody balances count even without trades
+		wallet := sources[i] == "wallet"
+		for k, a := range assets {
+			if len(a.Pairs) < 1 && a.Loan.Borrowed+a.Loan.Interest <= 0 && !(wallet && a.Balance > 0) {
+				continue
+			}
+			coin, ok := v.coinOn(sources[i], k)
+			if !ok {
+				continue
+			}
+			clean := Clean{}
+			clean.Symbol = k
+			clean.Coin = coin
+			clean.EarliestTrade.Time = time.Unix(9223372036854775807, 0)
+			clean.LatestTrade.Time = time.Unix(0, 0)
+			var existingIndex *int
+			for i, c := range cleaned {
+				if strings.EqualFold(c.Symbol, k) {
+					clean = c
+					existingIndex = &i
+					break
+				}
+			}
+			before := clean
+			clean.BuyQty += a.DistributionTotal
+			clean.TotalDistibutions += a.DistributionTotal * coin.Price
+			clean.Balance += a.Balance
+			// margin interest is a cost of holding the borrowed asset
+			clean.Borrowed += a.Loan.Borrowed
+			clean.Interest += a.Loan.Interest * coin.Price
+			clean.Cost += a.Loan.Interest * coin.Price
+			clean.Liquidations += a.Loan.Liquidations
+
+			v.addPairs(&clean, a.Pairs, ledger[sources[i]+"|"+strings.ToUpper(k)])
+			if existingIndex == nil && len(a.Pairs) < 1 {
+				// only borrowed
+				clean.EarliestTrade = Trade{}
+				clean.LatestTrade = Trade{}
+			}
+			clean.addSource(sources[i], breakdown(before, clean))
+			if existingIndex != nil {
+				cleaned[*existingIndex] = clean
+			} else {
+				cleaned = append(cleaned, clean)
+			}
+
+		}
+	}
+	for i, clean := range cleaned {
+		if clean.BuyQty != 0 {
+			clean.AverageBuy = clean.Cost / clean.BuyQty
+			clean.difference()
+		}
+		if clean.SellQty != 0 {
+			clean.AverageSell = clean.Revenue / clean.SellQty
+		}
+		// each source's balance at the price of the coin it holds
+		value := 0.0
+		for source, b := range clean.Sources {
+			coin, _ := v.coinOn(source, clean.Symbol)
+			b.Value = b.Balance * coin.Price
+			b.Profit = b.Revenue - b.Cost + b.Value
+			clean.Sources[source] = b
+			value += b.Value
+		}
+		clean.Profit = clean.Revenue - clean.Cost + value
+		cleaned[i] = clean
+	}
+	byAsset := assetLedger(payload.Ledger)
+	for i := range cleaned {
+		v.basis(&cleaned[i], byAsset[strings.ToUpper(cleaned[i].Symbol)], opts.Method)
+	}
+
+	summary := Summary{Currency: v.Currency, Exchanges: map[string]Breakdown{}, Method: opts.Method}
+	for _, c := range cleaned {
+		summary.Realized += c.Realized
+		summary.Unrealized += c.Unrealized
+		summary.Distributions += c.TotalDistibutions
+		summary.Cost += c.Cost
+		summary.Revenue += c.Revenue
+		summary.Fees += c.TotalFee
+		summary.Profit += c.Profit
+		for source, b := range c.Sources {
+			total := summary.Exchanges[source]
+			total.Value += b.Value
+			total.Cost += b.Cost
+			total.Revenue += b.Revenue
+			total.Fees += b.Fees
+			total.Profit += b.Profit
+			summary.Exchanges[source] = total
+		}
+	}
+	summary.Futures = v.futures(payload.Futures)
+	summary.Profit += summary.Futures.Profit
+	for _, t := range payload.Transfers {
+		if t.Unmatched {
+			summary.Unmatched = append(summary.Unmatched, t)
+		}
+	}
+	summary.Ambiguous = []Coin{}
+	for _, coin := range v.coins {
+		if len(coin.Ambiguous) > 0 {
+			summary.Ambiguous = append(summary.Ambiguous, coin)
+		}
+	}
+	if opts.Dust > 0 {
+		var kept []Clean
+		for _, c := range cleaned {
+			// fully sold assets are not dust
+			if value := c.Balance * c.Coin.Price; value <= 0 || value >= opts.Dust {
+				kept = append(kept, c)
+			}
+		}
+		cleaned = kept
+	}
+	return cleaned, summary
+}
+
+func (v Valuer) futures(futures Futures) FuturesSummary {
+	summary := FuturesSummary{Positions: futures.Positions}
+	for symbol, income := range futures.Income {
+		price := v.price(symbol)
+		summary.RealizedPnl += income.RealizedPnl * price
+		summary.Funding += income.Funding * price
+		summary.Commission += income.Commission * price
+		summary.Other += income.Other * price
+	}
+	for _, p := range futures.Positions {
+		summary.UnrealizedPnl += p.UnrealizedPnl * v.price(p.Asset)
+	}
+	// funding and commission are negative when paid
+	summary.Profit = summary.RealizedPnl + summary.Funding + summary.Commission + summary.Other + summary.UnrealizedPnl
+	return summary
+}
@@ -0,0 +1,221 @@
+package model
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// return periods
+const (
+	YearToDate = "ytd"
+	OneYear    = "1y"
+	AllTime    = "all"
+)
+
+var Periods = []string{YearToDate, OneYear, AllTime}
+
+// Return is how an asset or the portfolio did over a period
+type Return struct {
+	// time-weighted. Unaffected by when and how much was put in
+	TWR float64 `json:"twr"`
+	// money-weighted annual rate. Nil when it has no solution
+	XIRR *float64 `json:"xirr"`
+	// value at the start of the period and what was put in and taken out after it
+	StartValue float64 `json:"start_value"`
+	Invested   float64 `json:"invested"`
+	Withdrawn  float64 `json:"withdrawn"`
+	EndValue   float64 `json:"end_value"`
+}
+
+// Returns are the portfolio's and each asset's returns over a period
+type Returns struct {
+	Period string            `json:"period"`
+	Start  string            `json:"start"`
+	Total  Return            `json:"total"`
+	Assets map[string]Return `json:"assets"`
+}
+
+// periodStart is when a period begins. Zero for all time
+// ValidatePeriods is an error naming the first period that is not known
+func ValidatePeriods(periods []string) error {
+	for _, period := range periods {
+		if _, err := periodStart(period, time.Now()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func periodStart(period string, now time.Time) (time.Time, error) {
+	now = now.UTC()
+	switch period {
+	case YearToDate:
+		return time.Date(now.Year(), 1, 1, 0, 0, 0, 0, time.UTC), nil
+	case OneYear:
+		return now.AddDate(-1, 0, 0).Truncate(24 * time.Hour), nil
+	case AllTime, "":
+		return time.Time{}, nil
+	}
+	return time.Time{}, fmt.Errorf("unknown period %s", period)
+}
+
+// Returns are time and money-weighted returns over each period from the ledger replayed daily.
+// Buys are money put in and sells money taken out. Holdings at the start of a period count as put in then
+func (v Valuer) Returns(payload Payload, method string, periods []string) ([]Returns, error) {
+	days := v.replay(payload, method)
+	var all []Returns
+	for _, period := range periods {
+		start, err := periodStart(period, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		r := Returns{Period: period, Assets: map[string]Return{}}
+		if len(days) < 1 {
+			all = append(all, r)
+			continue
+		}
+		first := 0
+		for first < len(days) && days[first].time.Before(start) {
+			first++
+		}
+		if first >= len(days) {
+			first = len(days) - 1
+		}
+		r.Start = days[first].time.Format("2006-01-02")
+		assets := map[string]bool{}
+		for _, d := range days[first:] {
+			for asset := range d.values {
+				assets[asset] = true
+			}
+			for asset := range d.flows {
+				assets[asset] = true
+			}
+		}
+		if first > 0 {
+			for asset := range days[first-1].values {
+				assets[asset] = true
+			}
+		}
+		for asset := range assets {
+			r.Assets[asset] = periodReturn(days, first, func(d day) (float64, float64) {
+				return d.values[asset], d.flows[asset]
+			})
+		}
+		r.Total = periodReturn(days, first, func(d day) (float64, float64) {
+			flow := 0.0
+			for _, f := range d.flows {
+				flow += f
+			}
+			return d.snapshot.Value, flow
+		})
+		all = append(all, r)
+	}
+	return all, nil
+}
+
+type cashFlow struct {
+	time   time.Time
+	amount float64
+}
+
+// periodReturn chains daily returns from days[first] to the last day.
+// at is the value at a day's close and the money put in that day
+func periodReturn(days []day, first int, at func(day) (float64, float64)) Return {
+	var r Return
+	previous := 0.0
+	if first > 0 {
+		previous, _ = at(days[first-1])
+	}
+	r.StartValue = previous
+	// put in is negative like money leaving a wallet
+	var flows []cashFlow
+	if previous > 0 {
+		flows = append(flows, cashFlow{days[first].time, -previous})
+	}
+	growth := 1.0
+	for _, d := range days[first:] {
+		value, flow := at(d)
+		if flow > 0 {
+			r.Invested += flow
+		} else {
+			r.Withdrawn -= flow
+		}
+		if flow != 0 {
+			flows = append(flows, cashFlow{d.time, -flow})
+		}
+		// money moves at the start of the day
+		if base := previous + flow; base > 1e-9 {
+			growth *= value / base
+		}
+		previous = value
+	}
+	r.EndValue = previous
+	r.TWR = growth - 1
+	last := days[len(days)-1].time
+	if previous > 0 {
+		flows = append(flows, cashFlow{last, previous})
+	}
+	if rate, ok := xirr(flows); ok {
+		r.XIRR = &rate
+	}
+	return r
+}
+
+// xirr is the annual rate that discounts dated cash flows to zero.
+// Newton's method, then bisection when it does not converge
+func xirr(flows []cashFlow) (float64, bool) {
+	var in, out bool
+	for _, f := range flows {
+		in = in || f.amount < 0
+		out = out || f.amount > 0
+	}
+	if !in || !out {
+		return 0, false
+	}
+	t0 := flows[0].time
+	npv := func(rate float64) (float64, float64) {
+		value, derivative := 0.0, 0.0
+		for _, f := range flows {
+			years := f.time.Sub(t0).Hours() / 24 / 365
+			discount := math.Pow(1+rate, years)
+			value += f.amount / discount
+			derivative -= years * f.amount / (discount * (1 + rate))
+		}
+		return value, derivative
+	}
+	rate := 0.1
+	for i := 0; i < 100; i++ {
+		value, derivative := npv(rate)
+		if math.Abs(value) < 1e-7 {
+			return rate, true
+		}
+		if derivative == 0 {
+			break
+		}
+		next := rate - value/derivative
+		if next <= -1 || math.IsNaN(next) || math.IsInf(next, 0) {
+			break
+		}
+		rate = next
+	}
+	low, high := -0.9999, 1000.0
+	fLow, _ := npv(low)
+	fHigh, _ := npv(high)
+	if fLow*fHigh > 0 {
+		return 0, false
+	}
+	for i := 0; i < 200; i++ {
+		mid := (low + high) / 2
+		fMid, _ := npv(mid)
+		if math.Abs(fMid) < 1e-7 {
+			return mid, true
+		}
+		if fLow*fMid < 0 {
+			high = mid
+		} else {
+			low, fLow = mid, fMid
+		}
+	}
+	return (low + high) / 2, true
+}
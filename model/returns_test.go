@@ -0,0 +1,119 @@
+package model
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestXIRR(t *testing.T) {
+	t0 := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	year := 365 * 24 * time.Hour
+	tests := []struct {
+		name  string
+		flows []cashFlow
+		rate  float64
+		ok    bool
+	}{
+		{
+			name:  "single deposit",
+			flows: []cashFlow{{t0, -100}},
+		},
+		{
+			name:  "deposit and value a year later",
+			flows: []cashFlow{{t0, -100}, {t0.Add(year), 110}},
+			rate:  0.1, ok: true,
+		},
+		{
+			name:  "withdrawal partway",
+			flows: []cashFlow{{t0, -100}, {t0.Add(year), 55}, {t0.Add(2 * year), 60.5}},
+			rate:  0.1, ok: true,
+		},
+		{
+			name:  "no sign change",
+			flows: []cashFlow{{t0, -100}, {t0.Add(year), -50}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rate, ok := xirr(test.flows)
+			if ok != test.ok {
+				t.Fatalf("got solved %t, want %t", ok, test.ok)
+			}
+			if ok && math.Abs(rate-test.rate) > 1e-6 {
+				t.Errorf("got %g, want %g", rate, test.rate)
+			}
+		})
+	}
+}
+
+func TestPeriodReturn(t *testing.T) {
+	t0 := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	year := 365 * 24 * time.Hour
+	// value at close and money put in
+	type closing struct {
+		value, flow float64
+	}
+	rate := func(r float64) *float64 {
+		return &r
+	}
+	tests := []struct {
+		name   string
+		closes []closing
+		twr    float64
+		// nil when it has no solution
+		xirr      *float64
+		invested  float64
+		withdrawn float64
+	}{
+		{
+			name:     "single deposit",
+			closes:   []closing{{100, 100}, {110, 0}},
+			twr:      0.1,
+			xirr:     rate(0.1),
+			invested: 100,
+		},
+		{
+			name:   "withdrawal partway",
+			closes: []closing{{100, 100}, {110, 0}, {60.5, -55}},
+			// 55 left after the withdrawal grew by a tenth
+			twr:       0.21,
+			xirr:      rate(math.Sqrt(1.155) - 1),
+			invested:  100,
+			withdrawn: 55,
+		},
+		{
+			name:     "no sign change",
+			closes:   []closing{{100, 100}, {0, 0}},
+			twr:      -1,
+			invested: 100,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var days []day
+			for i, c := range test.closes {
+				days = append(days, day{
+					time:   t0.Add(time.Duration(i) * year),
+					values: map[string]float64{"BTC": c.value},
+					flows:  map[string]float64{"BTC": c.flow},
+				})
+			}
+			r := periodReturn(days, 0, func(d day) (float64, float64) {
+				return d.values["BTC"], d.flows["BTC"]
+			})
+			if math.Abs(r.TWR-test.twr) > 1e-9 {
+				t.Errorf("got twr %g, want %g", r.TWR, test.twr)
+			}
+			if (r.XIRR == nil) != (test.xirr == nil) {
+				t.Fatalf("got xirr %v, want %v", r.XIRR, test.xirr)
+			}
+			if r.XIRR != nil && math.Abs(*r.XIRR-*test.xirr) > 1e-6 {
+				t.Errorf("got xirr %g, want %g", *r.XIRR, *test.xirr)
+			}
+			if r.Invested != test.invested || r.Withdrawn != test.withdrawn {
+				t.Errorf("got invested %g withdrawn %g, want %g and %g", r.Invested, r.Withdrawn, test.invested, test.withdrawn)
+			}
+		})
+	}
+}
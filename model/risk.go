@@ -0,0 +1,206 @@
+package model
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// crypto trades every day of the year
+const tradingDays = 365
+
+// RiskOptions change how risk is measured
+type RiskOptions struct {
+	// days of returns each rolling volatility is taken over. 30 when zero
+	Window int
+	// largest holdings counted in the top share. 3 when zero
+	Top int
+	// annual rate a risk-free holding would have earned. e.g. 0.04
+	RiskFree float64
+}
+
+// Defaults fills in what was left empty
+func (o RiskOptions) Defaults() RiskOptions {
+	if o.Window == 0 {
+		o.Window = 30
+	}
+	if o.Top == 0 {
+		o.Top = 3
+	}
+	return o
+}
+
+func (o RiskOptions) Validate() error {
+	if o.Window < 2 {
+		return fmt.Errorf("window must be at least 2 days")
+	}
+	if o.Top < 1 {
+		return fmt.Errorf("top must be at least 1")
+	}
+	return nil
+}
+
+// Drawdown is the worst fall from a peak. Returns are adjusted for money put in and taken out
+type Drawdown struct {
+	// fraction lost from the peak. e.g. 0.4 for a 40% fall
+	Max    float64 `json:"max"`
+	Peak   string  `json:"peak"`
+	Trough string  `json:"trough"`
+	// fraction the portfolio is below its highest right now
+	Current float64 `json:"current"`
+}
+
+// VolatilityPoint is annualized volatility of the returns in the window ending on a day
+type VolatilityPoint struct {
+	Date       string  `json:"date"`
+	Volatility float64 `json:"volatility"`
+}
+
+// Share is how much of the portfolio an asset is
+type Share struct {
+	Symbol string  `json:"symbol"`
+	Value  float64 `json:"value"`
+	Share  float64 `json:"share"`
+}
+
+// Concentration is how much of the portfolio is in a few assets
+type Concentration struct {
+	// the largest holdings
+	Top []Share `json:"top"`
+	// their combined share
+	TopShare float64 `json:"top_share"`
+	// sum of squared shares. 1 when everything is in one asset
+	Herfindahl float64 `json:"herfindahl"`
+}
+
+// Risk is measured from daily returns of the portfolio's value history and how its holdings are spread
+type Risk struct {
+	Days     int      `json:"days"`
+	Drawdown Drawdown `json:"drawdown"`
+	// annualized standard deviation of daily returns
+	Volatility        float64           `json:"volatility"`
+	RollingVolatility []VolatilityPoint `json:"rolling_volatility"`
+	// annualized excess return over volatility and over downside volatility. Zero when undefined
+	Sharpe        float64       `json:"sharpe"`
+	Sortino       float64       `json:"sortino"`
+	Concentration Concentration `json:"concentration"`
+}
+
+// Risk measures the ledger replayed daily and the current holdings of a report
+func (v Valuer) Risk(payload Payload, method string, cleaned []Clean, opts RiskOptions) Risk {
+	days := v.replay(payload, method)
+	r := Risk{Concentration: concentration(cleaned, opts.Top)}
+	var returns []float64
+	var dates []string
+	growth, peak := 1.0, 1.0
+	peakDate := ""
+	previous := 0.0
+	for _, d := range days {
+		flow := 0.0
+		for _, f := range d.flows {
+			flow += f
+		}
+		value := d.snapshot.Value
+		// money moves at the start of the day
+		if base := previous + flow; base > 1e-9 {
+			daily := value/base - 1
+			returns = append(returns, daily)
+			dates = append(dates, d.snapshot.Date)
+			growth *= 1 + daily
+		}
+		previous = value
+		if growth > peak || peakDate == "" {
+			peak, peakDate = growth, d.snapshot.Date
+		}
+		if fall := 1 - growth/peak; fall > r.Drawdown.Max {
+			r.Drawdown = Drawdown{Max: fall, Peak: peakDate, Trough: d.snapshot.Date}
+		}
+		r.Drawdown.Current = 1 - growth/peak
+	}
+	r.Days = len(returns)
+	if len(returns) < 2 {
+		return r
+	}
+	r.Volatility = stddev(returns) * math.Sqrt(tradingDays)
+	for i := opts.Window; i <= len(returns); i++ {
+		r.RollingVolatility = append(r.RollingVolatility, VolatilityPoint{dates[i-1], stddev(returns[i-opts.Window:i]) * math.Sqrt(tradingDays)})
+	}
+	daily := math.Pow(1+opts.RiskFree, 1.0/tradingDays) - 1
+	var excess, downside []float64
+	for _, ret := range returns {
+		excess = append(excess, ret-daily)
+		if ret < daily {
+			downside = append(downside, ret-daily)
+		} else {
+			downside = append(downside, 0)
+		}
+	}
+	mean := average(excess) * tradingDays
+	if sd := stddev(excess) * math.Sqrt(tradingDays); sd > 0 {
+		r.Sharpe = mean / sd
+	}
+	// downside deviation is taken from zero excess return
+	squares := 0.0
+	for _, d := range downside {
+		squares += d * d
+	}
+	if dd := math.Sqrt(squares/float64(len(downside))) * math.Sqrt(tradingDays); dd > 0 {
+		r.Sortino = mean / dd
+	}
+	return r
+}
+
+// concentration of holdings by current value. Borrowed amounts are not subtracted
+func concentration(cleaned []Clean, top int) Concentration {
+	var c Concentration
+	var shares []Share
+	total := 0.0
+	for _, clean := range cleaned {
+		value := clean.Balance * clean.Coin.Price
+		if value <= 0 {
+			continue
+		}
+		shares = append(shares, Share{Symbol: strings.ToUpper(clean.Symbol), Value: value})
+		total += value
+	}
+	if total <= 0 {
+		return c
+	}
+	sort.Slice(shares, func(i, j int) bool {
+		return shares[i].Value > shares[j].Value
+	})
+	for i := range shares {
+		shares[i].Share = shares[i].Value / total
+		c.Herfindahl += shares[i].Share * shares[i].Share
+		if i < top {
+			c.Top = append(c.Top, shares[i])
+			c.TopShare += shares[i].Share
+		}
+	}
+	return c
+}
+
+func average(values []float64) float64 {
+	if len(values) < 1 {
+		return 0
+	}
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+// stddev is the sample standard deviation
+func stddev(values []float64) float64 {
+	if len(values) < 2 {
+		return 0
+	}
+	mean := average(values)
+	squares := 0.0
+	for _, v := range values {
+		squares += (v - mean) * (v - mean)
+	}
+	return math.Sqrt(squares / float64(len(values)-1))
+}
@@ -0,0 +1,188 @@
+package model
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// Holding is an asset held at current prices
+type Holding struct {
+	Symbol     string  `json:"symbol"`
+	Balance    float64 `json:"balance"`
+	Price      float64 `json:"price"`
+	Value      float64 `json:"value"`
+	CostBasis  float64 `json:"cost_basis"`
+	Unrealized float64 `json:"unrealized"`
+}
+
+// IncomeLine is income of one kind in an asset
+type IncomeLine struct {
+	Asset string  `json:"asset"`
+	Kind  string  `json:"kind"`
+	Value float64 `json:"value"`
+}
+
+// FeeLine is fees of one kind paid in one asset
+type FeeLine struct {
+	Asset  string  `json:"asset"`
+	Kind   string  `json:"kind"`
+	Amount float64 `json:"amount"`
+	Value  float64 `json:"value"`
+}
+
+// StatementTrade is a trade valued in the reporting currency
+type StatementTrade struct {
+	Time     time.Time `json:"time"`
+	Source   string    `json:"source"`
+	Side     string    `json:"side"`
+	Base     string    `json:"base"`
+	Quote    string    `json:"quote"`
+	Qty      float64   `json:"qty"`
+	Price    float64   `json:"price"`
+	Value    float64   `json:"value"`
+	Fee      float64   `json:"fee"`
+	FeeAsset string    `json:"fee_asset"`
+}
+
+// Statement is the portfolio over a period.
+// Holdings are as of now. Values, realized gains, income, fees and trades are within the period
+type Statement struct {
+	Currency  string    `json:"currency"`
+	Method    string    `json:"method"`
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	Generated time.Time `json:"generated"`
+	// replayed from the ledger at the day's close before the period and its last day
+	OpeningValue float64          `json:"opening_value"`
+	ClosingValue float64          `json:"closing_value"`
+	Realized     float64          `json:"realized"`
+	Holdings     []Holding        `json:"holdings"`
+	Summary      Summary          `json:"summary"`
+	Income       []IncomeLine     `json:"income"`
+	Fees         []FeeLine        `json:"fees"`
+	Trades       []StatementTrade `json:"trades"`
+}
+
+// incomeKinds are the payments a statement lists as income and what it calls them.
+// Interest and futures fees are costs
+var incomeKinds = map[string]string{
+	DistributionPayment: "distributions",
+	RewardPayment:       "earn rewards",
+	FuturesPnlPayment:   "futures pnl",
+	FundingPayment:      "funding",
+	FuturesOtherPayment: "futures other",
+}
+
+// feeKinds are the payments a statement lists as fees and what it calls them
+var feeKinds = map[string]string{
+	InterestPayment:   "margin interest",
+	FuturesFeePayment: "futures commission",
+}
+
+// Statement reports on the trades from start until end and what is held now
+func (v Valuer) Statement(payload Payload, opts Options, start, end time.Time) Statement {
+	s := Statement{Currency: v.Currency, Method: opts.Method, Start: start, End: end, Generated: time.Now()}
+	cleaned, summary := Report(payload, v, opts)
+	s.Summary = summary
+	for _, c := range cleaned {
+		if c.Balance*c.Coin.Price > 0 {
+			s.Holdings = append(s.Holdings, Holding{
+				Symbol:     strings.ToUpper(c.Symbol),
+				Balance:    c.Balance,
+				Price:      c.Coin.Price,
+				Value:      c.Balance * c.Coin.Price,
+				CostBasis:  c.CostBasis,
+				Unrealized: c.Unrealized,
+			})
+		}
+	}
+	sort.Slice(s.Holdings, func(i, j int) bool {
+		return s.Holdings[i].Value > s.Holdings[j].Value
+	})
+	income := map[[2]string]float64{}
+	for _, p := range append(payload.unrecordedDistributions(), payload.Payments...) {
+		kind, ok := incomeKinds[p.Kind]
+		if !ok || p.Time.Before(start) || !p.Time.Before(end) {
+			continue
+		}
+		asset := strings.ToUpper(p.Asset)
+		income[[2]string{asset, kind}] += v.value(asset, p.Amount, p.Time)
+	}
+	for k, value := range income {
+		s.Income = append(s.Income, IncomeLine{k[0], k[1], value})
+	}
+	sort.Slice(s.Income, func(i, j int) bool {
+		if s.Income[i].Asset == s.Income[j].Asset {
+			return s.Income[i].Kind < s.Income[j].Kind
+		}
+		return s.Income[i].Asset < s.Income[j].Asset
+	})
+	var realizedBefore, realizedAfter float64
+	for _, d := range v.replay(payload, opts.Method) {
+		if d.time.Before(start) {
+			s.OpeningValue = d.snapshot.Value
+			realizedBefore = d.snapshot.Realized
+			realizedAfter = d.snapshot.Realized
+			continue
+		}
+		if !d.time.Before(end) {
+			break
+		}
+		s.ClosingValue = d.snapshot.Value
+		realizedAfter = d.snapshot.Realized
+	}
+	s.Realized = realizedAfter - realizedBefore
+	fees := map[[2]string]FeeLine{}
+	addFee := func(asset, kind string, amount float64, at time.Time) {
+		asset = strings.ToUpper(asset)
+		f := fees[[2]string{asset, kind}]
+		f.Asset = asset
+		f.Kind = kind
+		f.Amount += amount
+		f.Value += v.value(asset, amount, at)
+		fees[[2]string{asset, kind}] = f
+	}
+	for _, p := range payload.Payments {
+		kind, ok := feeKinds[p.Kind]
+		if !ok || p.Time.Before(start) || !p.Time.Before(end) {
+			continue
+		}
+		// paid out so negative
+		addFee(p.Asset, kind, -p.Amount, p.Time)
+	}
+	for _, t := range payload.Ledger {
+		if t.Time.Before(start) || !t.Time.Before(end) || strings.HasPrefix(t.ID, "transfer:") {
+			continue
+		}
+		side := "sell"
+		if t.IsBuyer {
+			side = "buy"
+		}
+		s.Trades = append(s.Trades, StatementTrade{
+			Time:     t.Time,
+			Source:   t.Source,
+			Side:     side,
+			Base:     strings.ToUpper(t.Base),
+			Quote:    strings.ToUpper(t.Quote),
+			Qty:      t.Qty,
+			Price:    t.Price,
+			Value:    v.value(t.Quote, t.Price*t.Qty, t.Time),
+			Fee:      t.Fee,
+			FeeAsset: strings.ToUpper(t.FeeAsset),
+		})
+		if t.Fee > 0 && t.FeeAsset != "" {
+			addFee(t.FeeAsset, "trading", t.Fee, t.Time)
+		}
+	}
+	sort.SliceStable(s.Trades, func(i, j int) bool {
+		return s.Trades[i].Time.Before(s.Trades[j].Time)
+	})
+	for _, f := range fees {
+		s.Fees = append(s.Fees, f)
+	}
+	sort.Slice(s.Fees, func(i, j int) bool {
+		return s.Fees[i].Value > s.Fees[j].Value
+	})
+	return s
+}
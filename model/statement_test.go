@@ -0,0 +1,34 @@
+package model
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestStatementFees(t *testing.T) {
+	v := NewValuer("usd", map[string]Coin{"bnb": {Price: 300}}, FX{}, nil)
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := start.AddDate(0, 1, 0)
+	payload := Payload{
+		Ledger: []Transaction{
+			{ID: "1", Source: "binance", Base: "BTC", Quote: "USDT", Time: at, IsBuyer: true, Price: 100, Qty: 1, Fee: 0.1, FeeAsset: "USDT"},
+		},
+		Payments: []Payment{
+			{ID: "margin:interest:USDT:1", Source: MarginSource, Asset: "USDT", Kind: InterestPayment, Time: at, Amount: -2},
+			{ID: "futures:usdm:1:COMMISSION", Source: "futures", Asset: "USDT", Kind: FuturesFeePayment, Time: at, Amount: -3},
+			// before the period
+			{ID: "margin:interest:USDT:0", Source: MarginSource, Asset: "USDT", Kind: InterestPayment, Time: start.AddDate(0, 0, -1), Amount: -5},
+		},
+	}
+	s := v.Statement(payload, Options{Method: AverageCost}, start, start.AddDate(1, 0, 0))
+	want := map[string]float64{"trading": 0.1, "margin interest": 2, "futures commission": 3}
+	if len(s.Fees) != len(want) {
+		t.Fatalf("got fee lines %+v, want %v", s.Fees, want)
+	}
+	for _, f := range s.Fees {
+		if f.Asset != "USDT" || math.Abs(f.Amount-want[f.Kind]) > 1e-9 || math.Abs(f.Value-want[f.Kind]) > 1e-9 {
+			t.Errorf("got %+v, want %g USDT of %s", f, want[f.Kind], f.Kind)
+		}
+	}
+}
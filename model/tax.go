@@ -0,0 +1,401 @@
+package model
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Disposal is a sale of an asset matched to what it was acquired with
+type Disposal struct {
+	Asset string `json:"asset"`
+	// zero when sold before anything on record was bought
+	Acquired time.Time `json:"acquired"`
+	Disposed time.Time `json:"disposed"`
+	Qty      float64   `json:"qty"`
+	// in the reporting currency
+	Proceeds float64 `json:"proceeds"`
+	Cost     float64 `json:"cost"`
+	Gain     float64 `json:"gain"`
+	// short or long. Empty where the rule has no holding period
+	Term string `json:"term"`
+	// gains the rule does not tax
+	Exempt bool `json:"exempt"`
+	// what the sale was matched against. e.g. same day, 30 day, pool
+	Match string `json:"match"`
+}
+
+// holding terms
+const (
+	ShortTerm = "short"
+	LongTerm  = "long"
+)
+
+// TaxRule matches sales to acquisitions the way a jurisdiction does
+type TaxRule interface {
+	Name() string
+	// currency gains are reported in
+	Currency() string
+	// when a tax year starts and ends
+	Year(year int) (time.Time, time.Time)
+	// disposals of one asset from its acquisitions and disposals sorted by time
+	disposals(asset string, events []taxEvent) []Disposal
+}
+
+// TaxRules are the rules reports can be made by
+var TaxRules = map[string]TaxRule{
+	"us-fifo": lotRule{name: "us-fifo", currency: "usd", pick: firstIn},
+	// specific identification of the highest cost lot
+	"us-hifo": lotRule{name: "us-hifo", currency: "usd", pick: highestCost},
+	"uk":      ukRule{},
+	// gains on assets held over a year are tax free
+	"de": lotRule{name: "de", currency: "eur", pick: firstIn, exemptLong: true},
+}
+
+// TaxReport is every disposal in a tax year and their totals
+type TaxReport struct {
+	Rule      string     `json:"rule"`
+	Year      int        `json:"year"`
+	Currency  string     `json:"currency"`
+	Start     time.Time  `json:"start"`
+	End       time.Time  `json:"end"`
+	Disposals []Disposal `json:"disposals"`
+	Proceeds  float64    `json:"proceeds"`
+	Cost      float64    `json:"cost"`
+	Gain      float64    `json:"gain"`
+	ShortTerm float64    `json:"short_term"`
+	LongTerm  float64    `json:"long_term"`
+	// gains left out of taxable
+	Exempt  float64 `json:"exempt"`
+	Taxable float64 `json:"taxable"`
+}
+
+// Tax matches every sale in the ledger by rule and reports the ones in a tax year.
+// Trading one crypto for another disposes of the one given up. Transfers between sources are not sales.
+// Fees are added to the cost of what is bought and taken from the proceeds of what is sold
+func (v Valuer) Tax(payload Payload, rule TaxRule, year int) TaxReport {
+	start, end := rule.Year(year)
+	report := TaxReport{Rule: rule.Name(), Year: year, Currency: v.Currency, Start: start, End: end}
+	for asset, events := range v.taxEvents(payload) {
+		sort.SliceStable(events, func(i, j int) bool {
+			return events[i].time.Before(events[j].time)
+		})
+		for _, d := range rule.disposals(asset, events) {
+			if d.Disposed.Before(start) || !d.Disposed.Before(end) {
+				continue
+			}
+			report.Disposals = append(report.Disposals, d)
+			report.Proceeds += d.Proceeds
+			report.Cost += d.Cost
+			report.Gain += d.Gain
+			switch d.Term {
+			case ShortTerm:
+				report.ShortTerm += d.Gain
+			case LongTerm:
+				report.LongTerm += d.Gain
+			}
+			if d.Exempt {
+				report.Exempt += d.Gain
+			}
+		}
+	}
+	report.Taxable = report.Gain - report.Exempt
+	sort.SliceStable(report.Disposals, func(i, j int) bool {
+		if report.Disposals[i].Disposed.Equal(report.Disposals[j].Disposed) {
+			return report.Disposals[i].Asset < report.Disposals[j].Asset
+		}
+		return report.Disposals[i].Disposed.Before(report.Disposals[j].Disposed)
+	})
+	return report
+}
+
+// taxEvent is an asset acquired or disposed of in the reporting currency
+type taxEvent struct {
+	time    time.Time
+	acquire bool
+	qty     float64
+	// cost of an acquisition or proceeds of a disposal, fees included
+	amount float64
+}
+
+// taxEvents are the acquisitions and disposals of each asset.
+// Trades on record before the ledger was kept are one buy at the pair's earliest trade
+// and one sell before the first trade on the ledger, like basis does
+func (v Valuer) taxEvents(payload Payload) map[string][]taxEvent {
+	events := map[string][]taxEvent{}
+	for _, t := range payload.unrecorded() {
+		fee := 0.0
+		for asset, amount := range t.fees {
+			fee += v.value(asset, amount, t.Time)
+		}
+		v.addTrade(events, t.Base, t.Quote, t.Time, t.IsBuyer, t.Qty, t.Price*t.Qty, fee)
+	}
+	for _, t := range payload.Ledger {
+		if strings.HasPrefix(t.ID, "transfer:") {
+			continue
+		}
+		fee := 0.0
+		if t.Fee > 0 && t.FeeAsset != "" {
+			fee = v.value(t.FeeAsset, t.Fee, t.Time)
+		}
+		v.addTrade(events, t.Base, t.Quote, t.Time, t.IsBuyer, t.Qty, t.Price*t.Qty, fee)
+	}
+	return events
+}
+
+// addTrade adds both sides of a trade of qty base for total quote.
+// Fiat and stablecoins are money so only crypto quotes are acquired or disposed of
+func (v Valuer) addTrade(events map[string][]taxEvent, base, quote string, at time.Time, isBuyer bool, qty, total, fee float64) {
+	amount := v.value(quote, total, at)
+	base, quote = strings.ToUpper(base), strings.ToUpper(quote)
+	if isBuyer {
+		events[base] = append(events[base], taxEvent{at, true, qty, amount + fee})
+	} else {
+		events[base] = append(events[base], taxEvent{at, false, qty, amount - fee})
+	}
+	if peg(quote) != "" || total <= 0 {
+		return
+	}
+	events[quote] = append(events[quote], taxEvent{at, !isBuyer, total, amount})
+}
+
+// ParseTaxRule finds a rule by name
+func ParseTaxRule(name string) (TaxRule, error) {
+	rule, ok := TaxRules[strings.ToLower(name)]
+	if !ok {
+		var names []string
+		for n := range TaxRules {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown tax rule %s. Use one of %s", name, strings.Join(names, ", "))
+	}
+	return rule, nil
+}
+
+func calendarYear(year int) (time.Time, time.Time) {
+	return time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(year+1, 1, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// heldOverYear is whether an asset acquired at one time and disposed at another was held more than a year
+func heldOverYear(acquired, disposed time.Time) bool {
+	return !acquired.IsZero() && disposed.After(acquired.AddDate(1, 0, 0))
+}
+
+// lotRule sells whole lots in the order pick chooses. Holdings over a year are long term
+type lotRule struct {
+	name     string
+	currency string
+	pick     func([]lot) int
+	// long term gains are not taxed
+	exemptLong bool
+}
+
+func (r lotRule) Name() string {
+	return r.name
+}
+
+func (r lotRule) Currency() string {
+	return r.currency
+}
+
+func (r lotRule) Year(year int) (time.Time, time.Time) {
+	return calendarYear(year)
+}
+
+func (r lotRule) disposals(asset string, events []taxEvent) []Disposal {
+	var lots []lot
+	var disposals []Disposal
+	for _, e := range events {
+		if e.acquire {
+			lots = append(lots, lot{e.time, e.qty, e.amount})
+			continue
+		}
+		qty := e.qty
+		for qty > 1e-12 {
+			d := Disposal{Asset: asset, Disposed: e.time, Match: "lot"}
+			if len(lots) < 1 {
+				// sold more than was bought on record
+				d.Qty = qty
+				d.Match = "unknown"
+			} else {
+				i := r.pick(lots)
+				l := lots[i]
+				d.Acquired = l.time
+				d.Qty = qty
+				if d.Qty > l.qty {
+					d.Qty = l.qty
+				}
+				d.Cost = l.cost * d.Qty / l.qty
+				l.cost -= d.Cost
+				l.qty -= d.Qty
+				lots[i] = l
+				if l.qty <= 1e-12 {
+					lots = append(lots[:i], lots[i+1:]...)
+				}
+			}
+			d.Proceeds = e.amount * d.Qty / e.qty
+			d.Gain = d.Proceeds - d.Cost
+			d.Term = ShortTerm
+			if heldOverYear(d.Acquired, d.Disposed) {
+				d.Term = LongTerm
+				d.Exempt = r.exemptLong
+			}
+			disposals = append(disposals, d)
+			qty -= d.Qty
+		}
+	}
+	return disposals
+}
+
+func firstIn(lots []lot) int {
+	return 0
+}
+
+func highestCost(lots []lot) int {
+	best := 0
+	for i, l := range lots {
+		if l.cost/l.qty > lots[best].cost/lots[best].qty {
+			best = i
+		}
+	}
+	return best
+}
+
+// ukRule matches sales to buys on the same day, then buys in the 30 days after,
+// then the average cost of everything else held (the section 104 pool). The tax year starts on 6 April
+type ukRule struct{}
+
+func (ukRule) Name() string {
+	return "uk"
+}
+
+func (ukRule) Currency() string {
+	return "gbp"
+}
+
+func (ukRule) Year(year int) (time.Time, time.Time) {
+	return time.Date(year, 4, 6, 0, 0, 0, 0, time.UTC), time.Date(year+1, 4, 6, 0, 0, 0, 0, time.UTC)
+}
+
+func (ukRule) disposals(asset string, events []taxEvent) []Disposal {
+	type event struct {
+		taxEvent
+		// not yet matched
+		left float64
+	}
+	var buys, sells []*event
+	for _, t := range events {
+		e := &event{t, t.qty}
+		if t.acquire {
+			buys = append(buys, e)
+		} else {
+			sells = append(sells, e)
+		}
+	}
+	var disposals []Disposal
+	match := func(sell, buy *event, how string) {
+		qty := sell.left
+		if qty > buy.left {
+			qty = buy.left
+		}
+		if qty <= 1e-12 {
+			return
+		}
+		cost := buy.amount * qty / buy.qty
+		proceeds := sell.amount * qty / sell.qty
+		disposals = append(disposals, Disposal{
+			Asset: asset, Acquired: buy.time, Disposed: sell.time, Qty: qty,
+			Proceeds: proceeds, Cost: cost, Gain: proceeds - cost, Match: how,
+		})
+		sell.left -= qty
+		buy.left -= qty
+	}
+	date := func(t time.Time) time.Time {
+		return t.UTC().Truncate(24 * time.Hour)
+	}
+	for _, s := range sells {
+		for _, b := range buys {
+			if date(b.time).Equal(date(s.time)) {
+				match(s, b, "same day")
+			}
+		}
+	}
+	for _, s := range sells {
+		for _, b := range buys {
+			after := date(b.time).Sub(date(s.time))
+			if after > 0 && after <= 30*24*time.Hour {
+				match(s, b, "30 day")
+			}
+		}
+	}
+	// whatever is left goes through the pool in time order
+	var pool []*event
+	pool = append(pool, buys...)
+	pool = append(pool, sells...)
+	sort.SliceStable(pool, func(i, j int) bool {
+		return pool[i].time.Before(pool[j].time)
+	})
+	var poolQty, poolCost float64
+	for _, e := range pool {
+		if e.left <= 1e-12 {
+			continue
+		}
+		if e.acquire {
+			poolQty += e.left
+			poolCost += e.amount * e.left / e.qty
+			continue
+		}
+		qty := e.left
+		cost := 0.0
+		if poolQty > 0 {
+			taken := qty
+			if taken > poolQty {
+				taken = poolQty
+			}
+			cost = poolCost * taken / poolQty
+			poolCost -= cost
+			poolQty -= taken
+		}
+		proceeds := e.amount * qty / e.qty
+		disposals = append(disposals, Disposal{
+			Asset: asset, Disposed: e.time, Qty: qty,
+			Proceeds: proceeds, Cost: cost, Gain: proceeds - cost, Match: "pool",
+		})
+	}
+	sort.SliceStable(disposals, func(i, j int) bool {
+		return disposals[i].Disposed.Before(disposals[j].Disposed)
+	})
+	return disposals
+}
+
+// CSV writes one row per disposal
+func (r TaxReport) CSV(w io.Writer) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"asset", "acquired", "disposed", "qty", "proceeds", "cost", "gain", "term", "exempt", "match", "currency"})
+	for _, d := range r.Disposals {
+		acquired := ""
+		if !d.Acquired.IsZero() {
+			acquired = d.Acquired.UTC().Format("2006-01-02")
+		}
+		out.Write([]string{
+			d.Asset,
+			acquired,
+			d.Disposed.UTC().Format("2006-01-02"),
+			strconv.FormatFloat(d.Qty, 'f', -1, 64),
+			strconv.FormatFloat(d.Proceeds, 'f', 2, 64),
+			strconv.FormatFloat(d.Cost, 'f', 2, 64),
+			strconv.FormatFloat(d.Gain, 'f', 2, 64),
+			d.Term,
+			strconv.FormatBool(d.Exempt),
+			d.Match,
+			strings.ToUpper(r.Currency),
+		})
+	}
+	out.Flush()
+	return out.Error()
+}
@@ -0,0 +1,158 @@
+package model
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestTax(t *testing.T) {
+	at := func(date string) time.Time {
+		d, err := time.Parse("2006-01-02 15:04", date)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	buy := func(date string, price, qty float64) Transaction {
+		return Transaction{ID: date, Source: "binance", Base: "BTC", Quote: "USDT", Time: at(date), IsBuyer: true, Price: price, Qty: qty}
+	}
+	sell := func(date string, price, qty float64) Transaction {
+		t := buy(date, price, qty)
+		t.IsBuyer = false
+		return t
+	}
+	withFee := func(t Transaction, fee float64) Transaction {
+		t.Fee = fee
+		t.FeeAsset = "USDT"
+		return t
+	}
+	tests := []struct {
+		name    string
+		rule    string
+		year    int
+		payload Payload
+		gain    float64
+		taxable float64
+		match   string
+	}{
+		{
+			name: "uk same day before earlier buys",
+			rule: "uk", year: 2023,
+			payload: Payload{Ledger: []Transaction{
+				buy("2023-01-10 10:00", 100, 1),
+				buy("2023-06-01 10:00", 200, 1),
+				sell("2023-06-01 12:00", 300, 1),
+			}},
+			gain: 100, taxable: 100, match: "same day",
+		},
+		{
+			name: "uk buy within 30 days after",
+			rule: "uk", year: 2023,
+			payload: Payload{Ledger: []Transaction{
+				buy("2023-01-10 10:00", 100, 1),
+				sell("2023-06-01 12:00", 300, 1),
+				buy("2023-06-10 10:00", 250, 1),
+			}},
+			gain: 50, taxable: 50, match: "30 day",
+		},
+		{
+			name: "uk pool at average cost",
+			rule: "uk", year: 2023,
+			payload: Payload{Ledger: []Transaction{
+				buy("2023-01-10 10:00", 100, 1),
+				buy("2023-02-10 10:00", 300, 1),
+				sell("2023-06-01 12:00", 400, 1),
+			}},
+			gain: 200, taxable: 200, match: "pool",
+		},
+		{
+			name: "de exempt after a year",
+			rule: "de", year: 2023,
+			payload: Payload{Ledger: []Transaction{
+				buy("2022-01-10 10:00", 100, 1),
+				sell("2023-03-01 12:00", 300, 1),
+			}},
+			gain: 200, taxable: 0, match: "lot",
+		},
+		{
+			name: "de taxed within a year",
+			rule: "de", year: 2023,
+			payload: Payload{Ledger: []Transaction{
+				buy("2023-01-10 10:00", 100, 1),
+				sell("2023-06-01 12:00", 300, 1),
+			}},
+			gain: 200, taxable: 200, match: "lot",
+		},
+		{
+			name: "us hifo sells the most expensive lot",
+			rule: "us-hifo", year: 2023,
+			payload: Payload{Ledger: []Transaction{
+				buy("2023-01-10 10:00", 100, 1),
+				buy("2023-02-10 10:00", 300, 1),
+				sell("2023-06-01 12:00", 250, 1),
+			}},
+			gain: -50, taxable: -50, match: "lot",
+		},
+		{
+			name: "us fifo sells the oldest lot",
+			rule: "us-fifo", year: 2023,
+			payload: Payload{Ledger: []Transaction{
+				buy("2023-01-10 10:00", 100, 1),
+				buy("2023-02-10 10:00", 300, 1),
+				sell("2023-06-01 12:00", 250, 1),
+			}},
+			gain: 150, taxable: 150, match: "lot",
+		},
+		{
+			name: "fees add to cost and reduce proceeds",
+			rule: "us-fifo", year: 2023,
+			payload: Payload{Ledger: []Transaction{
+				withFee(buy("2023-01-10 10:00", 100, 1), 1),
+				withFee(sell("2023-06-01 12:00", 200, 1), 2),
+			}},
+			gain: 97, taxable: 97, match: "lot",
+		},
+		{
+			name: "crypto quote is disposed of",
+			rule: "us-fifo", year: 2023,
+			payload: Payload{Ledger: []Transaction{
+				buy("2023-01-10 10:00", 100, 1),
+				{ID: "eth", Source: "binance", Base: "ETH", Quote: "BTC", Time: at("2023-06-01 12:00"), IsBuyer: true, Price: 0.1, Qty: 10},
+			}},
+			gain: 50, taxable: 50, match: "lot",
+		},
+		{
+			name: "trades before the ledger are seeded from pair totals",
+			rule: "us-fifo", year: 2023,
+			payload: Payload{
+				Binance: map[string]Asset{"BTC": {Pairs: map[string]Pair{"USDT": {
+					BuyQty: 1, Cost: 100, SellQty: 1, Revenue: 300,
+					EarliestTrade: &Trade{Time: at("2023-01-10 10:00")},
+					LatestTrade:   &Trade{Time: at("2023-06-01 12:00")},
+				}}}},
+				Ledger: []Transaction{sell("2023-06-01 12:00", 300, 1)},
+			},
+			gain: 200, taxable: 200, match: "lot",
+		},
+	}
+	v := NewValuer("usd", map[string]Coin{"btc": {Price: 150}, "eth": {Price: 15}}, FX{}, nil)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rule, err := ParseTaxRule(test.rule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			report := v.Tax(test.payload, rule, test.year)
+			if len(report.Disposals) != 1 {
+				t.Fatalf("got %d disposals, want 1: %+v", len(report.Disposals), report.Disposals)
+			}
+			if math.Abs(report.Gain-test.gain) > 1e-9 || math.Abs(report.Taxable-test.taxable) > 1e-9 {
+				t.Errorf("got gain %g taxable %g, want %g and %g", report.Gain, report.Taxable, test.gain, test.taxable)
+			}
+			if report.Disposals[0].Match != test.match {
+				t.Errorf("got match %s, want %s", report.Disposals[0].Match, test.match)
+			}
+		})
+	}
+}
@@ -0,0 +1,240 @@
+package model
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// Transfer is a deposit or withdrawal of an asset.
+// A withdrawal from one source should have a matching deposit in another
+type Transfer struct {
+	ID      string    `json:"id"`
+	Source  string    `json:"source"`
+	Asset   string    `json:"asset"`
+	Amount  float64   `json:"amount"`
+	Fee     float64   `json:"fee"`
+	Time    time.Time `json:"time"`
+	TxHash  string    `json:"tx_hash"`
+	Address string    `json:"address"`
+	Deposit bool      `json:"deposit"`
+	// id of the transfer on the other side
+	Match string `json:"match,omitempty"`
+	// no transfer on the other side within the match window.
+	// Deposits like this have no cost basis
+	Unmatched bool `json:"unmatched,omitempty"`
+}
+
+// deposits are usually credited within a day of the withdrawal.
+// Some are credited before the withdrawal is marked complete
+const (
+	matchWindow = 48 * time.Hour
+	matchSkew   = time.Hour
+)
+
+// RecordTransfers appends transfers that are not yet recorded
+func (p *Payload) RecordTransfers(transfers []Transfer) int {
+	seen := map[string]bool{}
+	for _, t := range p.Transfers {
+		seen[t.ID] = true
+	}
+	added := 0
+	for _, t := range transfers {
+		if seen[t.ID] {
+			continue
+		}
+		seen[t.ID] = true
+		p.Transfers = append(p.Transfers, t)
+		added++
+	}
+	return added
+}
+
+// LatestTransfer is the time of the newest transfer of a source
+func (p Payload) LatestTransfer(source string, fallback time.Time) time.Time {
+	latest := fallback
+	for _, t := range p.Transfers {
+		if t.Source == source && t.Time.After(latest) {
+			latest = t.Time
+		}
+	}
+	return latest
+}
+
+// transfers still pending when fetched are looked for again for this long
+const transferPending = 24 * time.Hour
+
+// TransfersSince is when to fetch a source's transfers from.
+// After its newest transfer, or a little before the last fetch
+func (p Payload) TransfersSince(source string, fallback time.Time) time.Time {
+	since := p.LatestTransfer(source, fallback)
+	if fetched, ok := p.TransfersFetched[source]; ok && fetched.Add(-transferPending).After(since) {
+		since = fetched.Add(-transferPending)
+	}
+	return since
+}
+
+// FetchedTransfers records that a source's transfers were fetched up to at
+func (p *Payload) FetchedTransfers(source string, at time.Time) {
+	if p.TransfersFetched == nil {
+		p.TransfersFetched = map[string]time.Time{}
+	}
+	p.TransfersFetched[source] = at
+}
+
+// normalizeTxHash strips the 0x prefix and kucoin's @output suffix
+func normalizeTxHash(hash string) string {
+	hash = strings.ToLower(strings.TrimSpace(hash))
+	hash = strings.SplitN(hash, "@", 2)[0]
+	return strings.TrimPrefix(hash, "0x")
+}
+
+// Received is whether a deposit amount is what a withdrawal sent
+// with or without the network fee taken out
+func (t Transfer) Received(amount float64) bool {
+	tolerance := 1e-8 + t.Amount*0.001
+	return math.Abs(amount-t.Amount) <= tolerance || math.Abs(amount-(t.Amount-t.Fee)) <= tolerance
+}
+
+// Sent is how much a withdrawal took out of its source when amount was received.
+// The fee is on top when the whole amount arrived
+func (t Transfer) Sent(received float64) float64 {
+	if math.Abs(received-t.Amount) < math.Abs(received-(t.Amount-t.Fee)) {
+		return t.Amount + t.Fee
+	}
+	return t.Amount
+}
+
+// MatchTransfers pairs withdrawals and deposits of the same asset that share a tx hash.
+// The rest are paired by amount and time and flagged when nothing matches
+func (p *Payload) MatchTransfers() int {
+	deposits := map[string]int{}
+	for i, t := range p.Transfers {
+		hash := normalizeTxHash(t.TxHash)
+		if t.Deposit && t.Match == "" && hash != "" {
+			deposits[strings.ToUpper(t.Asset)+"|"+hash] = i
+		}
+	}
+	matched := 0
+	for i, t := range p.Transfers {
+		if t.Deposit || t.Match != "" {
+			continue
+		}
+		j, ok := deposits[strings.ToUpper(t.Asset)+"|"+normalizeTxHash(t.TxHash)]
+		if !ok || p.Transfers[j].Source == t.Source {
+			continue
+		}
+		p.Transfers[i].Match = p.Transfers[j].ID
+		p.Transfers[j].Match = t.ID
+		matched++
+	}
+	for i, t := range p.Transfers {
+		if t.Deposit || t.Match != "" {
+			continue
+		}
+		best := -1
+		var bestGap time.Duration
+		for j, d := range p.Transfers {
+			if !d.Deposit || d.Match != "" || d.Source == t.Source || !strings.EqualFold(d.Asset, t.Asset) {
+				continue
+			}
+			gap := d.Time.Sub(t.Time)
+			if gap < -matchSkew || gap > matchWindow || !t.Received(d.Amount) {
+				continue
+			}
+			if t.TxHash != "" && d.TxHash != "" && normalizeTxHash(t.TxHash) != normalizeTxHash(d.TxHash) {
+				continue
+			}
+			if gap < 0 {
+				gap = -gap
+			}
+			if best < 0 || gap < bestGap {
+				best = j
+				bestGap = gap
+			}
+		}
+		if best < 0 {
+			continue
+		}
+		p.Transfers[i].Match = p.Transfers[best].ID
+		p.Transfers[best].Match = t.ID
+		matched++
+	}
+	for i, t := range p.Transfers {
+		p.Transfers[i].Unmatched = t.Match == "" && time.Since(t.Time) > matchWindow
+	}
+	return matched
+}
+
+// CarryCostBasis moves the average cost of each matched withdrawal to the receiving source.
+// The sending source sells what was sent at cost and the receiving source buys what was received at cost
+// so profit is unchanged, the received lots keep their original basis and the fee leaves the portfolio
+func (p *Payload) CarryCostBasis() (int, error) {
+	carried := map[string]bool{}
+	for _, t := range p.Ledger {
+		if strings.HasPrefix(t.ID, "transfer:") {
+			carried[strings.SplitN(t.ID, "|", 2)[0]] = true
+		}
+	}
+	byID := map[string]Transfer{}
+	for _, t := range p.Transfers {
+		byID[t.ID] = t
+	}
+	var txs []Transaction
+	for _, w := range p.Transfers {
+		d, ok := byID[w.Match]
+		if w.Deposit || !ok || carried["transfer:"+w.ID] {
+			continue
+		}
+		from, err := p.Source(w.Source)
+		if err != nil {
+			continue
+		}
+		if _, err := p.Source(d.Source); err != nil {
+			continue
+		}
+		asset, ok := from[w.Asset]
+		if !ok {
+			continue
+		}
+		bought := 0.0
+		for _, pair := range asset.Pairs {
+			bought += pair.BuyQty
+		}
+		if bought <= 0 {
+			// nothing to carry
+			continue
+		}
+		for quote, pair := range asset.Pairs {
+			if pair.BuyQty <= 0 {
+				continue
+			}
+			// lots are carried in proportion to how they were bought
+			share := pair.BuyQty / bought
+			price := pair.Cost / pair.BuyQty
+			id := fmt.Sprintf("transfer:%s|%s", w.ID, quote)
+			txs = append(txs, Transaction{
+				ID:     id + ":out",
+				Source: w.Source,
+				Base:   w.Asset,
+				Quote:  quote,
+				Time:   w.Time,
+				Price:  price,
+				Qty:    w.Sent(d.Amount) * share,
+				Note:   "transfer to " + d.Source,
+			}, Transaction{
+				ID:      id + ":in",
+				Source:  d.Source,
+				Base:    w.Asset,
+				Quote:   quote,
+				Time:    d.Time,
+				IsBuyer: true,
+				Price:   price,
+				Qty:     d.Amount * share,
+				Note:    "transfer from " + w.Source,
+			})
+		}
+	}
+	return p.Merge(txs)
+}
@@ -0,0 +1,60 @@
+package model
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestCarryCostBasis(t *testing.T) {
+	at := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	var p Payload
+	_, err := p.Merge([]Transaction{{ID: "binance:BTCUSDT:1", Source: "binance", Base: "BTC", Quote: "USDT", Time: at, IsBuyer: true, Price: 100, Qty: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the fee is on top of the amount that arrives
+	p.RecordTransfers([]Transfer{
+		{ID: "binance:w1", Source: "binance", Asset: "BTC", Amount: 0.5, Fee: 0.01, Time: at.Add(time.Hour), TxHash: "0xabc"},
+		{ID: "wallet:d1", Source: "wallet", Asset: "BTC", Amount: 0.5, Time: at.Add(2 * time.Hour), TxHash: "abc", Deposit: true},
+	})
+	if matched := p.MatchTransfers(); matched != 1 {
+		t.Fatalf("matched %d transfers, want 1", matched)
+	}
+	if carried, err := p.CarryCostBasis(); err != nil || carried != 2 {
+		t.Fatalf("carried %d trades with %v, want 2", carried, err)
+	}
+	if carried, _ := p.CarryCostBasis(); carried != 0 {
+		t.Errorf("carried %d trades again", carried)
+	}
+	sent, received := 0.0, 0.0
+	for _, tx := range p.Ledger {
+		switch tx.Source {
+		case "binance":
+			if !tx.IsBuyer {
+				sent += tx.Qty
+			}
+		case "wallet":
+			received += tx.Qty
+		}
+	}
+	if math.Abs(sent-0.51) > 1e-9 || math.Abs(received-0.5) > 1e-9 {
+		t.Errorf("sent %g and received %g, want 0.51 and 0.5", sent, received)
+	}
+
+	p.Binance["BTC"] = Asset{Balance: 0.49, Pairs: p.Binance["BTC"].Pairs}
+	p.Wallet["BTC"] = Asset{Balance: 0.5, Pairs: p.Wallet["BTC"].Pairs}
+	v := NewValuer("usd", map[string]Coin{"btc": {Price: 100}}, FX{}, nil)
+	cleaned, _ := Report(p, v, Options{Method: FIFO})
+	for _, c := range cleaned {
+		if c.Symbol != "BTC" {
+			continue
+		}
+		// the fee's cost leaves with it and nothing is sold
+		if math.Abs(c.CostBasis-99) > 1e-9 || math.Abs(c.Realized) > 1e-9 {
+			t.Errorf("got cost basis %g realized %g, want 99 and 0", c.CostBasis, c.Realized)
+		}
+		return
+	}
+	t.Error("BTC missing from the report")
+}
@@ -0,0 +1,225 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// currencies with ecb reference rates
+var fiats = map[string]bool{
+	"aud": true, "bgn": true, "brl": true, "cad": true, "chf": true, "cny": true,
+	"czk": true, "dkk": true, "eur": true, "gbp": true, "hkd": true, "huf": true,
+	"idr": true, "ils": true, "inr": true, "isk": true, "jpy": true, "krw": true,
+	"mxn": true, "myr": true, "nok": true, "nzd": true, "php": true, "pln": true,
+	"ron": true, "sek": true, "sgd": true, "thb": true, "try": true, "usd": true,
+	"zar": true,
+}
+
+// stablecoins are valued as the fiat they are pegged to
+var stablecoins = map[string]string{
+	"usdt":  "usd",
+	"busd":  "usd",
+	"usdc":  "usd",
+	"tusd":  "usd",
+	"usdp":  "usd",
+	"fdusd": "usd",
+	"dai":   "usd",
+	"ust":   "usd",
+	"eurt":  "eur",
+	"eurs":  "eur",
+}
+
+// IsFiat is whether reports can be made in a currency
+func IsFiat(currency string) bool {
+	return fiats[strings.ToLower(currency)]
+}
+
+// peg is the fiat an asset is valued as. Empty for other crypto
+func peg(asset string) string {
+	s := strings.ToLower(asset)
+	if fiats[s] {
+		return s
+	}
+	return stablecoins[s]
+}
+
+// Fiats are the currencies trades were valued in, directly or through a route,
+// and when the earliest trade was
+func (p Payload) Fiats() (map[string]bool, time.Time) {
+	currencies := map[string]bool{}
+	earliest := time.Now().AddDate(-1, 0, 0)
+	add := func(asset string, t time.Time) {
+		if c := peg(asset); c != "" {
+			currencies[c] = true
+		}
+		if t.Before(earliest) && !t.IsZero() {
+			earliest = t
+		}
+	}
+	for _, assets := range []map[string]Asset{p.Binance, p.Kucoin, p.Manual, p.Margin, p.Wallet} {
+		for symbol, a := range assets {
+			add(symbol, time.Now())
+			for quote, pair := range a.Pairs {
+				if pair.EarliestTrade == nil {
+					continue
+				}
+				add(quote, pair.EarliestTrade.Time)
+				for fee := range pair.Fees {
+					add(fee, pair.EarliestTrade.Time)
+				}
+			}
+		}
+	}
+	for _, t := range p.Ledger {
+		add(t.Quote, t.Time)
+	}
+	for symbol := range p.Futures.Income {
+		add(symbol, time.Now())
+	}
+	return currencies, earliest
+}
+
+// FX is daily exchange rates per usd
+type FX struct {
+	// sorted
+	dates []string
+	rates map[string]map[string]float64
+}
+
+type fxSeries struct {
+	Rates map[string]map[string]float64 `json:"rates"`
+}
+
+// FetchFX reads daily rates of the given currencies since a day
+func FetchFX(client *http.Client, currencies []string, since time.Time) (FX, error) {
+	fx := FX{rates: map[string]map[string]float64{}}
+	var to []string
+	for _, c := range currencies {
+		if c != "usd" {
+			to = append(to, strings.ToUpper(c))
+		}
+	}
+	if len(to) < 1 {
+		return fx, nil
+	}
+	sort.Strings(to)
+	url := fmt.Sprintf("https://api.frankfurter.app/%s..?from=USD&to=%s", since.UTC().Format("2006-01-02"), strings.Join(to, ","))
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return fx, err
+	}
+	req.Header.Add("cache-control", "max-age=86400")
+	res, err := client.Do(req)
+	if err != nil {
+		return fx, err
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return fx, err
+	}
+	defer res.Body.Close()
+	var series fxSeries
+	err = json.Unmarshal(body, &series)
+	if err != nil {
+		return fx, err
+	}
+	for date, rates := range series.Rates {
+		day := map[string]float64{}
+		for c, r := range rates {
+			day[strings.ToLower(c)] = r
+		}
+		fx.rates[date] = day
+		fx.dates = append(fx.dates, date)
+	}
+	sort.Strings(fx.dates)
+	if len(fx.dates) < 1 {
+		return fx, fmt.Errorf("no exchange rates for %s", strings.Join(to, ","))
+	}
+	return fx, nil
+}
+
+// rate is units of currency per usd on the latest business day at or before t
+func (fx FX) rate(currency string, t time.Time) float64 {
+	if currency == "usd" {
+		return 1
+	}
+	date := t.UTC().Format("2006-01-02")
+	i := sort.SearchStrings(fx.dates, date)
+	if i >= len(fx.dates) || fx.dates[i] != date {
+		i--
+	}
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(fx.dates) {
+		return 0
+	}
+	return fx.rates[fx.dates[i]][currency]
+}
+
+// convert amount of one fiat to another at t
+func (fx FX) convert(amount float64, from, to string, t time.Time) float64 {
+	if from == to {
+		return amount
+	}
+	rate := fx.rate(from, t)
+	if rate == 0 {
+		return 0
+	}
+	return amount * fx.rate(to, t) / rate
+}
+
+// Valuer values assets in the reporting currency
+type Valuer struct {
+	Currency string
+	// current prices in the reporting currency
+	coins map[string]Coin
+	fx    FX
+	// routes other crypto to a fiat at the time of a trade
+	graph *Graph
+}
+
+func NewValuer(currency string, coins map[string]Coin, fx FX, graph *Graph) Valuer {
+	return Valuer{Currency: currency, coins: coins, fx: fx, graph: graph}
+}
+
+// price is the current price of one unit of asset
+func (v Valuer) price(asset string) float64 {
+	if coin, ok := v.coins[strings.ToLower(asset)]; ok && coin.Price > 0 && peg(asset) == "" {
+		return coin.Price
+	}
+	return v.value(asset, 1, time.Now())
+}
+
+// value of amount of asset at t.
+// Fiat and stablecoins are converted at the exchange rate on the day.
+// Other crypto through its route of markets on the day, or its current price when it has none
+func (v Valuer) value(asset string, amount float64, t time.Time) float64 {
+	if p := peg(asset); p != "" {
+		return v.fx.convert(amount, p, v.Currency, t)
+	}
+	if v.graph != nil {
+		if p, rate, ok := v.graph.rate(asset, t); ok {
+			return v.fx.convert(amount*rate, p, v.Currency, t)
+		}
+	}
+	return amount * v.coins[strings.ToLower(asset)].Price
+}
+
+// path describes how an asset is valued
+func (v Valuer) path(asset string) string {
+	if p := peg(asset); p != "" {
+		return strings.ToUpper(asset + " → " + v.Currency)
+	}
+	if v.graph != nil {
+		if path := v.graph.path(asset, v.Currency); path != "" {
+			return path
+		}
+	}
+	return strings.ToUpper(asset) + " → coingecko"
+}
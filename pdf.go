@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// A4 in points
+const (
+	pageWidth  = 595.0
+	pageHeight = 842.0
+	pageMargin = 40.0
+)
+
+// pdf fonts. Built into every reader so nothing is embedded
+const (
+	pdfRegular = "F1"
+	pdfBold    = "F2"
+	pdfMono    = "F3"
+)
+
+// pdfDocument writes text and lines onto A4 pages top to bottom.
+// Only the standard fonts and latin-1 text are supported
+type pdfDocument struct {
+	pages []*bytes.Buffer
+	page  *bytes.Buffer
+	// distance of the next line from the bottom of the page
+	y float64
+}
+
+func newPDF() *pdfDocument {
+	d := &pdfDocument{}
+	d.addPage()
+	return d
+}
+
+func (d *pdfDocument) addPage() {
+	d.page = &bytes.Buffer{}
+	d.pages = append(d.pages, d.page)
+	d.y = pageHeight - pageMargin
+}
+
+// space moves down by height, starting a new page when it does not fit
+func (d *pdfDocument) space(height float64) {
+	if d.y-height < pageMargin {
+		d.addPage()
+	}
+	d.y -= height
+}
+
+// text writes s with its baseline at the current line
+func (d *pdfDocument) text(x float64, font string, size float64, s string) {
+	fmt.Fprintf(d.page, "BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, d.y, pdfEscape(s))
+}
+
+// line writes s on its own line
+func (d *pdfDocument) line(font string, size float64, s string) {
+	d.space(size * 1.4)
+	d.text(pageMargin, font, size, s)
+}
+
+// courier is this wide for each point of its size
+const monoWidth = 0.6
+
+// table writes a heading, when there is one, and rows in monospace columns.
+// Right aligned columns hold numbers and widen to fit them. Other columns narrow to fit the page and wrap
+func (d *pdfDocument) table(size float64, widths []int, right []bool, heading []string, rows [][]string) {
+	widths = append([]int{}, widths...)
+	for _, cells := range append([][]string{heading}, rows...) {
+		for i, c := range cells {
+			if right[i] && len(c) > widths[i] {
+				widths[i] = len(c)
+			}
+		}
+	}
+	fits := int((pageWidth - 2*pageMargin) / (monoWidth * size))
+	for {
+		total, widest := len(widths)-1, -1
+		for i, w := range widths {
+			total += w
+			if !right[i] && w > 4 && (widest < 0 || w > widths[widest]) {
+				widest = i
+			}
+		}
+		if total <= fits || widest < 0 {
+			break
+		}
+		widths[widest] -= total - fits
+		if widths[widest] < 4 {
+			widths[widest] = 4
+		}
+	}
+	if heading != nil {
+		d.row(size, widths, right, heading, true)
+	}
+	for _, cells := range rows {
+		d.row(size, widths, right, cells, false)
+	}
+}
+
+// row writes cells in monospace columns. Right aligned columns are padded on the left.
+// Cells wider than their column go on as many lines as they need
+func (d *pdfDocument) row(size float64, widths []int, right []bool, cells []string, heading bool) {
+	lines := 1
+	for i, c := range cells {
+		if n := (len(c) + widths[i] - 1) / widths[i]; !right[i] && n > lines {
+			lines = n
+		}
+	}
+	for line := 0; line < lines; line++ {
+		d.space(size * 1.4)
+		var b strings.Builder
+		for i, c := range cells {
+			switch {
+			case right[i] && line > 0:
+				c = ""
+			case !right[i]:
+				start, end := line*widths[i], (line+1)*widths[i]
+				if start > len(c) {
+					start = len(c)
+				}
+				if end > len(c) {
+					end = len(c)
+				}
+				c = c[start:end]
+			}
+			pad := ""
+			if len(c) < widths[i] {
+				pad = strings.Repeat(" ", widths[i]-len(c))
+			}
+			if right[i] {
+				b.WriteString(pad + c)
+			} else {
+				b.WriteString(c + pad)
+			}
+			b.WriteString(" ")
+		}
+		if heading && line == lines-1 {
+			fmt.Fprintf(d.page, "0.5 w %.2f %.2f m %.2f %.2f l S\n", pageMargin, d.y-2, pageWidth-pageMargin, d.y-2)
+		}
+		d.text(pageMargin, pdfMono, size, strings.TrimRight(b.String(), " "))
+	}
+}
+
+// bytes is the finished document
+func (d *pdfDocument) bytes() []byte {
+	var out bytes.Buffer
+	var offsets []int
+	object := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+	out.WriteString("%PDF-1.4\n")
+	// 1 catalog, 2 pages, 3-5 fonts, then a page and its content for each page
+	var kids []string
+	for i := range d.pages {
+		kids = append(kids, fmt.Sprintf("%d 0 R", 6+i*2))
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	for _, font := range []string{"Helvetica", "Helvetica-Bold", "Courier"} {
+		object(fmt.Sprintf("<< /Type /Font /Subtype /Type1 /BaseFont /%s /Encoding /WinAnsiEncoding >>", font))
+	}
+	for i, page := range d.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources << /Font << /%s 3 0 R /%s 4 0 R /%s 5 0 R >> >> /Contents %d 0 R >>",
+			pageWidth, pageHeight, pdfRegular, pdfBold, pdfMono, 7+i*2))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", page.Len(), page.String()))
+	}
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, o := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", o)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return out.Bytes()
+}
+
+// pdfEscape makes s safe inside a pdf string. Characters outside latin-1 become ?
+func pdfEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '\\' || r == '(' || r == ')':
+			b.WriteRune('\\')
+			b.WriteRune(r)
+		case r == '→':
+			b.WriteString("->")
+		case r < 32:
+		case r < 128:
+			b.WriteRune(r)
+		case r < 256:
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteRune('?')
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTableKeepsNumbers(t *testing.T) {
+	d := newPDF()
+	d.table(8, []int{6, 4}, []bool{false, true}, []string{"Asset", "Value"}, [][]string{
+		{"LONGNAMEDTOKEN", "1,234,567.89"},
+	})
+	page := d.page.String()
+	if !strings.Contains(page, "1,234,567.89") {
+		t.Errorf("value was cut: %s", page)
+	}
+	// the name wraps onto a second line
+	if !strings.Contains(page, "(LONGNA ") || !strings.Contains(page, "(MEDTOK)") || !strings.Contains(page, "(EN)") {
+		t.Errorf("name did not wrap: %s", page)
+	}
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/enzosv/binalysis/model"
+	"github.com/pkg/errors"
+)
+
+// coingecko accepts this many ids per simple/price request
+const priceBatch = 250
+
+// the coin list barely changes
+const coinListTTL = 24 * time.Hour
+
+// ids not asked for in this long stop being refreshed
+const trackTTL = 7 * 24 * time.Hour
+
+// CoinListing is an entry of coingecko's coins/list
+type CoinListing struct {
+	ID     string `json:"id"`
+	Symbol string `json:"symbol"`
+	Name   string `json:"name"`
+}
+
+// PriceCache refreshes coingecko prices on an interval so browsers don't each call coingecko.
+// Prices are kept in the shape of simple/price: coin id then currency fields
+type PriceCache struct {
+	client   *http.Client
+	base     string
+	dir      string
+	interval time.Duration
+	verbose  bool
+
+	mu       sync.RWMutex
+	coins    []CoinListing
+	listed   map[string]bool
+	listedAt time.Time
+	prices   map[string]map[string]float64
+	// ids and currencies clients asked for, and when they last did
+	tracked    map[string]time.Time
+	currencies map[string]time.Time
+	updatedAt  time.Time
+}
+
+// NewPriceCache stores daily snapshots under <store>/prices.
+// Base is coingecko's api or a mirror of it
+func NewPriceCache(base, store string, interval time.Duration, verbose bool) (*PriceCache, error) {
+	dir := filepath.Join(store, "prices")
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	c := &PriceCache{
+		client:     &http.Client{Timeout: 30 * time.Second},
+		base:       strings.TrimSuffix(base, "/"),
+		dir:        dir,
+		interval:   interval,
+		verbose:    verbose,
+		prices:     map[string]map[string]float64{},
+		tracked:    map[string]time.Time{},
+		currencies: map[string]time.Time{"usd": time.Now()},
+	}
+	// continue from today's snapshot
+	snapshot, err := c.snapshot(time.Now())
+	if err == nil {
+		for id, fields := range snapshot.Prices {
+			c.prices[id] = fields
+			c.tracked[id] = time.Now()
+		}
+	}
+	return c, nil
+}
+
+// Run refreshes every interval until the process exits
+func (c *PriceCache) Run() {
+	for {
+		err := c.refresh()
+		if err != nil {
+			fmt.Println(err)
+		}
+		time.Sleep(c.interval)
+	}
+}
+
+func (c *PriceCache) get(path string, out interface{}) error {
+	res, err := c.client.Get(c.base + path)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		// rate limited
+		return fmt.Errorf("%s: %s", path, res.Status)
+	}
+	return json.Unmarshal(body, out)
+}
+
+func (c *PriceCache) refresh() error {
+	c.mu.RLock()
+	stale := time.Since(c.listedAt) > coinListTTL
+	var ids, currencies []string
+	for id, asked := range c.tracked {
+		if time.Since(asked) < trackTTL {
+			ids = append(ids, id)
+		}
+	}
+	for currency, asked := range c.currencies {
+		if time.Since(asked) < trackTTL {
+			currencies = append(currencies, currency)
+		}
+	}
+	c.mu.RUnlock()
+
+	if stale {
+		err := c.list()
+		if err != nil {
+			// prices can still be refreshed
+			fmt.Println(err)
+		}
+	}
+	if len(ids) < 1 {
+		return nil
+	}
+	prices, err := c.fetch(ids, currencies)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	for id, fields := range prices {
+		c.prices[id] = fields
+	}
+	c.updatedAt = time.Now()
+	c.mu.Unlock()
+	if c.verbose {
+		fmt.Printf("cached %d prices in %s\n", len(prices), strings.Join(currencies, ","))
+	}
+	return c.persist()
+}
+
+// list reads coingecko's coin list
+func (c *PriceCache) list() error {
+	var coins []CoinListing
+	err := c.get("/coins/list", &coins)
+	if err != nil {
+		return errors.Wrap(err, "coin list")
+	}
+	listed := map[string]bool{}
+	for _, coin := range coins {
+		listed[coin.ID] = true
+	}
+	c.mu.Lock()
+	c.coins = coins
+	c.listed = listed
+	c.listedAt = time.Now()
+	c.mu.Unlock()
+	return nil
+}
+
+// fetch asks coingecko for ids in batches
+func (c *PriceCache) fetch(ids, currencies []string) (map[string]map[string]float64, error) {
+	sort.Strings(ids)
+	prices := map[string]map[string]float64{}
+	for start := 0; start < len(ids); start += priceBatch {
+		end := start + priceBatch
+		if end > len(ids) {
+			end = len(ids)
+		}
+		params := url.Values{}
+		params.Set("ids", strings.Join(ids[start:end], ","))
+		params.Set("vs_currencies", strings.Join(currencies, ","))
+		params.Set("include_24hr_change", "true")
+		params.Set("include_market_cap", "true")
+		var batch map[string]map[string]float64
+		err := c.get("/simple/price?"+params.Encode(), &batch)
+		if err != nil {
+			return prices, errors.Wrap(err, "simple price")
+		}
+		for id, fields := range batch {
+			prices[id] = fields
+		}
+	}
+	return prices, nil
+}
+
+// PriceSnapshot is the cache as it was at a time
+type PriceSnapshot struct {
+	Time   time.Time                     `json:"time"`
+	Prices map[string]map[string]float64 `json:"prices"`
+}
+
+func (c *PriceCache) snapshotPath(t time.Time) string {
+	return filepath.Join(c.dir, t.UTC().Format("2006-01-02")+".json")
+}
+
+// persist overwrites the day's snapshot with the latest prices
+func (c *PriceCache) persist() error {
+	c.mu.RLock()
+	snapshot := PriceSnapshot{Time: c.updatedAt, Prices: c.prices}
+	content, err := json.Marshal(snapshot)
+	c.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(c.snapshotPath(snapshot.Time), content, 0644)
+}
+
+func (c *PriceCache) snapshot(day time.Time) (PriceSnapshot, error) {
+	var snapshot PriceSnapshot
+	content, err := ioutil.ReadFile(c.snapshotPath(day))
+	if err != nil {
+		return snapshot, err
+	}
+	err = json.Unmarshal(content, &snapshot)
+	return snapshot, err
+}
+
+// lookup returns the cached fields of ids in the given currencies.
+// Ids or currencies not cached yet are fetched now and tracked from then on.
+// Only coins on coingecko's list and currencies reports can be made in are tracked
+func (c *PriceCache) lookup(ids, currencies []string) map[string]map[string]float64 {
+	c.mu.RLock()
+	listed := len(c.listed) > 0
+	c.mu.RUnlock()
+	if !listed {
+		err := c.list()
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+	c.mu.Lock()
+	var known []string
+	for _, id := range ids {
+		if c.listed[id] {
+			known = append(known, id)
+		}
+	}
+	ids = known
+	var supported []string
+	for _, currency := range currencies {
+		if model.IsFiat(currency) {
+			supported = append(supported, currency)
+		}
+	}
+	currencies = supported
+	var missing []string
+	for _, id := range ids {
+		if _, ok := c.tracked[id]; !ok {
+			missing = append(missing, id)
+		}
+		c.tracked[id] = time.Now()
+	}
+	newCurrency := false
+	for _, currency := range currencies {
+		if _, ok := c.currencies[currency]; !ok {
+			newCurrency = true
+		}
+		c.currencies[currency] = time.Now()
+	}
+	c.mu.Unlock()
+
+	if newCurrency {
+		// every id is missing this currency
+		missing = ids
+	}
+	if len(missing) > 0 && len(currencies) > 0 {
+		prices, err := c.fetch(missing, currencies)
+		c.mu.Lock()
+		for id, fields := range prices {
+			if c.prices[id] == nil {
+				c.prices[id] = map[string]float64{}
+			}
+			for k, v := range fields {
+				c.prices[id][k] = v
+			}
+		}
+		c.updatedAt = time.Now()
+		c.mu.Unlock()
+		if err != nil {
+			fmt.Println(err)
+		}
+		err = c.persist()
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return pick(c.prices, ids, currencies)
+}
+
+// pick keeps the fields of the given currencies, like simple/price would return them
+func pick(prices map[string]map[string]float64, ids, currencies []string) map[string]map[string]float64 {
+	picked := map[string]map[string]float64{}
+	for _, id := range ids {
+		fields, ok := prices[id]
+		if !ok {
+			continue
+		}
+		out := map[string]float64{}
+		for _, currency := range currencies {
+			for _, suffix := range []string{"", "_market_cap", "_24h_change"} {
+				if v, ok := fields[currency+suffix]; ok {
+					out[currency+suffix] = v
+				}
+			}
+		}
+		if len(out) > 0 {
+			picked[id] = out
+		}
+	}
+	return picked
+}
+
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(strings.ToLower(s), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// PricesHandler serves cached prices in the shape of coingecko's simple/price.
+// ?date=2006-01-02 serves that day's snapshot instead
+func PricesHandler(cache *PriceCache, verbose bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Content-Type", "application/json")
+		ids := splitList(r.URL.Query().Get("ids"))
+		currencies := splitList(r.URL.Query().Get("vs_currencies"))
+		if len(ids) < 1 || len(currencies) < 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "ids and vs_currencies are required"})
+			return
+		}
+		if date := r.URL.Query().Get("date"); date != "" {
+			day, err := time.Parse("2006-01-02", date)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+				return
+			}
+			snapshot, err := cache.snapshot(day)
+			if err != nil {
+				w.WriteHeader(http.StatusNotFound)
+				json.NewEncoder(w).Encode(map[string]string{"error": "no snapshot on " + date})
+				return
+			}
+			json.NewEncoder(w).Encode(pick(snapshot.Prices, ids, currencies))
+			return
+		}
+		prices := cache.lookup(ids, currencies)
+		if verbose {
+			fmt.Printf("served %d of %d prices\n", len(prices), len(ids))
+		}
+		w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", int(cache.interval.Seconds())))
+		json.NewEncoder(w).Encode(prices)
+	}
+}
+
+// CoinsHandler serves the cached coin list in the shape of coingecko's coins/list
+func CoinsHandler(cache *PriceCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Content-Type", "application/json")
+		cache.mu.RLock()
+		coins := cache.coins
+		cache.mu.RUnlock()
+		if len(coins) < 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(map[string]string{"error": "coin list not loaded yet"})
+			return
+		}
+		w.Header().Set("Cache-Control", "max-age=86400")
+		json.NewEncoder(w).Encode(coins)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/enzosv/binalysis/model"
+)
+
+// reportOptions reads currency, method, dust and prices from the query
+func reportOptions(r *http.Request) (model.Options, error) {
+	q := r.URL.Query()
+	opts := model.Options{
+		Currency: q.Get("currency"),
+		Method:   strings.ToLower(q.Get("method")),
+	}
+	if prices := q.Get("prices"); prices != "" {
+		opts.Providers = strings.Split(prices, ",")
+	}
+	if dust := q.Get("dust"); dust != "" {
+		var err error
+		opts.Dust, err = strconv.ParseFloat(dust, 64)
+		if err != nil {
+			return opts, fmt.Errorf("dust must be a number")
+		}
+	}
+	opts = opts.Defaults()
+	return opts, opts.Validate()
+}
+
+// reportRequest is a stored report and how to evaluate it
+type reportRequest struct {
+	key      string
+	opts     model.Options
+	payload  Payload
+	mappings []CoinMapping
+	client   *http.Client
+}
+
+// loadReport reads the report of the request's key and its options.
+// The error is written and false returned when there is no report or the options are invalid
+func loadReport(w http.ResponseWriter, r *http.Request, store string, mappings []CoinMapping) (reportRequest, bool) {
+	key := r.Header.Get("X-API-Key")
+	path := fmt.Sprintf("%s/%s.json", store, key)
+	if _, err := os.Stat(path); key == "" || err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "no report for this key. Update first"})
+		return reportRequest{}, false
+	}
+	opts, err := reportOptions(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return reportRequest{}, false
+	}
+	payload := loadExisting(path)
+	return reportRequest{
+		key:      key,
+		opts:     opts,
+		payload:  payload,
+		mappings: mergeMappings(knownMappings, mappings, payload.Mappings),
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}, true
+}
+
+// evaluationFailed writes an error from valuing a report. Prices and rates come from upstream
+func evaluationFailed(w http.ResponseWriter, err error) {
+	fmt.Println(err)
+	w.WriteHeader(http.StatusBadGateway)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
+
+// ReportHandler computes the same rows and totals as the web client.
+// Prices come from the server's own cache and static prices at self, falling back to the providers
+func ReportHandler(store string, mappings []CoinMapping, self string, verbose bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Content-Type", "application/json")
+		req, ok := loadReport(w, r, store, mappings)
+		if !ok {
+			return
+		}
+		cleaned, summary, err := model.Evaluate(req.client, req.payload, req.mappings, self, req.opts)
+		if err != nil {
+			evaluationFailed(w, err)
+			return
+		}
+		if verbose {
+			fmt.Printf("reported %d assets in %s\n", len(cleaned), req.opts.Currency)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"binance":     cleaned,
+			"summary":     summary,
+			"currency":    req.opts.Currency,
+			"last_update": req.payload.LastUpdate,
+		})
+	}
+}
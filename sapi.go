@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	binance2 "github.com/adshao/go-binance/v2"
+	common "github.com/adshao/go-binance/v2/common"
+)
+
+// signedGet calls a signed binance endpoint that the client library does not cover yet
+func signedGet(ctx context.Context, client *binance2.Client, endpoint string, params url.Values, result interface{}) error {
+	return signedGetURL(ctx, client, client.BaseURL, endpoint, params, result)
+}
+
+// signedGetURL is signedGet against another binance api like dapi.binance.com
+func signedGetURL(ctx context.Context, client *binance2.Client, baseURL, endpoint string, params url.Values, result interface{}) error {
+	if params == nil {
+		params = url.Values{}
+	}
+	params.Set("recvWindow", "60000")
+	params.Set("timestamp", strconv.FormatInt(time.Now().UnixMilli()-client.TimeOffset, 10))
+	query := params.Encode()
+	mac := hmac.New(sha256.New, []byte(client.SecretKey))
+	mac.Write([]byte(query))
+	query = fmt.Sprintf("%s&signature=%x", query, mac.Sum(nil))
+
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s%s?%s", baseURL, endpoint, query), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-MBX-APIKEY", client.APIKey)
+	res, err := client.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode >= http.StatusBadRequest {
+		// same error as the client library so rate limits are handled the same
+		apiErr := new(common.APIError)
+		if json.Unmarshal(body, apiErr) != nil {
+			return fmt.Errorf("%s: %s", endpoint, res.Status)
+		}
+		return apiErr
+	}
+	return json.Unmarshal(body, result)
+}
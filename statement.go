@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/enzosv/binalysis/model"
+)
+
+// StatementHandler serves a printable statement from ?from= until ?to= as ?format=html or pdf.
+// Dates are YYYY-MM-DD. The period is the year so far when left out
+func StatementHandler(store string, mappings []CoinMapping, self string, verbose bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Content-Type", "application/json")
+		req, ok := loadReport(w, r, store, mappings)
+		if !ok {
+			return
+		}
+		start, end, err := statementPeriod(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = "html"
+		}
+		if format != "html" && format != "pdf" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "format must be html or pdf"})
+			return
+		}
+		statement, err := model.EvaluateStatement(req.client, req.payload, req.mappings, self, req.opts, start, end)
+		if err != nil {
+			evaluationFailed(w, err)
+			return
+		}
+		if verbose {
+			fmt.Printf("statement of %d trades as %s\n", len(statement.Trades), format)
+		}
+		name := fmt.Sprintf("statement-%s-%s", start.Format("2006-01-02"), end.AddDate(0, 0, -1).Format("2006-01-02"))
+		if format == "pdf" {
+			w.Header().Set("Content-Type", "application/pdf")
+			w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"%s.pdf\"", name))
+			w.Write(statementPDF(statement))
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		err = statementHTML(w, statement)
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+}
+
+// statementPeriod reads from and to as days. to is included
+func statementPeriod(r *http.Request) (time.Time, time.Time, error) {
+	now := time.Now().UTC()
+	start := time.Date(now.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+	end := now.Truncate(24*time.Hour).AddDate(0, 0, 1)
+	q := r.URL.Query()
+	if from := q.Get("from"); from != "" {
+		t, err := time.Parse("2006-01-02", from)
+		if err != nil {
+			return start, end, fmt.Errorf("from must be YYYY-MM-DD")
+		}
+		start = t
+	}
+	if to := q.Get("to"); to != "" {
+		t, err := time.Parse("2006-01-02", to)
+		if err != nil {
+			return start, end, fmt.Errorf("to must be YYYY-MM-DD")
+		}
+		end = t.AddDate(0, 0, 1)
+	}
+	if !start.Before(end) {
+		return start, end, fmt.Errorf("from must be before to")
+	}
+	return start, end, nil
+}
+
+// money formats an amount with thousands separators and 2 decimals
+func money(amount float64) string {
+	s := strconv.FormatFloat(amount, 'f', 2, 64)
+	sign := ""
+	if strings.HasPrefix(s, "-") {
+		sign, s = "-", s[1:]
+	}
+	whole, decimals := s[:len(s)-3], s[len(s)-3:]
+	var groups []string
+	for len(whole) > 3 {
+		groups = append([]string{whole[len(whole)-3:]}, groups...)
+		whole = whole[:len(whole)-3]
+	}
+	groups = append([]string{whole}, groups...)
+	return sign + strings.Join(groups, ",") + decimals
+}
+
+func quantity(amount float64) string {
+	return strconv.FormatFloat(amount, 'f', -1, 64)
+}
+
+var statementTemplate = template.Must(template.New("statement").Funcs(template.FuncMap{
+	"money":    money,
+	"quantity": quantity,
+	"date": func(t time.Time) string {
+		return t.UTC().Format("2006-01-02")
+	},
+	"last": func(t time.Time) string {
+		return t.UTC().AddDate(0, 0, -1).Format("2006-01-02")
+	},
+	"upper": strings.ToUpper,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Binalysis statement {{date .Start}} to {{last .End}}</title>
+<style>
+body { font-family: Helvetica, Arial, sans-serif; font-size: 12px; margin: 2em; color: #212529 }
+h1 { font-size: 20px; margin-bottom: 0 }
+h2 { font-size: 15px; margin-top: 2em; border-bottom: 1px solid #dee2e6 }
+table { border-collapse: collapse; width: 100% }
+th, td { padding: 2px 6px; text-align: right; white-space: nowrap }
+th:first-child, td:first-child { text-align: left }
+tr:nth-child(even) { background: #f8f9fa }
+.muted { color: #6c757d }
+@media print { body { margin: 0 } }
+</style>
+</head>
+<body>
+<h1>Portfolio statement</h1>
+<p class="muted">{{date .Start}} to {{last .End}} in {{upper .Currency}}. {{.Method}} cost basis. Generated {{.Generated.Format "2006-01-02 15:04"}}</p>
+
+<h2>Summary</h2>
+<table>
+<tr><td>Value at the start</td><td>{{money .OpeningValue}}</td></tr>
+<tr><td>Value at the end</td><td>{{money .ClosingValue}}</td></tr>
+<tr><td>Realized in the period</td><td>{{money .Realized}}</td></tr>
+<tr><td>Cost to date</td><td>{{money .Summary.Cost}}</td></tr>
+<tr><td>Revenue to date</td><td>{{money .Summary.Revenue}}</td></tr>
+<tr><td>Fees to date</td><td>{{money .Summary.Fees}}</td></tr>
+<tr><td>Profit to date</td><td>{{money .Summary.Profit}}</td></tr>
+<tr><td>Realized to date</td><td>{{money .Summary.Realized}}</td></tr>
+<tr><td>Unrealized</td><td>{{money .Summary.Unrealized}}</td></tr>
+</table>
+
+<h2>Holdings</h2>
+<p class="muted">At current prices</p>
+<table>
+<tr><th>Asset</th><th>Balance</th><th>Price</th><th>Value</th><th>Cost basis</th><th>Unrealized</th></tr>
+{{range .Holdings}}<tr><td>{{.Symbol}}</td><td>{{quantity .Balance}}</td><td>{{money .Price}}</td><td>{{money .Value}}</td><td>{{money .CostBasis}}</td><td>{{money .Unrealized}}</td></tr>
+{{end}}</table>
+
+<h2>Income</h2>
+<p class="muted">Received in the period, valued when received</p>
+<table>
+<tr><th>Asset</th><th>Kind</th><th>Value</th></tr>
+{{range .Income}}<tr><td>{{.Asset}}</td><td>{{.Kind}}</td><td>{{money .Value}}</td></tr>
+{{else}}<tr><td class="muted">None</td></tr>
+{{end}}</table>
+
+<h2>Fees</h2>
+<table>
+<tr><th>Asset</th><th>Kind</th><th>Amount</th><th>Value</th></tr>
+{{range .Fees}}<tr><td>{{.Asset}}</td><td>{{.Kind}}</td><td>{{quantity .Amount}}</td><td>{{money .Value}}</td></tr>
+{{else}}<tr><td class="muted">None</td></tr>
+{{end}}</table>
+
+<h2>Trades</h2>
+<table>
+<tr><th>Date</th><th>Source</th><th>Side</th><th>Pair</th><th>Qty</th><th>Price</th><th>Value</th><th>Fee</th></tr>
+{{range .Trades}}<tr><td>{{.Time.UTC.Format "2006-01-02 15:04"}}</td><td>{{.Source}}</td><td>{{.Side}}</td><td>{{.Base}}/{{.Quote}}</td><td>{{quantity .Qty}}</td><td>{{quantity .Price}}</td><td>{{money .Value}}</td><td>{{quantity .Fee}} {{.FeeAsset}}</td></tr>
+{{else}}<tr><td class="muted">None</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+func statementHTML(w io.Writer, s model.Statement) error {
+	return statementTemplate.Execute(w, s)
+}
+
+func statementPDF(s model.Statement) []byte {
+	d := newPDF()
+	const size = 8.0
+	d.line(pdfBold, 16, "Portfolio statement")
+	d.line(pdfRegular, 9, fmt.Sprintf("%s to %s in %s. %s cost basis. Generated %s",
+		s.Start.Format("2006-01-02"), s.End.AddDate(0, 0, -1).Format("2006-01-02"), strings.ToUpper(s.Currency), s.Method, s.Generated.Format("2006-01-02 15:04")))
+	section := func(title, note string) {
+		d.space(10)
+		d.line(pdfBold, 12, title)
+		if note != "" {
+			d.line(pdfRegular, 8, note)
+		}
+	}
+
+	section("Summary", "")
+	d.table(size, []int{30, 20}, []bool{false, true}, nil, [][]string{
+		{"Value at the start", money(s.OpeningValue)},
+		{"Value at the end", money(s.ClosingValue)},
+		{"Realized in the period", money(s.Realized)},
+		{"Cost to date", money(s.Summary.Cost)},
+		{"Revenue to date", money(s.Summary.Revenue)},
+		{"Fees to date", money(s.Summary.Fees)},
+		{"Profit to date", money(s.Summary.Profit)},
+		{"Realized to date", money(s.Summary.Realized)},
+		{"Unrealized", money(s.Summary.Unrealized)},
+	})
+
+	section("Holdings", "At current prices")
+	var rows [][]string
+	for _, h := range s.Holdings {
+		rows = append(rows, []string{h.Symbol, quantity(h.Balance), money(h.Price), money(h.Value), money(h.CostBasis), money(h.Unrealized)})
+	}
+	d.table(size, []int{10, 18, 14, 14, 14, 14}, []bool{false, true, true, true, true, true},
+		[]string{"Asset", "Balance", "Price", "Value", "Cost basis", "Unrealized"}, rows)
+
+	section("Income", "Received in the period, valued when received")
+	rows = nil
+	for _, i := range s.Income {
+		rows = append(rows, []string{i.Asset, i.Kind, money(i.Value)})
+	}
+	d.table(size, []int{10, 16, 14}, []bool{false, false, true}, []string{"Asset", "Kind", "Value"}, rows)
+
+	section("Fees", "")
+	rows = nil
+	for _, f := range s.Fees {
+		rows = append(rows, []string{f.Asset, f.Kind, quantity(f.Amount), money(f.Value)})
+	}
+	d.table(size, []int{10, 18, 18, 14}, []bool{false, false, true, true}, []string{"Asset", "Kind", "Amount", "Value"}, rows)
+
+	section("Trades", "")
+	rows = nil
+	for _, t := range s.Trades {
+		rows = append(rows, []string{
+			t.Time.UTC().Format("2006-01-02 15:04"), t.Source, t.Side, t.Base + "/" + t.Quote,
+			quantity(t.Qty), quantity(t.Price), money(t.Value), strings.TrimSpace(quantity(t.Fee) + " " + t.FeeAsset),
+		})
+	}
+	d.table(size, []int{16, 8, 4, 12, 14, 12, 12, 14}, []bool{false, false, false, false, true, true, true, true},
+		[]string{"Date", "Source", "Side", "Pair", "Qty", "Price", "Value", "Fee"}, rows)
+	return d.bytes()
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/enzosv/binalysis/model"
+)
+
+// TaxHandler serves disposals in ?year= by ?rule= as json, or csv with ?format=csv.
+// Gains are in the rule's currency unless ?currency= is given
+func TaxHandler(store string, mappings []CoinMapping, self string, verbose bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Content-Type", "application/json")
+		q := r.URL.Query()
+		name := q.Get("rule")
+		if name == "" {
+			name = "us-fifo"
+		}
+		rule, err := model.ParseTaxRule(name)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		year := time.Now().Year()
+		if y := q.Get("year"); y != "" {
+			year, err = strconv.Atoi(y)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": "year must be a number"})
+				return
+			}
+		}
+		if q.Get("currency") == "" {
+			q.Set("currency", rule.Currency())
+			r.URL.RawQuery = q.Encode()
+		}
+		req, ok := loadReport(w, r, store, mappings)
+		if !ok {
+			return
+		}
+		report, err := model.EvaluateTax(req.client, req.payload, req.mappings, self, req.opts, rule, year)
+		if err != nil {
+			evaluationFailed(w, err)
+			return
+		}
+		if verbose {
+			fmt.Printf("%d disposals in %d by %s\n", len(report.Disposals), year, report.Rule)
+		}
+		if q.Get("format") == "csv" {
+			w.Header().Set("Content-Type", "text/csv")
+			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"tax-%s-%d.csv\"", report.Rule, year))
+			err = report.CSV(w)
+			if err != nil {
+				fmt.Println(err)
+			}
+			return
+		}
+		json.NewEncoder(w).Encode(report)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Kucoin/kucoin-go-sdk"
+	binance2 "github.com/adshao/go-binance/v2"
+	"github.com/pkg/errors"
+)
+
+// binance withdraw and deposit history can only be queried 90 days at a time
+const transferWindow = 90 * 24 * time.Hour
+
+// kucoin deposit and withdrawal history can only be queried 30 days at a time
+const kucoinTransferWindow = 30 * 24 * time.Hour
+
+// binance launch. No transfers before this
+var binanceEpoch = time.Date(2017, 7, 14, 0, 0, 0, 0, time.UTC)
+
+func fetchBinanceTransfers(ctx context.Context, client *binance2.Client, since time.Time, verbose bool) ([]Transfer, error) {
+	var transfers []Transfer
+	for start := since; start.Before(time.Now()); start = start.Add(transferWindow) {
+		end := start.Add(transferWindow)
+		withdraws, err := client.NewListWithdrawsService().
+			StartTime(start.UnixMilli()).
+			EndTime(end.UnixMilli()).
+			Do(ctx)
+		if err != nil {
+			return transfers, errors.Wrap(err, "fetching withdrawals")
+		}
+		for _, w := range withdraws {
+			// 6 is completed
+			if w.Status != 6 {
+				continue
+			}
+			amount, err := strconv.ParseFloat(w.Amount, 64)
+			if err != nil {
+				return transfers, err
+			}
+			fee, err := strconv.ParseFloat(w.TransactionFee, 64)
+			if err != nil {
+				return transfers, err
+			}
+			t, err := time.Parse("2006-01-02 15:04:05", w.ApplyTime)
+			if err != nil {
+				return transfers, err
+			}
+			transfers = append(transfers, Transfer{
+				ID:      "binance:withdraw:" + w.ID,
+				Source:  "binance",
+				Asset:   w.Coin,
+				Amount:  amount,
+				Fee:     fee,
+				Time:    t,
+				TxHash:  w.TxID,
+				Address: w.Address,
+			})
+		}
+		deposits, err := client.NewListDepositsService().
+			StartTime(start.UnixMilli()).
+			EndTime(end.UnixMilli()).
+			Do(ctx)
+		if err != nil {
+			return transfers, errors.Wrap(err, "fetching deposits")
+		}
+		for _, d := range deposits {
+			// 1 is success
+			if d.Status != 1 {
+				continue
+			}
+			amount, err := strconv.ParseFloat(d.Amount, 64)
+			if err != nil {
+				return transfers, err
+			}
+			transfers = append(transfers, Transfer{
+				ID:      fmt.Sprintf("binance:deposit:%s:%s", d.Coin, d.TxID),
+				Source:  "binance",
+				Asset:   d.Coin,
+				Amount:  amount,
+				Time:    time.UnixMilli(d.InsertTime),
+				TxHash:  d.TxID,
+				Address: d.Address,
+				Deposit: true,
+			})
+		}
+	}
+	if verbose {
+		fmt.Printf("fetched %d binance transfers since %s\n", len(transfers), since.Format("2006-01-02"))
+	}
+	return transfers, nil
+}
+
+// fetchKucoinTransfers reads completed kucoin deposits and withdrawals since the given time
+func fetchKucoinTransfers(s *kucoin.ApiService, since time.Time, verbose bool) ([]Transfer, error) {
+	var transfers []Transfer
+	for start := since; start.Before(time.Now()); start = start.Add(kucoinTransferWindow) {
+		params := map[string]string{
+			"status":  "SUCCESS",
+			"startAt": strconv.FormatInt(start.UnixMilli(), 10),
+			"endAt":   strconv.FormatInt(start.Add(kucoinTransferWindow).UnixMilli(), 10),
+		}
+		for page := int64(1); ; page++ {
+			rsp, err := s.Deposits(params, &kucoin.PaginationParam{CurrentPage: page, PageSize: 500})
+			if err != nil {
+				return transfers, errors.Wrap(err, "fetching kucoin deposits")
+			}
+			deposits := kucoin.DepositsModel{}
+			pd, err := rsp.ReadPaginationData(&deposits)
+			if err != nil {
+				return transfers, errors.Wrap(err, "fetching kucoin deposits")
+			}
+			for _, d := range deposits {
+				amount, err := strconv.ParseFloat(d.Amount, 64)
+				if err != nil {
+					return transfers, err
+				}
+				transfers = append(transfers, Transfer{
+					ID:      fmt.Sprintf("kucoin:deposit:%s:%s", d.Currency, d.WalletTxId),
+					Source:  "kucoin",
+					Asset:   d.Currency,
+					Amount:  amount,
+					Time:    time.UnixMilli(d.CreatedAt),
+					TxHash:  d.WalletTxId,
+					Address: d.Address,
+					Deposit: true,
+				})
+			}
+			if pd.TotalPage <= page {
+				break
+			}
+		}
+		for page := int64(1); ; page++ {
+			rsp, err := s.Withdrawals(params, &kucoin.PaginationParam{CurrentPage: page, PageSize: 500})
+			if err != nil {
+				return transfers, errors.Wrap(err, "fetching kucoin withdrawals")
+			}
+			withdrawals := kucoin.WithdrawalsModel{}
+			pd, err := rsp.ReadPaginationData(&withdrawals)
+			if err != nil {
+				return transfers, errors.Wrap(err, "fetching kucoin withdrawals")
+			}
+			for _, w := range withdrawals {
+				amounts, err := parseAmounts(w.Amount, w.Fee)
+				if err != nil {
+					return transfers, err
+				}
+				transfers = append(transfers, Transfer{
+					ID:      "kucoin:withdraw:" + w.Id,
+					Source:  "kucoin",
+					Asset:   w.Currency,
+					Amount:  amounts[0],
+					Fee:     amounts[1],
+					Time:    time.UnixMilli(w.CreatedAt),
+					TxHash:  w.WalletTxId,
+					Address: w.Address,
+				})
+			}
+			if pd.TotalPage <= page {
+				break
+			}
+		}
+	}
+	if verbose {
+		fmt.Printf("fetched %d kucoin transfers since %s\n", len(transfers), since.Format("2006-01-02"))
+	}
+	return transfers, nil
+}
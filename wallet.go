@@ -0,0 +1,589 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// keccak256("Transfer(address,address,uint256)")
+const transferTopic = "0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef"
+
+// Token is an ERC-20 contract to read balances and transfers of
+type Token struct {
+	Symbol   string `json:"symbol"`
+	Address  string `json:"address"`
+	Decimals int    `json:"decimals"`
+}
+
+// RPC reads wallets from an ethereum json-rpc endpoint
+type RPC struct {
+	URL    string
+	Native string
+	Tokens []Token
+	// most blocks to scan for transfers per call.
+	// Only one range is read per sync when blocks are read one by one
+	MaxScan uint64
+	// block new addresses are scanned from. The last MaxScan blocks when 0
+	StartBlock uint64
+	client     *http.Client
+}
+
+type rpcTx struct {
+	Hash  string `json:"hash"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Value string `json:"value"`
+}
+
+type rpcBlock struct {
+	Number       string  `json:"number"`
+	Timestamp    string  `json:"timestamp"`
+	Transactions []rpcTx `json:"transactions"`
+}
+
+type rpcLog struct {
+	Address         string   `json:"address"`
+	Topics          []string `json:"topics"`
+	Data            string   `json:"data"`
+	BlockNumber     string   `json:"blockNumber"`
+	TransactionHash string   `json:"transactionHash"`
+	LogIndex        string   `json:"logIndex"`
+}
+
+func NewRPC(url, native, tokensPath string, maxScan, startBlock uint64) (*RPC, error) {
+	if maxScan == 0 {
+		return nil, errors.New("at least one block must be scanned at a time")
+	}
+	rpc := &RPC{
+		URL:        url,
+		Native:     native,
+		MaxScan:    maxScan,
+		StartBlock: startBlock,
+		client:     &http.Client{Timeout: 30 * time.Second},
+	}
+	if tokensPath == "" {
+		return rpc, nil
+	}
+	content, err := ioutil.ReadFile(tokensPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading tokens")
+	}
+	err = json.Unmarshal(content, &rpc.Tokens)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding tokens")
+	}
+	return rpc, nil
+}
+
+func (c *RPC) call(method string, params []interface{}, result interface{}) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      1,
+		"method":  method,
+		"params":  params,
+	})
+	if err != nil {
+		return err
+	}
+	res, err := c.client.Post(c.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, method)
+	}
+	defer res.Body.Close()
+	var response struct {
+		Result json.RawMessage `json:"result"`
+		Error  *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&response)
+	if err != nil {
+		return errors.Wrap(err, method)
+	}
+	if response.Error != nil {
+		return fmt.Errorf("%s: %d %s", method, response.Error.Code, response.Error.Message)
+	}
+	return json.Unmarshal(response.Result, result)
+}
+
+func parseHex(value string) *big.Int {
+	n := new(big.Int)
+	value = strings.TrimPrefix(value, "0x")
+	if value == "" {
+		return n
+	}
+	n.SetString(value, 16)
+	return n
+}
+
+// toFloat scales an integer amount by decimals
+func toFloat(amount *big.Int, decimals int) float64 {
+	f := new(big.Float).SetInt(amount)
+	f.Quo(f, new(big.Float).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil)))
+	value, _ := f.Float64()
+	return value
+}
+
+// padAddress left pads an address to a 32 byte topic or argument
+func padAddress(address string) string {
+	return "0x" + strings.Repeat("0", 24) + strings.TrimPrefix(strings.ToLower(address), "0x")
+}
+
+func (c *RPC) blockNumber() (uint64, error) {
+	var result string
+	err := c.call("eth_blockNumber", nil, &result)
+	if err != nil {
+		return 0, err
+	}
+	return parseHex(result).Uint64(), nil
+}
+
+func (c *RPC) block(number uint64) (rpcBlock, error) {
+	var block rpcBlock
+	err := c.call("eth_getBlockByNumber", []interface{}{fmt.Sprintf("0x%x", number), true}, &block)
+	return block, err
+}
+
+func (c *RPC) balance(address string) (*big.Int, error) {
+	var result string
+	err := c.call("eth_getBalance", []interface{}{address, "latest"}, &result)
+	if err != nil {
+		return nil, err
+	}
+	return parseHex(result), nil
+}
+
+func (c *RPC) ethCall(to, data string) (*big.Int, error) {
+	var result string
+	err := c.call("eth_call", []interface{}{map[string]string{"to": to, "data": data}, "latest"}, &result)
+	if err != nil {
+		return nil, err
+	}
+	return parseHex(result), nil
+}
+
+func (c *RPC) tokenBalance(token Token, address string) (*big.Int, error) {
+	// balanceOf(address)
+	return c.ethCall(token.Address, "0x70a08231"+strings.TrimPrefix(padAddress(address), "0x"))
+}
+
+func (c *RPC) decimals(token Token) (int, error) {
+	if token.Decimals > 0 {
+		return token.Decimals, nil
+	}
+	// decimals()
+	d, err := c.ethCall(token.Address, "0x313ce567")
+	if err != nil {
+		return 0, err
+	}
+	return int(d.Int64()), nil
+}
+
+func (c *RPC) logs(from, to uint64, tokens []string, topics []interface{}) ([]rpcLog, error) {
+	var logs []rpcLog
+	err := c.call("eth_getLogs", []interface{}{map[string]interface{}{
+		"fromBlock": fmt.Sprintf("0x%x", from),
+		"toBlock":   fmt.Sprintf("0x%x", to),
+		"address":   tokens,
+		"topics":    topics,
+	}}, &logs)
+	return logs, err
+}
+
+type rpcTrace struct {
+	Action struct {
+		CallType string `json:"callType"`
+		From     string `json:"from"`
+		To       string `json:"to"`
+		Value    string `json:"value"`
+	} `json:"action"`
+	BlockNumber     uint64 `json:"blockNumber"`
+	TransactionHash string `json:"transactionHash"`
+	TraceAddress    []int  `json:"traceAddress"`
+	Type            string `json:"type"`
+	Error           string `json:"error"`
+}
+
+// position is where a call is within its transaction. e.g. trace-0-1
+func (t rpcTrace) position() string {
+	position := "trace"
+	for _, i := range t.TraceAddress {
+		position += fmt.Sprintf("-%d", i)
+	}
+	return position
+}
+
+// traces are calls from or to the addresses. field is fromAddress or toAddress.
+// Only nodes with the trace api, like erigon and nethermind, have them
+func (c *RPC) traces(from, to uint64, field string, addresses []string) ([]rpcTrace, error) {
+	var traces []rpcTrace
+	err := c.call("trace_filter", []interface{}{map[string]interface{}{
+		"fromBlock": fmt.Sprintf("0x%x", from),
+		"toBlock":   fmt.Sprintf("0x%x", to),
+		field:       addresses,
+	}}, &traces)
+	return traces, err
+}
+
+// blockTime is when a block was mined. Cached in timestamps
+func (c *RPC) blockTime(timestamps map[uint64]time.Time, number uint64) (time.Time, error) {
+	if t, ok := timestamps[number]; ok {
+		return t, nil
+	}
+	var header struct {
+		Timestamp string `json:"timestamp"`
+	}
+	err := c.call("eth_getBlockByNumber", []interface{}{fmt.Sprintf("0x%x", number), false}, &header)
+	if err != nil {
+		return time.Time{}, err
+	}
+	timestamps[number] = time.Unix(parseHex(header.Timestamp).Int64(), 0)
+	return timestamps[number], nil
+}
+
+// sync reads balances of the payload's addresses
+// and transfers in blocks since the last sync
+func (c *RPC) sync(p *Payload, verbose bool) error {
+	if len(p.Addresses) < 1 {
+		return nil
+	}
+	latest, err := c.blockNumber()
+	if err != nil {
+		return err
+	}
+	own := map[string]bool{}
+	var padded []string
+	for _, a := range p.Addresses {
+		own[strings.ToLower(a)] = true
+		padded = append(padded, padAddress(a))
+	}
+
+	// zero out balances. Pairs are transfers carried in from other sources
+	wallet := map[string]Asset{}
+	for k, a := range p.Wallet {
+		a.Balance = 0
+		wallet[k] = a
+	}
+	decimals := map[string]int{}
+	symbols := map[string]string{}
+	var contracts []string
+	for _, a := range p.Addresses {
+		bal, err := c.balance(a)
+		if err != nil {
+			return err
+		}
+		native := wallet[c.Native]
+		native.Balance += toFloat(bal, 18)
+		wallet[c.Native] = native
+		for _, token := range c.Tokens {
+			d, err := c.decimals(token)
+			if err != nil {
+				return errors.Wrap(err, token.Symbol)
+			}
+			bal, err := c.tokenBalance(token, a)
+			if err != nil {
+				return errors.Wrap(err, token.Symbol)
+			}
+			asset := wallet[token.Symbol]
+			asset.Balance += toFloat(bal, d)
+			wallet[token.Symbol] = asset
+			address := strings.ToLower(token.Address)
+			if _, ok := decimals[address]; !ok {
+				decimals[address] = d
+				symbols[address] = token.Symbol
+				contracts = append(contracts, token.Address)
+			}
+		}
+	}
+	p.Wallet = wallet
+
+	start := p.WalletBlock + 1
+	if p.WalletBlock == 0 {
+		start = p.WalletStart
+		if start == 0 {
+			start = c.StartBlock
+		}
+		if start == 0 && latest > c.MaxScan {
+			start = latest - c.MaxScan
+		}
+	}
+	scan := walletScan{
+		own:        own,
+		addresses:  p.Addresses,
+		padded:     padded,
+		contracts:  contracts,
+		decimals:   decimals,
+		symbols:    symbols,
+		timestamps: map[uint64]time.Time{},
+	}
+	var transfers []Transfer
+	var scanErr error
+	for start <= latest {
+		end := latest
+		if end-start >= c.MaxScan {
+			end = start + c.MaxScan - 1
+		}
+		found, traced, err := c.scan(scan, start, end, verbose)
+		if err != nil {
+			scanErr = err
+			break
+		}
+		transfers = append(transfers, found...)
+		p.WalletBlock = end
+		start = end + 1
+		if !traced {
+			// reading every block is slow. Catch up on the next sync
+			break
+		}
+	}
+
+	added := p.RecordTransfers(transfers)
+	if verbose {
+		fmt.Printf("wallet synced to block %d. %d new transfers\n", p.WalletBlock, added)
+	}
+	return scanErr
+}
+
+// walletScan is what a sync looks for in each range of blocks
+type walletScan struct {
+	own       map[string]bool
+	addresses []string
+	// addresses as log topics
+	padded    []string
+	contracts []string
+	// by lowercase contract address
+	decimals   map[string]int
+	symbols    map[string]string
+	timestamps map[uint64]time.Time
+}
+
+// scan reads native and token transfers of the addresses from block start to end.
+// Native transfers come from the node's traces, which include calls from contracts,
+// or from every block's transactions when it has none
+func (c *RPC) scan(w walletScan, start, end uint64, verbose bool) ([]Transfer, bool, error) {
+	transfers, err := c.tracedTransfers(w, start, end)
+	traced := err == nil
+	if err != nil {
+		if verbose {
+			fmt.Printf("no traces, reading blocks %d to %d: %v\n", start, end, err)
+		}
+		transfers, err = c.blockTransfers(w, start, end)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+	if len(w.contracts) < 1 {
+		return transfers, traced, nil
+	}
+	incoming, err := c.logs(start, end, w.contracts, []interface{}{transferTopic, nil, w.padded})
+	if err != nil {
+		return nil, false, err
+	}
+	outgoing, err := c.logs(start, end, w.contracts, []interface{}{transferTopic, w.padded})
+	if err != nil {
+		return nil, false, err
+	}
+	for i, l := range append(incoming, outgoing...) {
+		if len(l.Topics) < 3 {
+			continue
+		}
+		deposit := i < len(incoming)
+		address := "0x" + l.Topics[1][26:]
+		direction := "out"
+		if deposit {
+			address = "0x" + l.Topics[2][26:]
+			direction = "in"
+		}
+		at, err := c.blockTime(w.timestamps, parseHex(l.BlockNumber).Uint64())
+		if err != nil {
+			return nil, false, err
+		}
+		contract := strings.ToLower(l.Address)
+		transfers = append(transfers, Transfer{
+			ID:      fmt.Sprintf("wallet:%s:%s:%s", l.TransactionHash, l.LogIndex, direction),
+			Source:  "wallet",
+			Asset:   w.symbols[contract],
+			Amount:  toFloat(parseHex(l.Data), w.decimals[contract]),
+			Time:    at,
+			TxHash:  l.TransactionHash,
+			Address: address,
+			Deposit: deposit,
+		})
+	}
+	return transfers, traced, nil
+}
+
+// blockTransfers reads native transfers from every block's transactions
+func (c *RPC) blockTransfers(w walletScan, start, end uint64) ([]Transfer, error) {
+	var transfers []Transfer
+	for n := start; n <= end; n++ {
+		block, err := c.block(n)
+		if err != nil {
+			return nil, err
+		}
+		w.timestamps[n] = time.Unix(parseHex(block.Timestamp).Int64(), 0)
+		for _, tx := range block.Transactions {
+			value := parseHex(tx.Value)
+			if value.Sign() == 0 {
+				continue
+			}
+			transfers = append(transfers, c.nativeTransfers(w, tx.Hash, "", tx.From, tx.To, value, w.timestamps[n])...)
+		}
+	}
+	return transfers, nil
+}
+
+// tracedTransfers reads native transfers from the node's call traces.
+// Calls within a reverted call moved nothing
+func (c *RPC) tracedTransfers(w walletScan, start, end uint64) ([]Transfer, error) {
+	from, err := c.traces(start, end, "fromAddress", w.addresses)
+	if err != nil {
+		return nil, err
+	}
+	to, err := c.traces(start, end, "toAddress", w.addresses)
+	if err != nil {
+		return nil, err
+	}
+	reverted := map[string]bool{}
+	for _, t := range append(from, to...) {
+		if t.Error != "" {
+			reverted[t.TransactionHash+":"+t.position()] = true
+		}
+	}
+	seen := map[string]bool{}
+	var transfers []Transfer
+	for _, t := range append(from, to...) {
+		key := t.TransactionHash + ":" + t.position()
+		value := parseHex(t.Action.Value)
+		if seen[key] || t.Type != "call" || t.Action.CallType == "delegatecall" || t.Action.CallType == "staticcall" || value.Sign() == 0 {
+			continue
+		}
+		seen[key] = true
+		failed := false
+		for i := 0; i <= len(t.TraceAddress); i++ {
+			failed = failed || reverted[t.TransactionHash+":"+rpcTrace{TraceAddress: t.TraceAddress[:i]}.position()]
+		}
+		if failed {
+			continue
+		}
+		at, err := c.blockTime(w.timestamps, t.BlockNumber)
+		if err != nil {
+			return nil, err
+		}
+		transfers = append(transfers, c.nativeTransfers(w, t.TransactionHash, t.position(), t.Action.From, t.Action.To, value, at)...)
+	}
+	return transfers, nil
+}
+
+// nativeTransfers are a value transfer into and out of the addresses.
+// position is empty for a transaction itself and the trace's place for a call within one
+func (c *RPC) nativeTransfers(w walletScan, hash, position, from, to string, value *big.Int, at time.Time) []Transfer {
+	var transfers []Transfer
+	id := func(address, direction string) string {
+		if position == "" {
+			return fmt.Sprintf("wallet:%s:%s:%s", hash, strings.ToLower(address), direction)
+		}
+		return fmt.Sprintf("wallet:%s:%s:%s:%s", hash, position, strings.ToLower(address), direction)
+	}
+	amount := toFloat(value, 18)
+	if w.own[strings.ToLower(to)] {
+		transfers = append(transfers, Transfer{
+			ID:      id(to, "in"),
+			Source:  "wallet",
+			Asset:   c.Native,
+			Amount:  amount,
+			Time:    at,
+			TxHash:  hash,
+			Address: to,
+			Deposit: true,
+		})
+	}
+	if w.own[strings.ToLower(from)] {
+		transfers = append(transfers, Transfer{
+			ID:      id(from, "out"),
+			Source:  "wallet",
+			Asset:   c.Native,
+			Amount:  amount,
+			Time:    at,
+			TxHash:  hash,
+			Address: from,
+		})
+	}
+	return transfers
+}
+
+// addressPattern is an evm address
+var addressPattern = regexp.MustCompile(`^0x[0-9a-fA-F]{40}$`)
+
+func WalletsHandler(store string, verbose bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Content-Type", "application/json")
+		key := r.Header.Get("X-API-Key")
+		if key == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "X-API-Key is required"})
+			return
+		}
+		path := fmt.Sprintf("%s/%s.json", store, key)
+		defer lockFile(path)()
+		payload := loadExisting(path)
+		if r.Method == http.MethodPost {
+			var body struct {
+				Addresses []string `json:"addresses"`
+				// block the addresses were first used in
+				StartBlock *uint64 `json:"start_block"`
+			}
+			err := json.NewDecoder(r.Body).Decode(&body)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+				return
+			}
+			addresses := []string{}
+			for _, a := range body.Addresses {
+				a = strings.ToLower(strings.TrimSpace(a))
+				if !addressPattern.MatchString(a) {
+					w.WriteHeader(http.StatusBadRequest)
+					json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("invalid address %s", a)})
+					return
+				}
+				addresses = append(addresses, a)
+			}
+			if strings.Join(addresses, ",") != strings.Join(payload.Addresses, ",") {
+				// rescan for the new addresses
+				payload.WalletBlock = 0
+			}
+			if body.StartBlock != nil && *body.StartBlock != payload.WalletStart {
+				payload.WalletStart = *body.StartBlock
+				payload.WalletBlock = 0
+			}
+			payload.Addresses = addresses
+			err = payload.Persist(path)
+			if err != nil {
+				fmt.Println(err)
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+				return
+			}
+			if verbose {
+				fmt.Printf("tracking %d addresses\n", len(addresses))
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"addresses":   payload.Addresses,
+			"start_block": payload.WalletStart,
+			"wallet":      payload.Wallet,
+		})
+	}
+}
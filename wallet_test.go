@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const (
+	walletAddress = "0x00000000000000000000000000000000000000aa"
+	otherAddress  = "0x00000000000000000000000000000000000000bb"
+)
+
+// fakeNode answers json-rpc calls for a chain of 20 blocks with traces in block 5
+func fakeNode(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Method string            `json:"method"`
+			Params []json.RawMessage `json:"params"`
+		}
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		var result interface{}
+		switch req.Method {
+		case "eth_blockNumber":
+			result = "0x14"
+		case "eth_getBalance":
+			result = "0xde0b6b3a7640000"
+		case "eth_getBlockByNumber":
+			result = map[string]string{"timestamp": "0x64000000"}
+		case "trace_filter":
+			var filter map[string]interface{}
+			json.Unmarshal(req.Params[0], &filter)
+			from, _ := strconv.ParseUint(strings.TrimPrefix(filter["fromBlock"].(string), "0x"), 16, 64)
+			to, _ := strconv.ParseUint(strings.TrimPrefix(filter["toBlock"].(string), "0x"), 16, 64)
+			traces := []map[string]interface{}{}
+			if from <= 5 && 5 <= to {
+				call := func(hash, from, to, value string, traceAddress []int, failed string) map[string]interface{} {
+					return map[string]interface{}{
+						"action":          map[string]string{"callType": "call", "from": from, "to": to, "value": value},
+						"blockNumber":     5,
+						"transactionHash": hash,
+						"traceAddress":    traceAddress,
+						"type":            "call",
+						"error":           failed,
+					}
+				}
+				if _, ok := filter["fromAddress"]; ok {
+					traces = append(traces, call("0x1", walletAddress, otherAddress, "0xde0b6b3a7640000", []int{}, ""))
+				} else {
+					// paid out by a contract, and a payout within a reverted call
+					traces = append(traces,
+						call("0x2", otherAddress, walletAddress, "0x6f05b59d3b20000", []int{0}, ""),
+						call("0x3", otherAddress, otherAddress, "0x0", []int{0}, "Reverted"),
+						call("0x3", otherAddress, walletAddress, "0x6f05b59d3b20000", []int{0, 1}, ""))
+				}
+			}
+			result = traces
+		default:
+			t.Errorf("unexpected call to %s", req.Method)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": 1, "result": result})
+	}))
+}
+
+func TestWalletSync(t *testing.T) {
+	if _, err := NewRPC("http://localhost", "ETH", "", 0, 0); err == nil {
+		t.Error("scanning 0 blocks at a time was allowed")
+	}
+	node := fakeNode(t)
+	defer node.Close()
+	rpc, err := NewRPC(node.URL, "ETH", "", 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := Payload{Addresses: []string{walletAddress}, WalletStart: 1}
+	for i := 0; i < 2; i++ {
+		err = rpc.sync(&p, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if p.WalletBlock != 20 {
+		t.Errorf("synced to block %d, want 20", p.WalletBlock)
+	}
+	if p.Wallet["ETH"].Balance != 1 {
+		t.Errorf("got a balance of %g ETH, want 1", p.Wallet["ETH"].Balance)
+	}
+	if len(p.Transfers) != 2 {
+		t.Fatalf("got %d transfers, want the withdrawal and the contract's payout", len(p.Transfers))
+	}
+	for _, transfer := range p.Transfers {
+		switch {
+		case transfer.Deposit && transfer.TxHash == "0x2" && transfer.Amount == 0.5:
+		case !transfer.Deposit && transfer.TxHash == "0x1" && transfer.Amount == 1:
+		default:
+			t.Errorf("unexpected transfer %+v", transfer)
+		}
+	}
+}
@@ -17,6 +17,15 @@ const wasmBrowserInstantiate = async (wasmModuleUrl, importObject) => {
 };
 
 const go = new Go();
+var manualTransactions = []
+var reportCurrency = "USD"
+
+function moneyFormat() {
+    return new Intl.NumberFormat(`en-US`, {
+        currency: reportCurrency,
+        style: 'currency',
+    })
+}
 
 const runWasmAdd = async () => {
     const importObject = go.importObject;
@@ -41,6 +50,9 @@ function setup() {
         var urlParams = new URLSearchParams(window.location.search)
         if (urlParams.has('key')) {
             document.getElementById("key").value = urlParams.get('key')
+            if (urlParams.has('currency')) {
+                document.getElementById("currency").value = urlParams.get('currency')
+            }
             let key = urlParams.get('key')
             refresh(key, true)
         }
@@ -59,6 +71,12 @@ function setup() {
             var table = $('#main').DataTable()
             table.search(this.value).draw();
         });
+        $('#currency').on('change', function () {
+            let key = document.getElementById("key").value
+            if (key != "") {
+                refresh(key, false)
+            }
+        });
         $('#hide-small').on('change', function () {
             if (!$.fn.dataTable.isDataTable('#main')) {
                 return
@@ -72,7 +90,11 @@ function setup() {
 }
 
 async function refresh(key, is_updating) {
-    window.history.replaceState(null, null, window.origin + "?key=" + document.getElementById("key").value);
+    let currency = document.getElementById("currency").value
+    let params = new URLSearchParams(window.location.search)
+    params.set("key", document.getElementById("key").value)
+    params.set("currency", currency)
+    window.history.replaceState(null, null, window.origin + "?" + params.toString());
     let btn = document.getElementById("refresh-btn")
     btn.disabled = true
     let status = document.getElementById("status")
@@ -80,9 +102,16 @@ async function refresh(key, is_updating) {
     status.className = "text-light"
     var balanceResponse
     try {
-        let request = gorefresh(key, window.location.origin + "/latest", is_updating)
+        // e.g. ?prices=binance,coingecko to prefer binance tickers
+        let prices = new URLSearchParams(window.location.search).get('prices') || ""
+        let request = gorefresh(key, window.location.origin + "/latest", is_updating, currency, prices)
         balanceResponse = await request
+        reportCurrency = balanceResponse.currency.toUpperCase()
+        manualTransactions = balanceResponse.manual || []
         populateTable(balanceResponse.binance)
+        populateSummary(balanceResponse.summary)
+        loadHistory(key, currency)
+        loadRisk(key, currency)
         generateDownloadable(balanceResponse)
         status.className = "text-light"
         status.innerHTML = "Last updated: " + new Date(balanceResponse.last_update).toLocaleDateString('en-us', { year: "numeric", month: "short", day: "numeric", hour: "numeric", minute: "numeric" })
@@ -104,6 +133,29 @@ function generateDownloadable(balance) {
     dlAnchorElem.setAttribute("href", dataStr);
     dlAnchorElem.setAttribute("download", "data.json");
     dlAnchorElem.innerHTML = "My data"
+    let statement = document.getElementById('statement')
+    statement.innerHTML = `Statement
+        <a href="#" data-format="html">html</a>,
+        <a href="#" data-format="pdf">pdf</a>`
+    statement.querySelectorAll('a').forEach(a => a.onclick = function (e) {
+        e.preventDefault()
+        openStatement(a.dataset.format, balance.currency || "")
+    })
+}
+
+// openStatement sends the key as a header so it stays out of urls and browser history
+async function openStatement(format, currency) {
+    // opened before awaiting so it is not blocked as a popup
+    let tab = window.open("", "_blank")
+    let response = await fetch('/statement?' + new URLSearchParams({ format: format, currency: currency }), {
+        headers: { 'X-API-Key': document.getElementById("key").value },
+    })
+    if (!response.ok) {
+        tab.close()
+        console.error((await response.json()).error)
+        return
+    }
+    tab.location = URL.createObjectURL(await response.blob())
 }
 
 function populateTable(binance) {
@@ -114,11 +166,7 @@ function populateTable(binance) {
         table.draw();
         return
     }
-    const usd_format = new Intl.NumberFormat(`en-US`, {
-        currency: `USD`,
-        style: 'currency',
-    })
-    $("#main").DataTable({
+        $("#main").DataTable({
         data: binance,
         fixedHeader: true,
         paging: false,
@@ -135,7 +183,7 @@ function populateTable(binance) {
                 data: "average_buy",
                 render: function (data, type, row) {
                     if (type === 'display') {
-                        return (row.buy_qty <= 0) ? "" : usd_format.format(data)
+                        return (row.buy_qty <= 0) ? "" : moneyFormat().format(data)
                     }
                     return data
                 },
@@ -145,19 +193,19 @@ function populateTable(binance) {
                 data: "average_sell",
                 render: function (data, type, row) {
                     if (type === 'display') {
-                        return (row.sell_qty <= 0) ? "" : usd_format.format(data)
+                        return (row.sell_qty <= 0) ? "" : moneyFormat().format(data)
                     }
                     return data
                 }
             },
             {
-                data: "coin.usd_24h_change",
+                data: "coin.change_24h",
                 render: function (data, type, row) {
                     if (type === 'display') {
                         let change = data
                         let change_color = (change > 0) ? "text-success" : "text-danger"
-                        return `${(row.coin.usd <= 0) ? "" : usd_format.format(row.coin.usd)}
-                        <small class='${change_color}'>${(row.coin.usd <= 0) ? "" : "(" + change.toFixed(2) + "%)"}</small>`
+                        return `${(row.coin.price <= 0) ? "" : moneyFormat().format(row.coin.price)}
+                        <small class='${change_color}'>${(row.coin.price <= 0) ? "" : "(" + change.toFixed(2) + "%)"}</small>`
                     }
                     return data
 
@@ -170,7 +218,7 @@ function populateTable(binance) {
                     if (type === 'display') {
                         let dif_color = (row.dif > 0) ? "text-success" : "text-danger"
                         return `<div class=${dif_color}>
-                        ${(row.buy_qty <= 0 || row.coin.usd <= 0) ? "" : usd_format.format(row.dif)} 
+                        ${(row.buy_qty <= 0 || row.coin.price <= 0) ? "" : moneyFormat().format(row.dif)} 
                         <small>${(row.dif == 0) ? "" : "(" + data.toFixed(2) + "%)"}</small>
                         </div>`
                     }
@@ -181,6 +229,178 @@ function populateTable(binance) {
     })
 }
 
+function populateSummary(summary) {
+    const money = moneyFormat()
+    let profit_color = (summary.profit > 0) ? "text-success" : "text-danger"
+    let futures = summary.futures
+    let positions = (futures.positions || []).map(p => `<tr>
+        <td>${p.symbol}</td>
+        <td>${p.side} ${p.amount} x${p.leverage}</td>
+        <td>${p.entry_price} → ${p.mark_price}</td>
+        <td class="${p.unrealized_pnl > 0 ? "text-success" : "text-danger"}">${p.unrealized_pnl} ${p.asset}</td>
+    </tr>`).join("")
+    let unmatched = (summary.unmatched || []).map(t => `<tr>
+        <td>${new Date(t.time).toLocaleDateString()}</td>
+        <td>${t.source}</td>
+        <td>${t.deposit ? "deposit" : "withdrawal"}</td>
+        <td>${t.amount} ${t.asset}</td>
+    </tr>`).join("")
+    let ambiguous = (summary.ambiguous || []).map(c => `<tr>
+        <td>${c.symbol.toUpperCase()}</td>
+        <td>${c.id}</td>
+        <td>${c.ambiguous.map(id => `<a href="#" onclick="pinCoin('${c.symbol}', '${id}'); return false">${id}</a>`).join(", ")}</td>
+    </tr>`).join("")
+    let exchanges = Object.keys(summary.exchanges || {}).sort().map(name => {
+        let e = summary.exchanges[name]
+        return `${name} <label class="${e.profit > 0 ? "text-success" : "text-danger"}">${money.format(e.profit)}</label>
+        <small class="text-muted">holding ${money.format(e.value)}</small>`
+    }).join(", ")
+    let hasFutures = positions != "" || futures.realized_pnl != 0 || futures.funding != 0
+    document.getElementById("summary").innerHTML = `
+        Profit: <label class="${profit_color}">${money.format(summary.profit)}</label>
+        <small class="text-muted">cost ${money.format(summary.cost)}, revenue ${money.format(summary.revenue)}, fees ${money.format(summary.fees)}</small>
+        <br>${exchanges}
+        ${!hasFutures ? "" : `<br>
+        Futures: <label class="${futures.profit > 0 ? "text-success" : "text-danger"}">${money.format(futures.profit)}</label>
+        <small class="text-muted">realized ${money.format(futures.realized_pnl)}, funding ${money.format(futures.funding)},
+        commission ${money.format(futures.commission)}, unrealized ${money.format(futures.unrealized_pnl)}</small>
+        <table class="table table-sm table-dark"><tbody>${positions}</tbody></table>`}
+        ${unmatched == "" ? "" : `<br>
+        <details><summary class="text-warning">${summary.unmatched.length} unmatched transfers</summary>
+        <small class="text-muted">Deposits with no matching withdrawal have no cost basis</small>
+        <table class="table table-sm table-dark"><tbody>${unmatched}</tbody></table></details>`}
+        ${ambiguous == "" ? "" : `<br>
+        <details><summary class="text-warning">${summary.ambiguous.length} ambiguous coins</summary>
+        <small class="text-muted">Symbols listed under several coins. Pin the right one with a mapping</small>
+        <table class="table table-sm table-dark"><tbody>${ambiguous}</tbody></table></details>`}
+    `
+}
+
+async function pinCoin(symbol, coinID) {
+    let response = await fetch('/mappings', {
+        method: 'POST',
+        headers: { 'X-API-Key': document.getElementById("key").value },
+        body: JSON.stringify({ symbol: symbol, coin_id: coinID }),
+    })
+    if (!response.ok) {
+        console.error((await response.json()).error)
+        return
+    }
+    refresh(document.getElementById("key").value, true)
+}
+
+async function loadRisk(key, currency) {
+    try {
+        let response = await fetch('/risk?currency=' + currency, {
+            headers: { 'X-API-Key': key },
+        })
+        let data = await response.json()
+        if (!response.ok) {
+            throw data.error
+        }
+        let risk = data.risk
+        if (risk.days < 2) {
+            return
+        }
+        let percent = v => (v * 100).toFixed(1) + "%"
+        let concentration = risk.concentration
+        let top = (concentration.top || []).map(s => `${s.symbol} ${percent(s.share)}`).join(", ")
+        document.getElementById("summary").insertAdjacentHTML("beforeend", `<br>
+            Risk: <small class="text-muted">max drawdown</small> <label class="text-danger">${percent(risk.drawdown.max)}</label>
+            <small class="text-muted">${risk.drawdown.peak} → ${risk.drawdown.trough},
+            volatility</small> ${percent(risk.volatility)}
+            <small class="text-muted">sharpe</small> ${risk.sharpe.toFixed(2)}
+            <small class="text-muted">sortino</small> ${risk.sortino.toFixed(2)}
+            ${top == "" ? "" : `<small class="text-muted">top holdings</small> ${top}
+            <small class="text-muted">herfindahl</small> ${concentration.herfindahl.toFixed(2)}`}`)
+    } catch (err) {
+        console.error(err)
+    }
+}
+
+async function loadHistory(key, currency) {
+    let container = document.getElementById("history")
+    try {
+        let response = await fetch('/history?currency=' + currency, {
+            headers: { 'X-API-Key': key },
+        })
+        let history = await response.json()
+        if (!response.ok) {
+            throw history.error
+        }
+        drawHistory(container, history.snapshots || [])
+        loadReturns(key, currency)
+        loadBenchmark(key, currency)
+    } catch (err) {
+        console.error(err)
+        container.innerHTML = ""
+    }
+}
+
+async function loadReturns(key, currency) {
+    try {
+        let response = await fetch('/returns?currency=' + currency, {
+            headers: { 'X-API-Key': key },
+        })
+        let data = await response.json()
+        if (!response.ok) {
+            throw data.error
+        }
+        let percent = v => v == null ? "n/a" : `<label class="${v > 0 ? "text-success" : "text-danger"}">${(v * 100).toFixed(2)}%</label>`
+        let names = { ytd: "YTD", "1y": "1Y", all: "All time" }
+        let returns = data.returns.map(r => `${names[r.period] || r.period}
+            <small class="text-muted">TWR</small> ${percent(r.total.twr)}
+            <small class="text-muted">XIRR</small> ${percent(r.total.xirr)}`).join(", ")
+        document.getElementById("history").insertAdjacentHTML("beforeend", `<small>${returns}</small>`)
+    } catch (err) {
+        console.error(err)
+    }
+}
+
+async function loadBenchmark(key, currency) {
+    try {
+        let response = await fetch('/benchmark?benchmark=btc&currency=' + currency, {
+            headers: { 'X-API-Key': key },
+        })
+        let data = await response.json()
+        if (!response.ok) {
+            throw data.error
+        }
+        const money = moneyFormat()
+        let total = data.comparison.total
+        document.getElementById("history").insertAdjacentHTML("beforeend", `<br><small>Holding BTC instead
+            <label class="text-muted">${money.format(total.benchmark)}</label>,
+            difference <label class="${total.difference > 0 ? "text-success" : "text-danger"}">${money.format(total.difference)}</label></small>`)
+    } catch (err) {
+        console.error(err)
+    }
+}
+
+// drawHistory plots portfolio value against invested capital
+function drawHistory(container, snapshots) {
+    if (snapshots.length < 2) {
+        container.innerHTML = ""
+        return
+    }
+    const money = moneyFormat()
+    const width = 1000, height = 200
+    let max = Math.max(...snapshots.map(s => Math.max(s.value, s.invested)), 1)
+    let x = i => (i * width / (snapshots.length - 1)).toFixed(1)
+    let y = v => (height - v * height / max).toFixed(1)
+    let line = key => snapshots.map((s, i) => `${x(i)},${y(s[key])}`).join(" ")
+    let last = snapshots[snapshots.length - 1]
+    container.innerHTML = `
+        <small>Value <label class="text-info">${money.format(last.value)}</label>,
+        invested <label class="text-muted">${money.format(last.invested)}</label>,
+        realized <label class="${last.realized > 0 ? "text-success" : "text-danger"}">${money.format(last.realized)}</label>,
+        unrealized <label class="${last.unrealized > 0 ? "text-success" : "text-danger"}">${money.format(last.unrealized)}</label>
+        <span class="text-muted">since ${snapshots[0].date}</span></small>
+        <svg viewBox="0 0 ${width} ${height}" preserveAspectRatio="none" style="width: 100%; height: 120px">
+            <polyline points="${line("invested")}" fill="none" stroke="#6c757d" stroke-width="2" vector-effect="non-scaling-stroke" />
+            <polyline points="${line("value")}" fill="none" stroke="#0dcaf0" stroke-width="2" vector-effect="non-scaling-stroke" />
+        </svg>`
+}
+
 async function update() {
     let status = document.getElementById("status")
     status.className = "text-light"
@@ -237,38 +457,182 @@ function presentModal(row) {
     var table = $('#main').DataTable()
     let asset = table.row(row).data();
     let profit_color = (asset.profit > 0) ? "text-success" : "text-danger"
-    let change_color = (asset.coin.usd_24h_change > 0) ? "text-success" : "text-danger"
+    let change_color = (asset.coin.change_24h > 0) ? "text-success" : "text-danger"
     let dif_color = (asset.dif > 0) ? "text-success" : "text-danger"
 
-    let usd_format = new Intl.NumberFormat(`en-US`, {
-        currency: `USD`,
-        style: 'currency',
-    })
+    let money = moneyFormat()
 
 
     $("#exampleModal").modal("show");
     $("#modal-header").html(`<a href="https://www.coingecko.com/en/coins/${asset.coin.id}">${asset.symbol.toUpperCase()}</a>`)
     $("#modal-body").html(`
         <p>
-        Average Buy: ${(asset.buy_qty <= 0) ? "Unbought" : usd_format.format(asset.average_buy)}<br>
-        Average Sell: ${(asset.sell_qty <= 0) ? "Unsold" : usd_format.format(asset.average_sell)}<br>
-        Price: ${asset.coin.usd} <label class="${change_color}">(${asset.coin.usd_24h_change.toFixed(2)})</label><br>
-        Current - Buy: <label class="${dif_color}">${usd_format.format(asset.dif)} <small>(${asset.percent_dif.toFixed(2)}%)</label><br>
+        Average Buy: ${(asset.buy_qty <= 0) ? "Unbought" : money.format(asset.average_buy)}<br>
+        Average Sell: ${(asset.sell_qty <= 0) ? "Unsold" : money.format(asset.average_sell)}<br>
+        Price: ${asset.coin.price} <label class="${change_color}">(${asset.coin.change_24h.toFixed(2)})</label> <small class="text-muted">${asset.coin.source}</small><br>
+        Current - Buy: <label class="${dif_color}">${money.format(asset.dif)} <small>(${asset.percent_dif.toFixed(2)}%)</label><br>
         <br>
-        Balance: ${asset.balance} (${usd_format.format(asset.balance * asset.coin.usd)})<br>
+        Balance: ${asset.balance} (${money.format(asset.balance * asset.coin.price)})<br>
+        ${(asset.earn.flexible + asset.earn.locked <= 0) ? "" : `&nbsp; Earn: ${asset.earn.flexible} flexible, ${asset.earn.locked} locked<br>
+        &nbsp; Earn rewards: ${asset.earn.rewards} <small class="text-muted">${asset.earn.redeemed} redeemed</small><br>`}
+        ${(asset.borrowed <= 0 && asset.interest <= 0) ? "" : `&nbsp; Margin borrowed: ${asset.borrowed} <small class="text-muted">${money.format(asset.interest)} interest${asset.liquidations > 0 ? ", " + asset.liquidations + " liquidations" : ""}</small><br>`}
         <small class="text-muted">May be inaccurate</small><br>
-        Cost: ${usd_format.format(asset.cost)}<br>
-        Revenue: ${usd_format.format(asset.revenue)}<br>
+        Cost: ${money.format(asset.cost)}<br>
+        Revenue: ${money.format(asset.revenue)}<br>
         <br>
-        Profit: <label class="${profit_color}">${usd_format.format(asset.profit)}</label><br>
-        <small class="text-muted">${usd_format.format(asset.revenue)}+${usd_format.format(asset.balance * asset.coin.usd)}-${usd_format.format(asset.cost)}</small><br>
+        Profit: <label class="${profit_color}">${money.format(asset.profit)}</label><br>
+        <small class="text-muted">${money.format(asset.revenue)}+${money.format(asset.balance * asset.coin.price)}-${money.format(asset.cost)}</small><br>
         First trade: <br>
-        &nbsp; ${asset.earliest_trade.IsBuyer ? "Bought" : "Sold"} ${asset.earliest_trade.Qty} ${asset.symbol} for ${usd_format.format(asset.earliest_trade.Price * asset.earliest_trade.Qty)} 
-        at ${usd_format.format(asset.earliest_trade.Price)}
+        &nbsp; ${asset.earliest_trade.IsBuyer ? "Bought" : "Sold"} ${asset.earliest_trade.Qty} ${asset.symbol} for ${money.format(asset.earliest_trade.Price * asset.earliest_trade.Qty)} 
+        at ${money.format(asset.earliest_trade.Price)}
         on ${new Date(asset.earliest_trade.Time).toLocaleDateString('en-us', { year: "numeric", month: "short", day: "numeric" })}<br>
         Last trade: <br>
-        &nbsp; ${asset.latest_trade.IsBuyer ? "Bought" : "Sold"} ${asset.latest_trade.Qty} ${asset.symbol} for ${usd_format.format(asset.latest_trade.Price * asset.latest_trade.Qty)} 
-        at ${usd_format.format(asset.latest_trade.Price)}
+        &nbsp; ${asset.latest_trade.IsBuyer ? "Bought" : "Sold"} ${asset.latest_trade.Qty} ${asset.symbol} for ${money.format(asset.latest_trade.Price * asset.latest_trade.Qty)} 
+        at ${money.format(asset.latest_trade.Price)}
         on ${new Date(asset.latest_trade.Time).toLocaleDateString('en-us', { year: "numeric", month: "short", day: "numeric" })}<br>
+        ${pathsSection(asset.paths)}
+        ${sourcesSection(asset.sources, money)}
+        ${manualSection(asset.symbol)}
     `)
+}
+
+function pathsSection(paths) {
+    let quotes = Object.keys(paths || {}).sort()
+    if (quotes.length < 1) {
+        return ""
+    }
+    return `<small class="text-muted">Valued at the time of each trade through ${quotes.map(q => paths[q]).join(", ")}</small><br>`
+}
+
+function sourcesSection(sources, money) {
+    let names = Object.keys(sources || {}).sort()
+    if (names.length < 2) {
+        return ""
+    }
+    let rows = names.map(name => {
+        let s = sources[name]
+        return `<tr>
+            <td>${name}</td>
+            <td>${s.balance} <small class="text-muted">${money.format(s.value)}</small></td>
+            <td>${money.format(s.cost)}</td>
+            <td>${money.format(s.revenue)}</td>
+            <td>${money.format(s.fees)}</td>
+            <td class="${s.profit > 0 ? "text-success" : "text-danger"}">${money.format(s.profit)}</td>
+        </tr>`
+    }).join("")
+    return `
+        <br>
+        <h6>By source</h6>
+        <table class="table table-sm table-dark">
+            <thead><th></th><th>Balance</th><th>Cost</th><th>Revenue</th><th>Fees</th><th>Profit</th></thead>
+            <tbody>${rows}</tbody>
+        </table>
+    `
+}
+
+// escapeHTML makes user text safe to put in markup and attribute values
+function escapeHTML(text) {
+    return String(text).replace(/[&<>"']/g, c => ({ '&': '&amp;', '<': '&lt;', '>': '&gt;', '"': '&quot;', "'": '&#39;' })[c])
+}
+
+// jsArgument is a string as a quoted argument in an inline handler
+function jsArgument(text) {
+    return escapeHTML(JSON.stringify(String(text)))
+}
+
+function manualSection(symbol) {
+    let rows = manualTransactions
+        .filter(t => t.base.toUpperCase() == symbol.toUpperCase())
+        .map(t => `<tr>
+            <td>${new Date(t.time).toLocaleDateString('en-us', { year: "numeric", month: "short", day: "numeric" })}</td>
+            <td>${t.is_buyer ? "Bought" : "Sold"} ${t.qty} at ${t.price} ${escapeHTML(t.quote)}</td>
+            <td><small class="text-muted">${escapeHTML(t.note || "")}</small></td>
+            <td>
+                <button class="btn btn-sm btn-secondary" type="button" onclick="editManual(${jsArgument(t.id)})">Edit</button>
+                <button class="btn btn-sm btn-danger" type="button" onclick="deleteManual(${jsArgument(t.id)}, ${jsArgument(symbol)})">Delete</button>
+            </td>
+        </tr>`).join("")
+    return `
+        <br>
+        <h6>Manual transactions</h6>
+        <table class="table table-sm table-dark"><tbody>${rows}</tbody></table>
+        <form id="manual-form" onsubmit="saveManual(event, ${jsArgument(symbol)})">
+            <input type="hidden" id="manual-id">
+            <div class="form-row">
+                <div class="col"><select id="manual-side" class="form-control form-control-sm">
+                    <option value="buy">Buy</option><option value="sell">Sell</option>
+                </select></div>
+                <div class="col"><input id="manual-qty" type="number" step="any" class="form-control form-control-sm" placeholder="Qty" required></div>
+                <div class="col"><input id="manual-price" type="number" step="any" class="form-control form-control-sm" placeholder="Price" required></div>
+                <div class="col"><input id="manual-quote" type="text" class="form-control form-control-sm" value="USDT" required></div>
+            </div>
+            <div class="form-row mt-1">
+                <div class="col"><input id="manual-time" type="date" class="form-control form-control-sm" required></div>
+                <div class="col"><input id="manual-note" type="text" class="form-control form-control-sm" placeholder="OTC, gift, lost..."></div>
+                <div class="col-auto"><button class="btn btn-sm btn-primary" type="submit">Save</button></div>
+            </div>
+            <small id="manual-status" class="text-light"></small>
+        </form>
+    `
+}
+
+function editManual(id) {
+    let t = manualTransactions.find(t => t.id == id)
+    if (t == undefined) {
+        return
+    }
+    document.getElementById("manual-id").value = t.id
+    document.getElementById("manual-side").value = t.is_buyer ? "buy" : "sell"
+    document.getElementById("manual-qty").value = t.qty
+    document.getElementById("manual-price").value = t.price
+    document.getElementById("manual-quote").value = t.quote
+    document.getElementById("manual-time").value = t.time.substring(0, 10)
+    document.getElementById("manual-note").value = t.note || ""
+}
+
+async function saveManual(event, symbol) {
+    event.preventDefault()
+    let id = document.getElementById("manual-id").value
+    let body = {
+        base: symbol,
+        quote: document.getElementById("manual-quote").value,
+        is_buyer: document.getElementById("manual-side").value == "buy",
+        qty: parseFloat(document.getElementById("manual-qty").value),
+        price: parseFloat(document.getElementById("manual-price").value),
+        time: new Date(document.getElementById("manual-time").value).toISOString(),
+        note: document.getElementById("manual-note").value
+    }
+    let response = await fetch(id == "" ? '/manual' : '/manual/' + id, {
+        method: id == "" ? 'POST' : 'PUT',
+        headers: {
+            'X-API-Key': document.getElementById("key").value,
+            'Content-Type': 'application/json'
+        },
+        body: JSON.stringify(body)
+    })
+    let result = await response.json()
+    if (result.error != undefined) {
+        document.getElementById("manual-status").className = "text-danger"
+        document.getElementById("manual-status").innerHTML = result.error
+        return
+    }
+    $("#exampleModal").modal("hide");
+    refresh(document.getElementById("key").value, true)
+}
+
+async function deleteManual(id, symbol) {
+    let response = await fetch('/manual/' + id, {
+        method: 'DELETE',
+        headers: {
+            'X-API-Key': document.getElementById("key").value,
+        }
+    })
+    let result = await response.json()
+    if (result.error != undefined) {
+        document.getElementById("manual-status").className = "text-danger"
+        document.getElementById("manual-status").innerHTML = result.error
+        return
+    }
+    $("#exampleModal").modal("hide");
+    refresh(document.getElementById("key").value, true)
 }
\ No newline at end of file
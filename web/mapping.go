@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/enzosv/binalysis/model"
+)
+
+// fetchMappings reads the built-in, server and user mappings from the same server as the report
+func fetchMappings(client *http.Client, key, latestURL string) ([]model.CoinMapping, error) {
+	u, err := url.Parse(latestURL)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = "/mappings"
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("X-API-Key", key)
+	req.Header.Add("Accept", "application/json")
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("mappings: %s", res.Status)
+	}
+	var data struct {
+		Mappings []model.CoinMapping `json:"mappings"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&data)
+	if err != nil {
+		return nil, err
+	}
+	return data.Mappings, nil
+}